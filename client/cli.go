@@ -5,8 +5,12 @@ import (
 	"goRedis/client/cli_core"
 	"net"
 	"os"
+	"strconv"
+	"time"
 )
 
+// usage: client host:port [--bigkeys | --memkeys | --hotkeys] [-i interval]
+
 func main() {
 	fmt.Println("Client start..")
 	if len(os.Args) < 2 {
@@ -20,6 +24,10 @@ func main() {
 		return
 	}
 	fmt.Printf("Connection to server %s success!\n", serverAddr)
+	if len(os.Args) > 2 {
+		runKeysMode(conn, os.Args[2:])
+		return
+	}
 	// 阻塞通道
 	stop := make(chan error)
 	go cli_core.StartReader(conn, stop)
@@ -33,3 +41,35 @@ func main() {
 		}
 	}
 }
+
+// runKeysMode
+// walk the keyspace with keyspace discovery mode and exit
+func runKeysMode(conn net.Conn, args []string) {
+	defer conn.Close()
+	mode := ""
+	var interval time.Duration = 0
+	for i := 0; i < len(args); i += 1 {
+		switch {
+		case cli_core.IsKeysMode(args[i]):
+			mode = args[i]
+		case args[i] == "-i" && i+1 < len(args):
+			seconds, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || seconds < 0 {
+				fmt.Printf("[ERROR] Invalid interval %s\n", args[i+1])
+				return
+			}
+			interval = time.Duration(seconds * float64(time.Second))
+			i += 1
+		default:
+			fmt.Printf("[ERROR] Unknown option %s\n", args[i])
+			return
+		}
+	}
+	if mode == "" {
+		fmt.Printf("[ERROR] One of %s, %s and %s is needed\n", cli_core.BigKeys, cli_core.MemKeys, cli_core.HotKeys)
+		return
+	}
+	if err := cli_core.FindKeys(conn, mode, interval); err != nil {
+		fmt.Printf("[ERROR] %s\n", err)
+	}
+}
@@ -0,0 +1,290 @@
+package cli_core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyspace discovery, walk the keyspace by KEYSTATS and report
+// --bigkeys : the key with most elements (bytes of strings) of every type
+// --memkeys : the key using most memory of every type
+// --hotkeys : the most frequently accessed keys, an LFU maxmemory policy must be selected
+
+const (
+	BigKeys string = "--bigkeys"
+	MemKeys string = "--memkeys"
+	HotKeys string = "--hotkeys"
+	// keys returned by a KEYSTATS call
+	keystatsCount int = 100
+	// hot keys reported
+	hotKeysReported int = 16
+)
+
+var (
+	typeOrder []string          = []string{"string", "list", "set", "hash", "zset"}
+	typeUnits map[string]string = map[string]string{
+		"string": "bytes",
+		"list":   "items",
+		"set":    "members",
+		"hash":   "fields",
+		"zset":   "members",
+	}
+)
+
+type keyStats struct {
+	key    string
+	typ    string
+	length int64
+	memory int64
+	freq   int64
+}
+
+type typeStats struct {
+	keys   int64
+	total  int64
+	bigKey *keyStats
+}
+
+// IsKeysMode whether arg selects a keyspace discovery mode
+func IsKeysMode(arg string) bool {
+	return arg == BigKeys || arg == MemKeys || arg == HotKeys
+}
+
+// FindKeys
+// walk the whole keyspace and print the report of mode, sleep interval between KEYSTATS calls
+func FindKeys(conn net.Conn, mode string, interval time.Duration) error {
+	reader := bufio.NewReader(conn)
+	types := make(map[string]*typeStats, 0)
+	hot := make([]*keyStats, 0)
+	var total int64 = 0
+	fmt.Printf("# Scanning the entire keyspace to find %s\n", modeTarget(mode))
+	fmt.Printf("# Use -i 0.1 to sleep 0.1 sec per %d keys\n\n", keystatsCount)
+	cursor := "0"
+	for {
+		reply, err := requestKeystats(conn, reader, cursor)
+		if err != nil {
+			return err
+		}
+		cursor = reply[0]
+		for i := 1; i+4 < len(reply); i += 5 {
+			stats := parseKeyStats(reply[i : i+5])
+			total += 1
+			if mode == HotKeys {
+				if stats.freq < 0 {
+					return errors.New("An LFU maxmemory policy is not selected, access frequency not tracked")
+				}
+				hot = addHotKey(hot, stats)
+				continue
+			}
+			if types[stats.typ] == nil {
+				types[stats.typ] = &typeStats{}
+			}
+			if ts := types[stats.typ]; ts.update(stats, mode) {
+				fmt.Printf("Biggest %-6s found so far '%s' with %s\n", stats.typ, stats.key, describe(stats, mode))
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	fmt.Printf("\n-------- summary -------\n\n")
+	fmt.Printf("Sampled %d keys in the keyspace!\n", total)
+	if mode == HotKeys {
+		for _, stats := range hot {
+			fmt.Printf("hot key found with counter: %d\tkeyname: %s\n", stats.freq, stats.key)
+		}
+		return nil
+	}
+	for _, typ := range typeOrder {
+		if ts := types[typ]; ts != nil {
+			fmt.Printf("Biggest %-6s found '%s' has %s\n", typ, ts.bigKey.key, describe(ts.bigKey, mode))
+		}
+	}
+	fmt.Println()
+	for _, typ := range typeOrder {
+		ts := types[typ]
+		if ts == nil {
+			ts = &typeStats{}
+		}
+		unit := typeUnits[typ]
+		if mode == MemKeys {
+			unit = "bytes"
+		}
+		avg := 0.0
+		if ts.keys > 0 {
+			avg = float64(ts.total) / float64(ts.keys)
+		}
+		fmt.Printf("%d %ss with %d %s (%.2f%% of keys, avg size %.2f)\n",
+			ts.keys, typ, ts.total, unit, percentage(ts.keys, total), avg)
+	}
+	return nil
+}
+
+// update
+// account a key, return whether it is the biggest one so far
+func (ts *typeStats) update(stats *keyStats, mode string) bool {
+	size := stats.size(mode)
+	ts.keys += 1
+	ts.total += size
+	if ts.bigKey == nil || size > ts.bigKey.size(mode) {
+		ts.bigKey = stats
+		return true
+	}
+	return false
+}
+
+func (stats *keyStats) size(mode string) int64 {
+	if mode == MemKeys {
+		return stats.memory
+	}
+	return stats.length
+}
+
+// addHotKey
+// keep the hottest keys in descending order of frequency
+func addHotKey(hot []*keyStats, stats *keyStats) []*keyStats {
+	index := sort.Search(len(hot), func(i int) bool {
+		return hot[i].freq < stats.freq
+	})
+	if index >= hotKeysReported {
+		return hot
+	}
+	hot = append(hot, nil)
+	copy(hot[index+1:], hot[index:])
+	hot[index] = stats
+	if len(hot) > hotKeysReported {
+		hot = hot[:hotKeysReported]
+	}
+	return hot
+}
+
+func modeTarget(mode string) string {
+	switch mode {
+	case MemKeys:
+		return "biggest keys (in bytes of memory)"
+	case HotKeys:
+		return "hot keys"
+	}
+	return "biggest keys"
+}
+
+func describe(stats *keyStats, mode string) string {
+	if mode == MemKeys {
+		return fmt.Sprintf("%d bytes", stats.memory)
+	}
+	return fmt.Sprintf("%d %s", stats.length, typeUnits[stats.typ])
+}
+
+func percentage(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+func parseKeyStats(fields []string) *keyStats {
+	length, _ := strconv.ParseInt(fields[2], 10, 64)
+	memory, _ := strconv.ParseInt(fields[3], 10, 64)
+	freq, _ := strconv.ParseInt(fields[4], 10, 64)
+	return &keyStats{
+		key:    fields[0],
+		typ:    fields[1],
+		length: length,
+		memory: memory,
+		freq:   freq,
+	}
+}
+
+// requestKeystats
+// send KEYSTATS cursor and read the reply, the first element is the next cursor
+func requestKeystats(conn net.Conn, reader *bufio.Reader, cursor string) ([]string, error) {
+	if err := sendCommand(conn, "KEYSTATS", cursor, "COUNT", strconv.Itoa(keystatsCount)); err != nil {
+		return nil, err
+	}
+	reply, err := readArray(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) == 0 || (len(reply)-1)%5 != 0 {
+		return nil, errors.New("Unexpected KEYSTATS reply")
+	}
+	return reply, nil
+}
+
+// sendCommand
+// write a command as a bulk request
+func sendCommand(w io.Writer, args ...string) error {
+	var builder strings.Builder
+	builder.WriteString("*" + strconv.Itoa(len(args)) + CRLF)
+	for _, arg := range args {
+		builder.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + arg + CRLF)
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// readArray
+// read a bulk array reply, welcome message and other status replies before it are skipped
+func readArray(reader *bufio.Reader) ([]string, error) {
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch typeMap[rune(line[0])] {
+		case ERROR:
+			return nil, errors.New(line[1:])
+		case BULKARR:
+			num, _ := strconv.Atoi(line[1:])
+			result := make([]string, 0, num)
+			for i := 0; i < num; i += 1 {
+				s, err := readBulkString(reader)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, s)
+			}
+			return result, nil
+		}
+	}
+}
+
+func readBulkString(reader *bufio.Reader) (string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return "", err
+	}
+	length, _ := strconv.Atoi(line[1:])
+	if length < 0 {
+		return NIL, nil
+	}
+	buffer := make([]byte, length+2)
+	if _, err = io.ReadFull(reader, buffer); err != nil {
+		return "", err
+	}
+	return string(buffer[:length]), nil
+}
+
+// readLine
+// a line without CRLF
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, CRLF)
+	if len(line) == 0 {
+		return "", errors.New("Empty reply")
+	}
+	return line, nil
+}
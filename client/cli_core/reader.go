@@ -11,6 +11,7 @@ const (
 	MaxMessageSize int    = 1024 << 16
 	CRLF           string = "\r\n"
 	SEPARATION     string = " "
+	NIL            string = "(nil)"
 )
 
 // sliding window
@@ -156,6 +157,12 @@ func resolveBulkStr(byteBuffer []byte, length int, pack *Pack) int {
 			pack.bulkLength = findNumber(byteBuffer, 1, index)
 			// CRLF
 			left = index + 2
+			if pack.bulkLength < 0 {
+				// nil
+				pack.output = append(pack.output, NIL)
+				pack.readyToOutput = true
+				return left
+			}
 		}
 	}
 	if pack.bulkLength != -1 {
@@ -181,10 +188,22 @@ func resolveBulkArr(byteBuffer []byte, length int, pack *Pack) int {
 	for len(pack.output) < pack.bulkNum {
 		if pack.bulkLength == -1 {
 			index := findCrlf(byteBuffer, left, length)
+			if index != -1 && byteBuffer[left] == '*' {
+				// nested array (e.g. reply of SCAN), its elements are output in place of it
+				pack.bulkNum += findNumber(byteBuffer, left+1, index) - 1
+				left = index + 2
+				continue
+			}
 			if index != -1 {
 				// $
 				pack.bulkLength = findNumber(byteBuffer, left+1, index)
 				left = index + 2
+				if pack.bulkLength < 0 {
+					// nil element
+					pack.output = append(pack.output, NIL)
+					pack.bulkLength = -1
+					continue
+				}
 			}
 		}
 		if pack.bulkLength != -1 {
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"goRedis/service"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// goredis-check-aof
+// validate an append only file by replaying it into an empty database, print key statistics
+// usage: goredis-check-aof [--fix] [--databases n] <aof file | manifest file>
+// for a manifest, the base file is loaded and incr files are replayed in order
+// with --fix, an AOF (or the last incr file) ending with an incomplete or corrupted command
+// is truncated to the end of the last complete command
+
+// offlineServer
+// service.Server used for replaying, server level commands are never written to AOF
+type offlineServer struct {
+	dbs []*Database
+	// database selected by the last SELECT
+	selected int
+}
+
+func (*offlineServer) Save() error                { return nil }
+func (*offlineServer) BgSave() error              { return nil }
+func (*offlineServer) BgRewriteAof() error        { return nil }
+func (*offlineServer) IncrDirty(delta int64)      {}
+func (*offlineServer) LastSave() int64            { return 0 }
+func (*offlineServer) Info(section string) string { return "" }
+func (*offlineServer) Import(path string) (int64, error) {
+	return 0, errors.New("IMPORT is not supported when checking AOF")
+}
+func (*offlineServer) MemoryStats() []string                     { return nil }
+func (*offlineServer) BeforeWriteKey(dbIndex int, key *DbObject) {}
+func (server *offlineServer) Databases() []*Database             { return server.dbs }
+func (server *offlineServer) SelectDb(index int) error {
+	if index < 0 || index >= len(server.dbs) {
+		return errors.New("DB index is out of range")
+	}
+	server.selected = index
+	return nil
+}
+
+// CommandTime
+// replayed commands are not written anywhere, so the time needs not be the same within a command
+func (offlineServer) CommandTime() int64 { return time.Now().UnixNano() }
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last complete command")
+	databases := flag.Int("databases", DefaultDatabases, "number of databases configured")
+	flag.Usage = func() {
+		fmt.Println("Usage: goredis-check-aof [--fix] [--databases n] <aof file | manifest file>")
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	path := flag.Arg(0)
+	// command handlers log every command
+	log.SetOutput(io.Discard)
+	if *databases <= 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	server := &offlineServer{dbs: make([]*Database, *databases)}
+	for index := range server.dbs {
+		server.dbs[index] = NewDatabase()
+	}
+	commands := 0
+	rejected := make(map[string]int)
+	replay := func(args []*DbObject) error {
+		commands += 1
+		if reply := service.Handle(args, server.dbs[server.selected], server); service.IsErrorReply(reply) {
+			rejected[strings.ToUpper(args[0].StrVal())] += 1
+		}
+		return nil
+	}
+	printStats := func() {
+		stats := persistence.NewKeyStats()
+		for index, db := range server.dbs {
+			db.ForEach(func(key, val *DbObject, expireTime int64) bool {
+				stats.Add(index, key, val, expireTime)
+				return true
+			})
+		}
+		fmt.Printf("commands: %d\n", commands)
+		names := make([]string, 0, len(rejected))
+		for name := range rejected {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("rejected %s: %d\n", name, rejected[name])
+		}
+		stats.Print(os.Stdout)
+	}
+
+	files := []string{path}
+	if strings.HasSuffix(path, persistence.AofManifestSuffix) {
+		// multi-part AOF: the base file then incr files in manifest order
+		dir := filepath.Dir(path)
+		manifest, err := persistence.LoadAofManifest(dir, strings.TrimSuffix(filepath.Base(path), persistence.AofManifestSuffix))
+		if err != nil {
+			fmt.Printf("[ERROR] Load manifest error:%s\n", err)
+			os.Exit(1)
+		}
+		if manifest.Base != nil {
+			basePath := filepath.Join(dir, manifest.Base.Name)
+			err = persistence.ReadSnapshotFile(basePath, func(dbIndex int, key, val *DbObject, expireTime int64) error {
+				if err := persistence.CheckDbIndex(dbIndex, len(server.dbs)); err != nil {
+					return err
+				}
+				return server.dbs[dbIndex].SetKeyObject(key, val, expireTime)
+			})
+			if err != nil {
+				fmt.Printf("[ERROR] Base file %s is invalid:%s, check it with goredis-check-snapshot\n", basePath, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Base file %s is valid\n", basePath)
+		}
+		files = files[:0]
+		for _, info := range manifest.Incrs {
+			files = append(files, filepath.Join(dir, info.Name))
+		}
+	}
+	for i, file := range files {
+		offset, size, err := checkAppendOnlyFile(file, replay)
+		if err == nil {
+			fmt.Printf("AOF %s is valid, %d bytes checked\n", file, offset)
+			continue
+		}
+		printStats()
+		if err == io.ErrUnexpectedEOF {
+			fmt.Printf("[ERROR] AOF %s is truncated, last complete command ends at offset %d\n", file, offset)
+		} else {
+			fmt.Printf("[ERROR] AOF %s is invalid after offset %d:%s\n", file, offset, err)
+		}
+		if offset < 0 {
+			os.Exit(1)
+		}
+		// commands after a broken incr file depend on the lost ones, only the last file can be fixed
+		if i != len(files)-1 {
+			fmt.Println("The file is not the last incr file and can not be fixed")
+			os.Exit(1)
+		}
+		if !*fix {
+			fmt.Println("Run with --fix to truncate the file to the last complete command")
+			os.Exit(1)
+		}
+		if err = persistence.TruncateAppendOnlyFile(file, offset); err != nil {
+			fmt.Printf("[ERROR] Truncate append only file error:%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("AOF %s truncated to %d bytes, %d bytes discarded\n", file, offset, size-offset)
+		return
+	}
+	printStats()
+}
+
+// checkAppendOnlyFile
+// replay a single AOF, return the end offset of the last complete command and file size
+// offset is -1 if the file can not be read
+func checkAppendOnlyFile(path string, replay func(args []*DbObject) error) (int64, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return -1, 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return -1, 0, err
+	}
+	offset, err := persistence.ReadAppendOnlyFile(file, replay)
+	return offset, info.Size(), err
+}
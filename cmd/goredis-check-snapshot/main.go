@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	. "goRedis/data_structure"
+	"goRedis/persistence"
+	"os"
+)
+
+// goredis-check-snapshot
+// validate structure and checksum of a snapshot file, print key statistics
+// usage: goredis-check-snapshot <snapshot file>
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Println("Usage: goredis-check-snapshot <snapshot file>")
+		os.Exit(1)
+	}
+	path := os.Args[1]
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("[ERROR] Open snapshot error:%s\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	stats := persistence.NewKeyStats()
+	offset, err := persistence.CheckSnapshot(bufio.NewReader(file), func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		stats.Add(dbIndex, key, val, expireTime)
+		return nil
+	})
+	stats.Print(os.Stdout)
+	if err != nil {
+		fmt.Printf("[ERROR] Snapshot %s is invalid at offset %d:%s\n", path, offset, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot %s is valid, %d bytes checked\n", path, offset)
+}
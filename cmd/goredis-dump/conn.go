@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	"goRedis/persistence"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// minimal RESP connection to the server
+
+type conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dial(addr string) (*conn, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	client := &conn{
+		conn:   c,
+		reader: bufio.NewReader(c),
+		writer: bufio.NewWriter(c),
+	}
+	// the server greets every new connection
+	if _, err = client.receive(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// write
+// buffer raw commands, flushed by flush or do
+func (c *conn) write(buf []byte) error {
+	_, err := c.writer.Write(buf)
+	return err
+}
+
+func (c *conn) flush() error {
+	return c.writer.Flush()
+}
+
+// do
+// send a command and receive its reply
+func (c *conn) do(args ...string) (string, error) {
+	objs := make([]*DbObject, 0, len(args))
+	for _, arg := range args {
+		objs = append(objs, NewStr(arg))
+	}
+	if err := c.write(persistence.EncodeCommand(objs)); err != nil {
+		return "", err
+	}
+	if err := c.flush(); err != nil {
+		return "", err
+	}
+	return c.receive()
+}
+
+// receive
+// read a reply, error reply is returned as replyError
+func (c *conn) receive() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("invalid reply %q", line)
+	}
+	content := line[1 : len(line)-2]
+	switch line[0] {
+	case '+', ':':
+		return content, nil
+	case '-':
+		return "", replyError{errors.New(content)}
+	case '$':
+		length, err := strconv.Atoi(content)
+		if err != nil || length < 0 {
+			return "", fmt.Errorf("invalid reply %q", line)
+		}
+		buf := make([]byte, length+2)
+		if _, err = io.ReadFull(c.reader, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:length]), nil
+	}
+	return "", fmt.Errorf("unsupported reply %q", line)
+}
+
+func (c *conn) close() error {
+	return c.conn.Close()
+}
+
+// replyError
+// error replied by the server, the connection is still usable
+type replyError struct {
+	error
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	. "goRedis/data_structure"
+	"goRedis/persistence"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// goredis-dump
+// export every key of a server (or a snapshot file) as newline-delimited json or csv,
+// and load such a file back into a server
+// usage:
+//   goredis-dump -server <host:port> -snapshot <file> [-format json|csv] [-out file]   export from server
+//   goredis-dump -snapshot <file> [-format json|csv] [-out file]                       export from snapshot or Redis RDB file
+//   goredis-dump -server <host:port> -load <file> [-format json|csv]                   load into server
+// a server is exported from the snapshot written by its BGSAVE, -snapshot is the snapshot file of
+// the server (dbfilename in dir of its config), so the export never blocks the server
+
+const (
+	// commands sent before waiting for their replies when loading
+	pipelineSize int = 1000
+	// bytes of commands sent before waiting for their replies,
+	// the server disconnects a client whose unprocessed queries exceed maxQueryLength
+	pipelineBytes int = 8 << 10
+	// error replies printed when loading
+	maxPrintedErrors int = 10
+	// interval of checking whether the BGSAVE of an exported server finishes
+	bgSavePollInterval time.Duration = 100 * time.Millisecond
+)
+
+func main() {
+	server := flag.String("server", "", "server address")
+	snapshot := flag.String("snapshot", "", "snapshot file to export (of the server with -server)")
+	load := flag.String("load", "", "dataset file to load into server")
+	format := flag.String("format", "json", "dataset format, json or csv")
+	out := flag.String("out", "", "output file of export (default stdout)")
+	flag.Parse()
+
+	var err error
+	switch {
+	case *load != "" && *server != "":
+		err = loadDataset(*server, *load, *format)
+	case *load == "" && *snapshot != "":
+		if *server != "" {
+			err = saveServer(*server, *snapshot)
+		}
+		if err == nil {
+			err = exportDataset(*snapshot, *format, *out)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// exportDataset
+// keys of the snapshot file are decoded in order
+func exportDataset(snapshot, format, out string) error {
+	output := os.Stdout
+	if out != "" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	buffered := bufio.NewWriter(output)
+	writer, err := newRecordWriter(format, buffered)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	keys := 0
+	handler := func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		r, err := newRecord(dbIndex, key, val, expireTime, now)
+		if err != nil {
+			return err
+		}
+		keys += 1
+		return writer.Write(r)
+	}
+	if err = persistence.ReadSnapshotFile(snapshot, handler); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d keys exported\n", keys)
+	return nil
+}
+
+// saveServer
+// take a snapshot of a running server with BGSAVE and wait until it is written to snapshot,
+// the server saves in background, so its event loop is never blocked by the export
+func saveServer(server, snapshot string) error {
+	c, err := dial(server)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	start := time.Now()
+	for {
+		_, err = c.do("BGSAVE")
+		if _, ok := err.(replyError); !ok || !strings.Contains(err.Error(), "in progress") {
+			break
+		}
+		// a background save started before may miss recent changes, save again after it
+		if err = waitBgSave(c); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err = waitBgSave(c); err != nil {
+		return err
+	}
+	info, err := os.Stat(snapshot)
+	if err != nil {
+		return err
+	}
+	// the snapshot of server is written after BGSAVE starts
+	if info.ModTime().Before(start.Truncate(time.Second)) {
+		return fmt.Errorf("%s is not written by the BGSAVE of %s, is it the snapshot file of the server?", snapshot, server)
+	}
+	return nil
+}
+
+// waitBgSave
+// poll INFO persistence until the background save finishes, fail if it failed
+func waitBgSave(c *conn) error {
+	for {
+		info, err := c.do("INFO", "persistence")
+		if err != nil {
+			return err
+		}
+		fields := make(map[string]string)
+		for _, line := range strings.Split(info, "\r\n") {
+			if index := strings.Index(line, ":"); index >= 0 {
+				fields[line[:index]] = line[index+1:]
+			}
+		}
+		if fields["rdb_bgsave_in_progress"] == "0" {
+			if fields["rdb_last_bgsave_status"] != "ok" {
+				return errors.New("background save of the server failed")
+			}
+			return nil
+		}
+		time.Sleep(bgSavePollInterval)
+	}
+}
+
+// loadDataset
+// every key is replaced by DEL and the commands which rebuild it
+func loadDataset(server, path, format string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := newRecordReader(format, bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	c, err := dial(server)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	// whether error reply of a pending command is ignored (DEL of a key not exists)
+	pending := make([]bool, 0, pipelineSize)
+	failed, keys, pendingBytes := 0, 0, 0
+	receive := func() error {
+		if err := c.flush(); err != nil {
+			return err
+		}
+		for _, ignored := range pending {
+			if _, err := c.receive(); err != nil {
+				if _, ok := err.(replyError); !ok {
+					return err
+				}
+				if ignored {
+					continue
+				}
+				if failed < maxPrintedErrors {
+					fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+				}
+				failed += 1
+			}
+		}
+		pending = pending[:0]
+		pendingBytes = 0
+		return nil
+	}
+	write := func(args []*DbObject, ignored bool) error {
+		if pendingBytes >= pipelineBytes {
+			if err := receive(); err != nil {
+				return err
+			}
+		}
+		buf := persistence.EncodeCommand(args)
+		pending = append(pending, ignored)
+		pendingBytes += len(buf)
+		return c.write(buf)
+	}
+	send := func(args []*DbObject) error {
+		return write(args, false)
+	}
+	buffer := &bytes.Buffer{}
+	encoder := persistence.NewAofRewriteEncoder(buffer)
+	// a new connection uses db 0
+	selected := 0
+	for {
+		r, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if r.Db != selected {
+			// keys of the previous db are loaded before switching, a failed SELECT stops loading
+			if err = receive(); err != nil {
+				return err
+			}
+			if err = c.write(persistence.EncodeCommand([]*DbObject{NewStr("SELECT"), NewObjectByInt(int64(r.Db))})); err != nil {
+				return err
+			}
+			if err = c.flush(); err != nil {
+				return err
+			}
+			if _, err = c.receive(); err != nil {
+				return fmt.Errorf("key %s of db %d can not be loaded: %w", r.Key, r.Db, err)
+			}
+			selected = r.Db
+		}
+		key, val, expireTime, err := r.object(time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		buffer.Reset()
+		if err = encoder.WriteEntry(key, val, expireTime); err != nil {
+			return err
+		}
+		if err = encoder.Flush(); err != nil {
+			return err
+		}
+		if err = write([]*DbObject{NewStr("DEL"), key}, true); err != nil {
+			return err
+		}
+		if _, err = persistence.ReadAppendOnlyFile(buffer, send); err != nil {
+			return err
+		}
+		keys += 1
+		if len(pending) >= pipelineSize {
+			if err = receive(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = receive(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d keys loaded, %d commands failed\n", keys, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d commands failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"io"
+	"strconv"
+)
+
+// dataset record, one key per line (json) or row (csv)
+// value: string -> string, list / set -> array, hash -> object, zset -> [{"member", "score"}]
+// csv columns: db, key, type, ttl, value (json encoded except string values)
+// strings are expected to be valid utf-8
+
+type record struct {
+	Db    int             `json:"db"`
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Ttl   int64           `json:"ttl"` // ms, -1 if the key never expires
+	Value json.RawMessage `json:"value"`
+}
+
+type zsetMember struct {
+	Member string `json:"member"`
+	Score  int64  `json:"score"`
+}
+
+var csvHeader []string = []string{"db", "key", "type", "ttl", "value"}
+
+// newRecord
+// now is unix nano when exporting, used to compute ttl
+func newRecord(dbIndex int, key, val *DbObject, expireTime int64, now int64) (*record, error) {
+	var value interface{}
+	switch val.Type {
+	case STR:
+		value = val.StrVal()
+	case LINKDLIST:
+		value = strValues(val.Val.(*LinkedList).Members())
+	case SET:
+		value = strValues(val.Val.(*Set).Members())
+	case HASH:
+		fields := make(map[string]string)
+		val.Val.(*Hash).ForEach(func(field, value *DbObject) bool {
+			fields[field.StrVal()] = value.StrVal()
+			return true
+		})
+		value = fields
+	case ZSET:
+		scores, members := val.Val.(*Zset).Members()
+		zset := make([]zsetMember, 0, len(members))
+		for i, member := range members {
+			score, _ := scores[i].IntVal()
+			zset = append(zset, zsetMember{Member: member.StrVal(), Score: score})
+		}
+		value = zset
+	default:
+		return nil, fmt.Errorf("unsupported value type of key %s", key.StrVal())
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var ttl int64 = -1
+	if expireTime >= 0 {
+		ttl = (expireTime - now) / 1000000
+		if ttl < 0 {
+			ttl = 0
+		}
+	}
+	return &record{
+		Db:    dbIndex,
+		Key:   key.StrVal(),
+		Type:  persistence.TypeName(val.Type),
+		Ttl:   ttl,
+		Value: data,
+	}, nil
+}
+
+// object
+// decode value of the record, return key, value and expire time (unix nano, -1 if never expires)
+func (r *record) object(now int64) (*DbObject, *DbObject, int64, error) {
+	var val *DbObject
+	var err error
+	switch r.Type {
+	case "string":
+		var value string
+		if err = json.Unmarshal(r.Value, &value); err == nil {
+			val = NewStr(value)
+		}
+	case "list":
+		var values []string
+		if err = json.Unmarshal(r.Value, &values); err == nil {
+			list := NewLinkedList()
+			for _, value := range values {
+				list.Rpush(NewStr(value))
+			}
+			val = NewObject(LINKDLIST, list)
+		}
+	case "set":
+		var values []string
+		if err = json.Unmarshal(r.Value, &values); err == nil {
+			set := NewSet()
+			for _, value := range values {
+				set.Add(NewStr(value))
+			}
+			val = NewObject(SET, set)
+		}
+	case "hash":
+		var fields map[string]string
+		if err = json.Unmarshal(r.Value, &fields); err == nil {
+			hash := NewHash()
+			for field, value := range fields {
+				hash.Set(NewStr(field), NewStr(value))
+			}
+			val = NewObject(HASH, hash)
+		}
+	case "zset":
+		var members []zsetMember
+		if err = json.Unmarshal(r.Value, &members); err == nil {
+			zset := NewZset()
+			for _, member := range members {
+				if err = zset.AddMember(NewStr(member.Member), member.Score); err != nil {
+					break
+				}
+			}
+			val = NewObject(ZSET, zset)
+		}
+	default:
+		err = fmt.Errorf("unknown type %s", r.Type)
+	}
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid value of key %s: %s", r.Key, err)
+	}
+	var expireTime int64 = -1
+	if r.Ttl >= 0 {
+		expireTime = now + r.Ttl*1000000
+	}
+	return NewStr(r.Key), val, expireTime, nil
+}
+
+func strValues(objs []*DbObject) []string {
+	values := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		values = append(values, obj.StrVal())
+	}
+	return values
+}
+
+// record writers and readers
+
+type recordWriter interface {
+	Write(r *record) error
+	Flush() error
+}
+
+type recordReader interface {
+	// Read return io.EOF after the last record
+	Read() (*record, error)
+}
+
+type jsonRecordWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonRecordWriter) Write(r *record) error {
+	return w.encoder.Encode(r)
+}
+
+func (w *jsonRecordWriter) Flush() error {
+	return nil
+}
+
+type jsonRecordReader struct {
+	decoder *json.Decoder
+}
+
+func (r *jsonRecordReader) Read() (*record, error) {
+	rec := &record{}
+	if err := r.decoder.Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+type csvRecordWriter struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvRecordWriter) Write(r *record) error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if err := w.writer.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	value := string(r.Value)
+	if r.Type == "string" {
+		json.Unmarshal(r.Value, &value)
+	}
+	return w.writer.Write([]string{strconv.Itoa(r.Db), r.Key, r.Type, strconv.FormatInt(r.Ttl, 10), value})
+}
+
+func (w *csvRecordWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type csvRecordReader struct {
+	reader     *csv.Reader
+	readHeader bool
+}
+
+func (r *csvRecordReader) Read() (*record, error) {
+	if !r.readHeader {
+		r.readHeader = true
+		if _, err := r.reader.Read(); err != nil {
+			return nil, err
+		}
+	}
+	row, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	dbIndex, err := strconv.Atoi(row[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid db of key %s", row[1])
+	}
+	ttl, err := strconv.ParseInt(row[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ttl of key %s", row[1])
+	}
+	value := []byte(row[4])
+	if row[2] == "string" {
+		value, _ = json.Marshal(row[4])
+	}
+	return &record{Db: dbIndex, Key: row[1], Type: row[2], Ttl: ttl, Value: value}, nil
+}
+
+func newRecordWriter(format string, w io.Writer) (recordWriter, error) {
+	switch format {
+	case "json":
+		return &jsonRecordWriter{encoder: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvRecordWriter{writer: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+func newRecordReader(format string, r io.Reader) (recordReader, error) {
+	switch format {
+	case "json":
+		return &jsonRecordReader{decoder: json.NewDecoder(r)}, nil
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvHeader)
+		return &csvRecordReader{reader: reader}, nil
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"log"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -46,9 +47,9 @@ type AeFileEvent struct {
 type AeTimeEvent struct {
 	id   int
 	mask TeType
-	// 下一次执行时间
+	// 下一次执行时间 (ms)
 	nextExecTime int64
-	// 执行间隔（仅NORMAL事件）
+	// 执行间隔（仅NORMAL事件, ms）
 	interval int64
 	proc     AeTimeProc
 	// 链表方式存储
@@ -63,8 +64,12 @@ type AeLoop struct {
 	// fileEvent文件描述符 -> 即Epoll对象的文件描述符
 	fileEventFd     int
 	timeEventNextId int
-	stop            bool
-	server          *Server
+	// set by AeStop, may be accessed by other goroutines
+	stop int32
+	// set when AeMain starts, stopped is closed when it returns
+	running int32
+	stopped chan struct{}
+	server  *Server
 }
 
 func getAeFileEventKey(fd int, mask FeType) int {
@@ -142,7 +147,7 @@ func (loop *AeLoop) RemoveFileEvent(fd int, mask FeType) {
 
 func getTime() int64 {
 	// ms
-	return time.Now().UnixNano()
+	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
 // getNextExecTime 根据TimeEvent确定EPOLL_WAIT等待时间
@@ -260,19 +265,36 @@ func (loop *AeLoop) AeProcess(fileEvents []*AeFileEvent, timeEvents []*AeTimeEve
 // AeWait <-> AeProcess
 func (loop *AeLoop) AeMain() {
 	log.Printf("[AE LOOP AEMAIN] Aeloop is running...\n")
-	for !loop.stop {
+	atomic.StoreInt32(&loop.running, 1)
+	defer close(loop.stopped)
+	for atomic.LoadInt32(&loop.stop) == 0 {
 		fileEvents, timeEvents, err := loop.AeWait()
 		if err != nil {
 			log.Printf("[AE LOOP AEMAIN ERROR] AeWait error, err: %s\n", err)
-			loop.stop = true
+			atomic.StoreInt32(&loop.stop, 1)
 			return
 		}
-		if !loop.stop {
+		if atomic.LoadInt32(&loop.stop) == 0 {
 			loop.AeProcess(fileEvents, timeEvents)
 		}
 	}
 }
 
+// AeStop 通知AeMain退出, 可以在其他goroutine调用
+// AeMain正在运行时等待它退出(最多一次AeWait的时间), 返回后事件循环不会再处理任何事件
+func (loop *AeLoop) AeStop() {
+	atomic.StoreInt32(&loop.stop, 1)
+	// AeMain started after stop is set returns before processing any event
+	if atomic.LoadInt32(&loop.running) == 1 {
+		<-loop.stopped
+	}
+}
+
+// close 关闭EPOLL对象文件描述符, 事件循环停止后调用
+func (loop *AeLoop) close() error {
+	return unix.Close(loop.fileEventFd)
+}
+
 // AeLoopCreate 创建一个AeLoop
 func AeLoopCreate(server *Server) (*AeLoop, error) {
 	// 系统调用：创建EPOLL对象文件描述符
@@ -285,7 +307,7 @@ func AeLoopCreate(server *Server) (*AeLoop, error) {
 		AeTimeEvents:    nil,
 		timeEventNextId: 1,
 		fileEventFd:     epollFd,
-		stop:            false,
+		stopped:         make(chan struct{}),
 		server:          server,
 	}
 	return loop, nil
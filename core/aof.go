@@ -0,0 +1,365 @@
+package core
+
+import (
+	"errors"
+	. "goRedis/data_structure"
+	"goRedis/persistence"
+	"goRedis/service"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Server append only file core lib
+
+var (
+	ErrorAppendOnlyOff        error = errors.New("Append only mode is off")
+	ErrorAofRewriteInProgress error = errors.New("Background append only file rewriting already in progress")
+)
+
+// aofRewriteState 正在进行的AOF重写
+type aofRewriteState struct {
+	// 增量快照会话, 生成重写开始时数据库的快照作为新的base文件
+	session *persistence.SnapshotSession
+	// 快照会话写入的临时文件
+	tempPath string
+	// 重写开始时新建的incr文件, 之前的文件在重写完成后被新的base取代
+	incr  *persistence.AofFileInfo
+	start time.Time
+}
+
+// appendOnlyDir 多文件AOF所在目录
+func (server *Server) appendOnlyDir() string {
+	return filepath.Join(server.Dir, server.AppendDirName)
+}
+
+// legacyAppendOnlyPath 单文件AOF的路径, 启动时升级为多文件AOF
+func (server *Server) legacyAppendOnlyPath() string {
+	return filepath.Join(server.Dir, server.AppendFileName)
+}
+
+// loadAppendOnly 加载manifest中的base文件, 再通过命令路由重放incr文件中的命令
+// manifest不存在时加载单文件AOF
+func (server *Server) loadAppendOnly() error {
+	start := time.Now()
+	keys, commands, failed := 0, 0, 0
+	// SELECT in AOF changes the database of the following commands
+	context := &commandContext{Server: server, db: 0}
+	replay := func(args []*DbObject) error {
+		commands += 1
+		context.beginCommand()
+		if msg := service.Handle(args, context.database(), context); service.IsErrorReply(msg) {
+			failed += 1
+		}
+		return nil
+	}
+	manifest, err := persistence.LoadAofManifest(server.appendOnlyDir(), server.AppendFileName)
+	if err == nil {
+		err = persistence.LoadAofManifestFiles(server.appendOnlyDir(), manifest, func(dbIndex int, key, val *DbObject, expireTime int64) error {
+			if err := persistence.CheckDbIndex(dbIndex, len(server.Dbs)); err != nil {
+				return err
+			}
+			keys += 1
+			return server.Dbs[dbIndex].SetKeyObject(key, val, expireTime)
+		}, replay)
+		if last := manifest.LastIncr(); err != nil && last != nil {
+			err = server.truncateAppendOnly(err, filepath.Join(server.appendOnlyDir(), last.Name))
+		}
+		if err != nil {
+			log.Printf("[LOAD DATA ERROR] Load append only files error, err = %s\n", err)
+			return err
+		}
+		server.aofManifest = manifest
+		log.Printf("[LOAD DATA] Load append only files success, %d keys loaded from base, %d commands replayed (%d failed), cost %s\n", keys, commands, failed, time.Since(start))
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("[LOAD DATA ERROR] Load append only manifest error, err = %s\n", err)
+		return err
+	}
+	err = persistence.LoadAppendOnlyFile(server.legacyAppendOnlyPath(), replay)
+	if err != nil {
+		err = server.truncateAppendOnly(err, server.legacyAppendOnlyPath())
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("[LOAD DATA] Append only file does not exist, start with empty database\n")
+			return nil
+		}
+		log.Printf("[LOAD DATA ERROR] Load append only file error, err = %s\n", err)
+		return err
+	}
+	log.Printf("[LOAD DATA] Load append only file success, %d commands replayed (%d failed), cost %s\n", commands, failed, time.Since(start))
+	return nil
+}
+
+// truncateAppendOnly 最后一个AOF文件以不完整的命令结尾(写入时崩溃)时截断不完整的命令, 之前的命令已经重放
+// 其他文件不完整或未开启AofLoadTruncated时返回原错误
+func (server *Server) truncateAppendOnly(err error, last string) error {
+	var truncated *persistence.AofTruncatedError
+	if !server.AofLoadTruncated || !errors.As(err, &truncated) || truncated.Path != last {
+		return err
+	}
+	if err = persistence.TruncateAppendOnlyFile(truncated.Path, truncated.Offset); err != nil {
+		return err
+	}
+	log.Printf("[LOAD DATA WARNING] %s, the incomplete command is truncated\n", truncated)
+	return nil
+}
+
+// startAppendOnly 打开最后一个incr文件, everysec策略时注册fsync时间事件
+// 没有manifest时(首次开启或单文件AOF)以当前数据库作为base创建manifest, 然后删除单文件AOF
+func (server *Server) startAppendOnly() error {
+	dir := server.appendOnlyDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("[APPEND ONLY ERROR] Create append only directory error, err = %s\n", err)
+		return err
+	}
+	manifest := server.aofManifest
+	upgrade := manifest == nil
+	if upgrade {
+		manifest = persistence.NewAofManifest(server.AppendFileName)
+		if server.datasetSize() > 0 {
+			base := manifest.NextBase()
+			if err := persistence.SaveSnapshot(filepath.Join(dir, base.Name), server.Dbs); err != nil {
+				log.Printf("[APPEND ONLY ERROR] Save append only base file error, err = %s\n", err)
+				return err
+			}
+			manifest.Base = base
+		}
+	}
+	incr := manifest.LastIncr()
+	if incr == nil {
+		incr = manifest.NextIncr()
+		manifest.Incrs = append(manifest.Incrs, incr)
+	}
+	aof, err := persistence.OpenAppendOnlyFile(filepath.Join(dir, incr.Name), server.AppendFsync)
+	if err != nil {
+		log.Printf("[APPEND ONLY ERROR] Open append only file error, err = %s\n", err)
+		return err
+	}
+	if err = manifest.Save(dir); err != nil {
+		aof.Close()
+		log.Printf("[APPEND ONLY ERROR] Save append only manifest error, err = %s\n", err)
+		return err
+	}
+	if upgrade {
+		if err = os.Remove(server.legacyAppendOnlyPath()); err == nil {
+			log.Printf("[APPEND ONLY] Append only file %s is upgraded to %s\n", server.legacyAppendOnlyPath(), dir)
+		}
+	}
+	server.aof = aof
+	server.aofSelectedDb = -1
+	server.aofManifest = manifest
+	server.updateAofSizes()
+	server.aofRewriteBaseSize = server.aofCurrentSize()
+	if server.AppendFsync == persistence.FsyncEverySec {
+		server.Loop.AddTimeEvent(NORMAL, 1000, aofFsyncHandler, nil)
+	}
+	return nil
+}
+
+// updateAofSizes 统计base文件和除当前incr外所有文件的大小
+func (server *Server) updateAofSizes() {
+	dir := server.appendOnlyDir()
+	server.aofBaseSize = 0
+	server.aofHistorySize = 0
+	for _, info := range server.aofManifest.Files() {
+		if info == server.aofManifest.LastIncr() {
+			continue
+		}
+		stat, err := os.Stat(filepath.Join(dir, info.Name))
+		if err != nil {
+			continue
+		}
+		if info.Type == persistence.AofBaseType {
+			server.aofBaseSize = stat.Size()
+		}
+		server.aofHistorySize += stat.Size()
+	}
+}
+
+// aofCurrentSize 所有AOF文件的大小
+func (server *Server) aofCurrentSize() int64 {
+	return server.aofHistorySize + server.aof.Size()
+}
+
+// feedAppendOnly 将数据库dbIndex中执行成功的写命令追加到AOF
+// 与上一条命令的数据库不同时先追加SELECT
+func (server *Server) feedAppendOnly(dbIndex int, args []*DbObject) {
+	if server.aof == nil || len(args) == 0 {
+		return
+	}
+	if dbIndex != server.aofSelectedDb {
+		server.writeAppendOnly(persistence.EncodeCommand([]*DbObject{NewStr("SELECT"), NewObjectByInt(int64(dbIndex))}))
+		server.aofSelectedDb = dbIndex
+	}
+	server.writeAppendOnly(persistence.EncodeCommand(args))
+}
+
+// writeAppendOnly 追加完整的命令到当前incr文件
+func (server *Server) writeAppendOnly(buf []byte) {
+	if server.aof == nil || len(buf) == 0 {
+		return
+	}
+	if err := server.aof.Write(buf); err != nil {
+		log.Printf("[APPEND ONLY ERROR] Append command to append only file error, err = %s\n", err)
+	}
+}
+
+// aofFsyncHandler everysec策略下每秒fsync一次AOF
+func aofFsyncHandler(loop *AeLoop, id int, extra interface{}) {
+	server := loop.server
+	if server.aof == nil {
+		return
+	}
+	if err := server.aof.Fsync(); err != nil {
+		log.Printf("[APPEND ONLY ERROR] Fsync append only file error, err = %s\n", err)
+	}
+}
+
+// aof rewrite
+
+// BgRewriteAof 后台重写AOF(不fork)
+// 先新建incr文件接收之后的写命令并更新manifest, 再由时间事件驱动增量快照会话生成新的base文件
+// 重写完成后原子更新manifest, 旧的base和incr文件被删除
+func (server *Server) BgRewriteAof() error {
+	if server.aof == nil {
+		return ErrorAppendOnlyOff
+	}
+	if server.aofRewrite != nil {
+		return ErrorAofRewriteInProgress
+	}
+	dir := server.appendOnlyDir()
+	file, err := os.CreateTemp(dir, "temp-rewriteaof-*"+persistence.AofBaseSuffix)
+	if err != nil {
+		return err
+	}
+	incr, err := server.openNextIncr()
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	session, err := persistence.NewSnapshotSession(server.Dbs, file, persistence.NewSnapshotFileEncoder)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	state := &aofRewriteState{
+		session:  session,
+		tempPath: file.Name(),
+		incr:     incr,
+		start:    time.Now(),
+	}
+	server.aofRewrite = state
+	server.Loop.AddTimeEvent(NORMAL, SnapshotStepInterval, aofRewriteStepHandler, state)
+	log.Printf("[BGREWRITEAOF] Background append only file rewriting started\n")
+	return nil
+}
+
+// openNextIncr 新建incr文件并加入manifest, 之后的写命令追加到新文件
+func (server *Server) openNextIncr() (*persistence.AofFileInfo, error) {
+	dir := server.appendOnlyDir()
+	manifest := server.aofManifest.Copy()
+	incr := manifest.NextIncr()
+	aof, err := persistence.OpenAppendOnlyFile(filepath.Join(dir, incr.Name), server.AppendFsync)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Incrs = append(manifest.Incrs, incr)
+	if err = manifest.Save(dir); err != nil {
+		aof.Close()
+		os.Remove(aof.Path())
+		return nil, err
+	}
+	if err = server.aof.Close(); err != nil {
+		log.Printf("[APPEND ONLY ERROR] Close append only file error, err = %s\n", err)
+	}
+	server.aofHistorySize += server.aof.Size()
+	server.aof = aof
+	// the first command of the new incr file selects its database again
+	server.aofSelectedDb = -1
+	server.aofManifest = manifest
+	return incr, nil
+}
+
+// aofRewriteStepHandler AOF重写时间事件, 推进快照会话, 完成后更新manifest
+func aofRewriteStepHandler(loop *AeLoop, id int, extra interface{}) {
+	server := loop.server
+	state := extra.(*aofRewriteState)
+	state.session.Step(SnapshotStepBudget)
+	select {
+	case err := <-state.session.Done():
+		loop.RemoveTimeEvent(id)
+		server.aofRewrite = nil
+		if err == nil {
+			err = server.finishAofRewrite(state)
+		}
+		if err != nil {
+			os.Remove(state.tempPath)
+			log.Printf("[BGREWRITEAOF ERROR] Background append only file rewriting error, err = %s\n", err)
+			return
+		}
+		log.Printf("[BGREWRITEAOF] Background append only file rewriting success, %d keys saved, cost %s\n", state.session.Keys(), time.Since(state.start))
+	default:
+	}
+}
+
+// finishAofRewrite 新的base取代旧的base和重写开始前的incr文件
+// manifest更新之前旧文件一直有效
+func (server *Server) finishAofRewrite(state *aofRewriteState) error {
+	dir := server.appendOnlyDir()
+	manifest := server.aofManifest.Copy()
+	base := manifest.NextBase()
+	if err := persistence.RenameFile(state.tempPath, filepath.Join(dir, base.Name)); err != nil {
+		os.Remove(filepath.Join(dir, base.Name))
+		return err
+	}
+	history := make([]*persistence.AofFileInfo, 0)
+	if manifest.Base != nil {
+		history = append(history, manifest.Base)
+	}
+	for len(manifest.Incrs) > 0 && manifest.Incrs[0].Seq < state.incr.Seq {
+		history = append(history, manifest.Incrs[0])
+		manifest.Incrs = manifest.Incrs[1:]
+	}
+	manifest.Base = base
+	if err := manifest.Save(dir); err != nil {
+		os.Remove(filepath.Join(dir, base.Name))
+		return err
+	}
+	server.aofManifest = manifest
+	for _, info := range history {
+		if err := os.Remove(filepath.Join(dir, info.Name)); err != nil {
+			log.Printf("[BGREWRITEAOF ERROR] Remove history file %s error, err = %s\n", info.Name, err)
+		}
+	}
+	server.updateAofSizes()
+	server.aofRewriteBaseSize = server.aofCurrentSize()
+	return nil
+}
+
+// rewriteAofIfNeeded AOF增长超过阈值时自动重写
+func (server *Server) rewriteAofIfNeeded() {
+	if server.aof == nil || server.aofRewrite != nil || server.AutoAofRewritePercentage <= 0 {
+		return
+	}
+	size := server.aofCurrentSize()
+	if size < server.AutoAofRewriteMinSize {
+		return
+	}
+	base := server.aofRewriteBaseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	if growth >= server.AutoAofRewritePercentage {
+		log.Printf("[BGREWRITEAOF] Starting automatic rewriting of append only file on %d%% growth\n", growth)
+		if err := server.BgRewriteAof(); err != nil {
+			log.Printf("[BGREWRITEAOF ERROR] Automatic rewriting error, err = %s\n", err)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package core
 
 import (
+	"errors"
 	. "goRedis/data_structure"
+	. "goRedis/db"
 	"goRedis/net"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Database Client core library
@@ -15,6 +18,10 @@ const (
 	IoBufferSize int = 1024 * 16
 )
 
+var (
+	ErrorDbIndexOutOfRange error = errors.New("DB index is out of range")
+)
+
 type Client struct {
 	// socket
 	fd int
@@ -32,6 +39,8 @@ type Client struct {
 	bulkLength int
 	// Server
 	server *Server
+	// server seen by commands, with the selected database
+	context *commandContext
 	// isClosed
 	isClosed bool
 	// 是否有读到一半没有读完的请求
@@ -78,6 +87,65 @@ func (client *Client) AddReplyStr(s string) {
 	client.AddReply(obj)
 }
 
+// memoryUsage
+// memory used by query buffer, args and replies not sent
+func (client *Client) memoryUsage() int64 {
+	size := int64(cap(client.queryBuffer)) + client.reply.MemoryUsage()
+	for _, arg := range client.args {
+		size += StrObjectSize(arg)
+	}
+	client.reply.ForEach(func(rep *DbObject) bool {
+		size += StrObjectSize(rep)
+		return true
+	})
+	return size
+}
+
+// commandContext 命令执行时的Server, 记录当前选择的数据库
+// 每个client一个, AOF加载时也用它重放SELECT
+type commandContext struct {
+	*Server
+	// index of the selected database
+	db int
+	// unix nano when the command being processed started, truncated to whole milliseconds (the
+	// precision of PXAT and PEXPIREAT written to AOF)
+	time int64
+	// changes made by the command being processed
+	dirty int64
+}
+
+// beginCommand 开始处理一条命令, 记录开始时间, 清零命令自身的修改次数
+func (ctx *commandContext) beginCommand() {
+	ctx.time = time.Now().UnixNano() / int64(time.Millisecond) * int64(time.Millisecond)
+	ctx.dirty = 0
+}
+
+// CommandTime 当前命令开始处理的时间, 命令和写入AOF的参数都以它计算相对过期时间
+func (ctx *commandContext) CommandTime() int64 {
+	return ctx.time
+}
+
+// IncrDirty 只统计命令自身的修改, 命令执行期间服务器删除的key(访问时过期)不计入
+// 命令是否写入AOF由它决定
+func (ctx *commandContext) IncrDirty(delta int64) {
+	ctx.Server.IncrDirty(delta)
+	ctx.dirty += delta
+}
+
+// SelectDb 选择之后的命令使用的数据库
+func (ctx *commandContext) SelectDb(index int) error {
+	if index < 0 || index >= len(ctx.Dbs) {
+		return ErrorDbIndexOutOfRange
+	}
+	ctx.db = index
+	return nil
+}
+
+// database 当前选择的数据库
+func (ctx *commandContext) database() *Database {
+	return ctx.Dbs[ctx.db]
+}
+
 func NewClient(fd int, server *Server) *Client {
 	return &Client{
 		fd:                fd,
@@ -90,6 +158,7 @@ func NewClient(fd int, server *Server) *Client {
 		bulkNum:           0,
 		bulkLength:        0,
 		server:            server,
+		context:           &commandContext{Server: server, db: 0},
 		isQueryProcessing: false,
 	}
 }
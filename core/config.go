@@ -2,22 +2,88 @@ package core
 
 import (
 	"encoding/json"
+	. "goRedis/db"
+	"goRedis/persistence"
 	"io"
 	"os"
 )
 
+// SavePoint 自动快照规则: Seconds秒内至少有Changes次修改时触发BGSAVE
+type SavePoint struct {
+	Seconds int64 `json:"seconds"`
+	Changes int64 `json:"changes"`
+}
+
 type Config struct {
 	Port           int   `json:"port"`
 	MaxConnection  int32 `json:"maxConnection"`
 	MaxQueryLength int32 `json:"maxQueryLength"`
+	// number of databases, clients select one of them by index
+	Databases int `json:"databases"`
+	// persistence
+	Dir        string `json:"dir"`
+	DbFileName string `json:"dbFileName"`
+	// save points, empty to disable automatic snapshot
+	Save       []SavePoint `json:"save"`
+	AppendOnly bool        `json:"appendOnly"`
+	// directory (in dir) of base, incr and manifest files
+	AppendDirName string `json:"appendDirName"`
+	// prefix of append only file names
+	AppendFileName string `json:"appendFileName"`
+	AppendFsync    string `json:"appendFsync"`
+	// when the last AOF ends with an incomplete command (crashed while writing), truncate the incomplete
+	// command with a warning and start, otherwise refuse to start
+	AofLoadTruncated bool `json:"aofLoadTruncated"`
+	// rewrite AOF automatically when it grows by this percentage since the last rewrite, 0 to disable
+	AutoAofRewritePercentage int64 `json:"autoAofRewritePercentage"`
+	// AOF smaller than this size (bytes) will not be rewritten automatically
+	AutoAofRewriteMinSize int64 `json:"autoAofRewriteMinSize"`
+	// percentage of cron interval spent on deleting expired keys, 0 to disable active expire
+	ActiveExpireCpuPercent int64 `json:"activeExpireCpuPercent"`
+	// rehash dicts of the database in cron, so that dicts not accessed finish rehash and release the old table
+	ActiveRehashing bool `json:"activeRehashing"`
+	// memory limit (bytes) of the heap, 0 for no limit
+	Maxmemory int64 `json:"maxmemory"`
+	// how keys are evicted when maxmemory is reached, see db/evict.go
+	MaxmemoryPolicy string `json:"maxmemoryPolicy"`
+	// keys sampled in every database when evicting
+	MaxmemorySamples int `json:"maxmemorySamples"`
+	// compact encodings, values are converted to normal structures when they have more elements
+	// or larger elements than the thresholds, 0 to disable
+	ListMaxListpackEntries int `json:"listMaxListpackEntries"`
+	ListMaxListpackValue   int `json:"listMaxListpackValue"`
+	SetMaxIntsetEntries    int `json:"setMaxIntsetEntries"`
+	HashMaxListpackEntries int `json:"hashMaxListpackEntries"`
+	HashMaxListpackValue   int `json:"hashMaxListpackValue"`
+	ZsetMaxListpackEntries int `json:"zsetMaxListpackEntries"`
+	ZsetMaxListpackValue   int `json:"zsetMaxListpackValue"`
+	// free values deleted by eviction, expiration, DEL and FLUSHALL/FLUSHDB in background
+	LazyfreeLazyEviction  bool `json:"lazyfreeLazyEviction"`
+	LazyfreeLazyExpire    bool `json:"lazyfreeLazyExpire"`
+	LazyfreeLazyUserDel   bool `json:"lazyfreeLazyUserDel"`
+	LazyfreeLazyUserFlush bool `json:"lazyfreeLazyUserFlush"`
 }
 
 const (
-	DefaultMaxConnection  int32 = 1024
-	DefaultPort           int   = 6379
-	DefaultMaxQueryLength int32 = 1024 << 4
-	MaxMaxConnection      int32 = 4096
-	MaxMaxQueryLength     int32 = 1024 << 16
+	DefaultMaxConnection  int32  = 1024
+	DefaultPort           int    = 6379
+	DefaultMaxQueryLength int32  = 1024 << 4
+	MaxMaxConnection      int32  = 4096
+	MaxMaxQueryLength     int32  = 1024 << 16
+	DefaultDir            string = "."
+	DefaultDbFileName     string = "dump.gdb"
+	DefaultAppendDirName  string = "appendonlydir"
+	DefaultAppendFileName string = "appendonly.aof"
+	DefaultAppendFsync    string = persistence.FsyncEverySec
+	// auto aof rewrite
+	DefaultAutoAofRewritePercentage int64 = 100
+	DefaultAutoAofRewriteMinSize    int64 = 64 << 20
+	// active expire
+	DefaultActiveExpireCpuPercent int64 = 25
+	MaxActiveExpireCpuPercent     int64 = 100
+	// maxmemory
+	DefaultMaxmemoryPolicy  string = NoEviction
+	DefaultMaxmemorySamples int    = DefaultEvictionSamples
 )
 
 // LoadConfig
@@ -25,11 +91,7 @@ const (
 func LoadConfig(path string) *Config {
 	config, err := loadConfigFile(path)
 	if err != nil {
-		return &Config{
-			Port:           DefaultPort,
-			MaxConnection:  DefaultMaxConnection,
-			MaxQueryLength: DefaultMaxQueryLength,
-		}
+		return newDefaultConfig()
 	}
 	if config.MaxConnection > MaxMaxConnection {
 		config.MaxConnection = MaxMaxConnection
@@ -37,9 +99,72 @@ func LoadConfig(path string) *Config {
 	if config.MaxQueryLength > MaxMaxQueryLength {
 		config.MaxQueryLength = MaxMaxQueryLength
 	}
+	if config.Databases <= 0 {
+		config.Databases = DefaultDatabases
+	}
+	if config.Dir == "" {
+		config.Dir = DefaultDir
+	}
+	if config.DbFileName == "" {
+		config.DbFileName = DefaultDbFileName
+	}
+	if config.AppendDirName == "" {
+		config.AppendDirName = DefaultAppendDirName
+	}
+	if config.AppendFileName == "" {
+		config.AppendFileName = DefaultAppendFileName
+	}
+	if config.ActiveExpireCpuPercent > MaxActiveExpireCpuPercent {
+		config.ActiveExpireCpuPercent = MaxActiveExpireCpuPercent
+	}
+	if config.ActiveExpireCpuPercent < 0 {
+		config.ActiveExpireCpuPercent = 0
+	}
+	if config.Maxmemory < 0 {
+		config.Maxmemory = 0
+	}
+	if !ValidEvictionPolicy(config.MaxmemoryPolicy) {
+		config.MaxmemoryPolicy = DefaultMaxmemoryPolicy
+	}
+	if config.MaxmemorySamples <= 0 {
+		config.MaxmemorySamples = DefaultMaxmemorySamples
+	}
+	if !persistence.ValidFsyncPolicy(config.AppendFsync) {
+		config.AppendFsync = DefaultAppendFsync
+	}
 	return config
 }
 
+func newDefaultConfig() *Config {
+	return &Config{
+		Port:                     DefaultPort,
+		MaxConnection:            DefaultMaxConnection,
+		MaxQueryLength:           DefaultMaxQueryLength,
+		Databases:                DefaultDatabases,
+		Dir:                      DefaultDir,
+		DbFileName:               DefaultDbFileName,
+		Save:                     []SavePoint{{3600, 1}, {300, 100}, {60, 10000}},
+		AppendOnly:               false,
+		AppendDirName:            DefaultAppendDirName,
+		AppendFileName:           DefaultAppendFileName,
+		AppendFsync:              DefaultAppendFsync,
+		AofLoadTruncated:         true,
+		AutoAofRewritePercentage: DefaultAutoAofRewritePercentage,
+		AutoAofRewriteMinSize:    DefaultAutoAofRewriteMinSize,
+		ActiveExpireCpuPercent:   DefaultActiveExpireCpuPercent,
+		ActiveRehashing:          true,
+		MaxmemoryPolicy:          DefaultMaxmemoryPolicy,
+		MaxmemorySamples:         DefaultMaxmemorySamples,
+		ListMaxListpackEntries:   ListMaxListpackEntries,
+		ListMaxListpackValue:     ListMaxListpackValue,
+		SetMaxIntsetEntries:      SetMaxIntsetEntries,
+		HashMaxListpackEntries:   HashMaxListpackEntries,
+		HashMaxListpackValue:     HashMaxListpackValue,
+		ZsetMaxListpackEntries:   ZsetMaxListpackEntries,
+		ZsetMaxListpackValue:     ZsetMaxListpackValue,
+	}
+}
+
 func loadConfigFile(path string) (*Config, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -51,7 +176,8 @@ func loadConfigFile(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	config := &Config{}
+	// fields not in the file keep default values
+	config := newDefaultConfig()
 	if err = json.Unmarshal(buffer, config); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	. "goRedis/data_structure"
+	"time"
+)
+
+// Server cron core lib
+
+const (
+	// CronInterval serverCron执行间隔 (ms)
+	CronInterval int64 = 100
+	// time spent on rehashing in a cron loop
+	ActiveRehashBudget time.Duration = time.Millisecond
+)
+
+// ServerCron 服务器周期任务, 以NORMAL时间事件注册到AeLoop
+func ServerCron(loop *AeLoop, id int, extra interface{}) {
+	server := loop.server
+	server.updatePeakMemory()
+	// active expire
+	server.activeExpireCycle()
+	// incremental rehash
+	server.databasesCron()
+	// automatic snapshot
+	server.saveIfNeeded()
+	// aof rewrite
+	server.rewriteAofIfNeeded()
+}
+
+// activeExpireCycle 主动删除过期key, 每次最多占用cron间隔的ActiveExpireCpuPercent
+// 只在访问时删除(惰性删除)的话, 不再被访问的过期key会一直占用内存
+func (server *Server) activeExpireCycle() {
+	if server.ActiveExpireCpuPercent <= 0 {
+		return
+	}
+	budget := time.Duration(CronInterval*server.ActiveExpireCpuPercent/100) * time.Millisecond
+	deadline := time.Now().Add(budget)
+	// databases not reached before the deadline are checked first in the next cycle
+	for i := 0; i < len(server.Dbs) && time.Now().Before(deadline); i += 1 {
+		index := server.activeExpireDb
+		server.activeExpireDb = (index + 1) % len(server.Dbs)
+		server.Dbs[index].ActiveExpireCycle(deadline, func(key *DbObject) {
+			server.propagateDel(index, key)
+		})
+	}
+}
+
+// databasesCron 渐进式rehash只在访问dict时推进, 不再被访问的dict会一直占用两个哈希表
+// 每次cron最多花费ActiveRehashBudget推进rehash, 后台快照暂停rehash期间不推进
+func (server *Server) databasesCron() {
+	if !server.ActiveRehashing {
+		return
+	}
+	// one database a cron, the next one if it is not rehashing
+	for i := 0; i < len(server.Dbs); i += 1 {
+		index := server.rehashDb
+		server.rehashDb = (index + 1) % len(server.Dbs)
+		if server.Dbs[index].IncrementallyRehash(ActiveRehashBudget) {
+			return
+		}
+	}
+}
+
+// propagateDel 服务器删除key(主动或访问时过期, 淘汰)之前通知后台快照, 并以DEL追加到AOF
+// AOF重放时key的过期时间和内存占用不一定相同, 显式删除保证数据一致
+func (server *Server) propagateDel(dbIndex int, key *DbObject) {
+	server.BeforeWriteKey(dbIndex, key)
+	server.feedAppendOnly(dbIndex, []*DbObject{NewStr("DEL"), key})
+	server.dirty += 1
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"errors"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"runtime/metrics"
+)
+
+// maxmemory core lib
+// used memory is the size of heap objects reported by go runtime, memory of deleted keys is
+// reclaimed by the next GC, so the size of values freed (reported by lazyfree) is subtracted until then
+
+var (
+	ErrorOOM error = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+)
+
+const (
+	heapObjectsMetric string = "/memory/classes/heap/objects:bytes"
+	gcCyclesMetric    string = "/gc/cycles/total:gc-cycles"
+)
+
+// usedMemory 堆对象占用的内存, 减去已淘汰但尚未被GC回收的部分
+func (server *Server) usedMemory() int64 {
+	samples := []metrics.Sample{{Name: heapObjectsMetric}, {Name: gcCyclesMetric}}
+	metrics.Read(samples)
+	used := int64(samples[0].Value.Uint64())
+	server.pendingFree += server.lazyfree.TakeFreedBytes()
+	if gc := samples[1].Value.Uint64(); gc != server.pendingFreeGc {
+		// evicted keys are reclaimed
+		server.pendingFree = 0
+		server.pendingFreeGc = gc
+	}
+	return used - server.pendingFree
+}
+
+// freeMemoryIfNeeded 内存超过maxmemory时按策略淘汰key, 无法淘汰到maxmemory以下时返回ErrorOOM
+// 在写命令执行前调用
+func (server *Server) freeMemoryIfNeeded() error {
+	return server.freeMemory(0)
+}
+
+// freeMemory 同freeMemoryIfNeeded, reserved为已分配但尚未加入数据库的内存(如正在导入的key), 不计入使用量
+func (server *Server) freeMemory(reserved int64) error {
+	if server.Maxmemory <= 0 {
+		return nil
+	}
+	used := server.usedMemory() - reserved
+	if used <= server.Maxmemory {
+		return nil
+	}
+	toFree := used - server.Maxmemory
+	var freed int64 = 0
+	for freed < toFree {
+		_, key, val := server.evictor.Evict(server.Dbs, server.MaxmemoryPolicy, func(db *Database, key, val *DbObject) {
+			server.propagateDel(server.dbIndex(db), key)
+		})
+		if key == nil {
+			break
+		}
+		freed += EstimateSize(key, val, DefaultSizeSamples)
+		server.evictedKeys += 1
+	}
+	if freed < toFree {
+		return ErrorOOM
+	}
+	return nil
+}
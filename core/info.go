@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Server INFO command core lib
+
+type infoSection struct {
+	name string
+	// generate fields of the section
+	gen func(server *Server, builder *strings.Builder)
+}
+
+var infoSections []infoSection = []infoSection{
+	{"memory", memoryInfo},
+	{"persistence", persistenceInfo},
+	{"stats", statsInfo},
+	{"keyspace", keyspaceInfo},
+}
+
+// Info 生成INFO命令返回的服务器信息, section为空时返回所有section
+func (server *Server) Info(section string) string {
+	var builder strings.Builder
+	for _, s := range infoSections {
+		if section != "" && section != "all" && section != s.name {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + strings.ToUpper(s.name[:1]) + s.name[1:] + "\r\n")
+		s.gen(server, &builder)
+	}
+	return builder.String()
+}
+
+func writeInfoField(builder *strings.Builder, name string, value interface{}) {
+	builder.WriteString(fmt.Sprintf("%s:%v\r\n", name, value))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func persistenceInfo(server *Server, builder *strings.Builder) {
+	writeInfoField(builder, "rdb_changes_since_last_save", server.dirty)
+	writeInfoField(builder, "rdb_bgsave_in_progress", boolToInt(server.bgSave != nil))
+	writeInfoField(builder, "rdb_last_save_time", server.lastSave)
+	status := "ok"
+	if !server.lastBgSaveOk {
+		status = "err"
+	}
+	writeInfoField(builder, "rdb_last_bgsave_status", status)
+	lastSaveTime := int64(-1)
+	if server.lastSaveDuration >= 0 {
+		lastSaveTime = server.lastSaveDuration.Milliseconds()
+	}
+	writeInfoField(builder, "rdb_last_save_time_ms", lastSaveTime)
+	currentSaveTime := int64(-1)
+	if server.bgSave != nil {
+		currentSaveTime = time.Since(server.bgSave.start).Milliseconds()
+	}
+	writeInfoField(builder, "rdb_current_bgsave_time_ms", currentSaveTime)
+	writeInfoField(builder, "aof_enabled", boolToInt(server.aof != nil))
+	writeInfoField(builder, "aof_rewrite_in_progress", boolToInt(server.aofRewrite != nil))
+	if server.aof != nil {
+		writeInfoField(builder, "aof_current_size", server.aofCurrentSize())
+		writeInfoField(builder, "aof_base_size", server.aofRewriteBaseSize)
+		writeInfoField(builder, "aof_base_file_size", server.aofBaseSize)
+		writeInfoField(builder, "aof_incr_files", len(server.aofManifest.Incrs))
+	}
+}
+
+func statsInfo(server *Server, builder *strings.Builder) {
+	var expiredKeys int64 = 0
+	for _, db := range server.Dbs {
+		expiredKeys += db.ExpiredKeys()
+	}
+	writeInfoField(builder, "expired_keys", expiredKeys)
+	writeInfoField(builder, "evicted_keys", server.evictedKeys)
+	writeInfoField(builder, "lazyfreed_objects", server.lazyfree.FreedObjects())
+}
+
+func memoryInfo(server *Server, builder *strings.Builder) {
+	stats := server.getMemoryStats()
+	writeInfoField(builder, "used_memory", stats.total)
+	writeInfoField(builder, "used_memory_rss", stats.rss)
+	writeInfoField(builder, "used_memory_peak", stats.peak)
+	writeInfoField(builder, "used_memory_startup", stats.startup)
+	writeInfoField(builder, "used_memory_overhead", stats.overhead)
+	writeInfoField(builder, "used_memory_dataset", stats.dataset)
+	writeInfoField(builder, "mem_fragmentation_ratio", ratio(stats.rss, stats.total))
+	writeInfoField(builder, "lazyfree_pending_objects", server.lazyfree.PendingObjects())
+	writeInfoField(builder, "maxmemory", server.Maxmemory)
+	writeInfoField(builder, "maxmemory_policy", server.MaxmemoryPolicy)
+}
+
+// keyspaceInfo 非空数据库的key数量和设置了过期时间的key数量
+func keyspaceInfo(server *Server, builder *strings.Builder) {
+	for index, db := range server.Dbs {
+		if db.Size() == 0 {
+			continue
+		}
+		writeInfoField(builder, fmt.Sprintf("db%d", index), fmt.Sprintf("keys=%d,expires=%d", db.Size(), db.Expires()))
+	}
+}
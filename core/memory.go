@@ -0,0 +1,136 @@
+package core
+
+import (
+	"fmt"
+	"runtime/metrics"
+)
+
+// memory accounting core lib
+// used memory is divided into overhead (memory used at startup, clients, hash tables of database)
+// and dataset (keys and values), fragmentation is the memory held by go runtime but not used by objects
+
+const (
+	totalMemoryMetric    string = "/memory/classes/total:bytes"
+	releasedMemoryMetric string = "/memory/classes/heap/released:bytes"
+)
+
+type memoryStats struct {
+	peak    int64
+	total   int64
+	startup int64
+	clients int64
+	// hash tables of non-empty databases
+	dbs      []dbOverhead
+	overhead int64
+	keys     int64
+	dataset  int64
+	// memory obtained from OS and not released
+	rss int64
+}
+
+type dbOverhead struct {
+	index    int
+	dictMain int64
+	// hash tables of expire dict
+	dictExpires int64
+}
+
+// updatePeakMemory 记录使用内存的峰值, 由cron定期调用
+func (server *Server) updatePeakMemory() int64 {
+	used := server.usedMemory()
+	if used > server.peakMemory {
+		server.peakMemory = used
+	}
+	return used
+}
+
+// rssMemory go runtime从操作系统获取且未归还的内存
+func rssMemory() int64 {
+	samples := []metrics.Sample{{Name: totalMemoryMetric}, {Name: releasedMemoryMetric}}
+	metrics.Read(samples)
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+func (server *Server) getMemoryStats() *memoryStats {
+	stats := &memoryStats{
+		total:   server.updatePeakMemory(),
+		peak:    server.peakMemory,
+		startup: server.startupMemory,
+		rss:     rssMemory(),
+	}
+	for _, client := range server.Clients {
+		stats.clients += client.memoryUsage()
+	}
+	stats.overhead = stats.startup + stats.clients
+	for index, db := range server.Dbs {
+		if db.Size() == 0 {
+			continue
+		}
+		overhead := dbOverhead{index: index}
+		overhead.dictMain, overhead.dictExpires = db.Overhead()
+		stats.dbs = append(stats.dbs, overhead)
+		stats.keys += db.Size()
+		stats.overhead += overhead.dictMain + overhead.dictExpires
+	}
+	if stats.total > stats.overhead {
+		stats.dataset = stats.total - stats.overhead
+	}
+	return stats
+}
+
+func percentage(part, total int64) string {
+	if total <= 0 {
+		return "0.00"
+	}
+	return fmt.Sprintf("%.2f", float64(part)*100/float64(total))
+}
+
+func ratio(a, b int64) string {
+	if b <= 0 {
+		return "0.00"
+	}
+	return fmt.Sprintf("%.2f", float64(a)/float64(b))
+}
+
+// MemoryStats MEMORY STATS命令返回的字段, 按name, value依次排列
+func (server *Server) MemoryStats() []string {
+	stats := server.getMemoryStats()
+	bytesPerKey := int64(0)
+	if stats.keys > 0 {
+		bytesPerKey = stats.dataset / stats.keys
+	}
+	result := appendMemoryFields(nil, []memoryField{
+		{"peak.allocated", stats.peak},
+		{"total.allocated", stats.total},
+		{"startup.allocated", stats.startup},
+		{"clients.normal", stats.clients},
+	})
+	for _, db := range stats.dbs {
+		result = appendMemoryFields(result, []memoryField{
+			{fmt.Sprintf("db.%d.overhead.hashtable.main", db.index), db.dictMain},
+			{fmt.Sprintf("db.%d.overhead.hashtable.expires", db.index), db.dictExpires},
+		})
+	}
+	return appendMemoryFields(result, []memoryField{
+		{"overhead.total", stats.overhead},
+		{"keys.count", stats.keys},
+		{"keys.bytes-per-key", bytesPerKey},
+		{"dataset.bytes", stats.dataset},
+		{"dataset.percentage", percentage(stats.dataset, stats.total-stats.startup)},
+		{"peak.percentage", percentage(stats.total, stats.peak)},
+		{"fragmentation", ratio(stats.rss, stats.total)},
+		{"fragmentation.bytes", stats.rss - stats.total},
+	})
+}
+
+type memoryField struct {
+	name  string
+	value interface{}
+}
+
+func appendMemoryFields(result []string, fields []memoryField) []string {
+	for _, field := range fields {
+		result = append(result, field.name, fmt.Sprint(field.value))
+	}
+	return result
+}
@@ -0,0 +1,311 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"goRedis/service"
+	"goRedis/util"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Server persistence core lib
+// implements service.Server
+
+const (
+	// SnapshotStepInterval 后台快照时间事件执行间隔 (ms)
+	SnapshotStepInterval int64 = 10
+	// SnapshotStepBudget 每次时间事件中后台快照最多占用的时间
+	SnapshotStepBudget time.Duration = 2 * time.Millisecond
+	// BgSaveRetryDelay 自动快照失败后重试的间隔 (second)
+	BgSaveRetryDelay int64 = 5
+	// ImportBatchSize IMPORT每批写入的key数, 每批写入前检查maxmemory
+	ImportBatchSize int = 1000
+	// ImportMaxFileSize IMPORT文件的最大字节数, 文件在事件循环中解码, 更大的文件应在启动时加载
+	ImportMaxFileSize int64 = 64 << 20
+)
+
+var (
+	ErrorBgSaveInProgress error = errors.New("Background save already in progress")
+	ErrorImportPath       error = errors.New("IMPORT path must be relative to dir and must not contain '..'")
+	ErrorImportTooLarge   error = fmt.Errorf("IMPORT file is larger than %d bytes, load it at startup instead", ImportMaxFileSize)
+)
+
+// bgSaveState 正在进行的后台快照
+type bgSaveState struct {
+	session  *persistence.SnapshotSession
+	tempPath string
+	start    time.Time
+}
+
+// snapshotPath the path of snapshot file
+func (server *Server) snapshotPath() string {
+	return filepath.Join(server.Dir, server.DbFileName)
+}
+
+// Save 同步保存快照, 保存期间阻塞事件循环
+func (server *Server) Save() error {
+	if server.bgSave != nil {
+		return ErrorBgSaveInProgress
+	}
+	start := time.Now()
+	if err := persistence.SaveSnapshot(server.snapshotPath(), server.Dbs); err != nil {
+		log.Printf("[SAVE ERROR] Save snapshot error, err = %s\n", err)
+		return err
+	}
+	server.dirty = 0
+	server.lastSave = time.Now().Unix()
+	server.lastSaveDuration = time.Since(start)
+	log.Printf("[SAVE] Save snapshot success, cost %s\n", server.lastSaveDuration)
+	return nil
+}
+
+// BgSave 后台保存快照(不fork)
+// 由时间事件驱动增量快照会话, 每次最多占用SnapshotStepBudget, 不会阻塞事件循环
+func (server *Server) BgSave() error {
+	if server.bgSave != nil {
+		return ErrorBgSaveInProgress
+	}
+	server.lastBgSaveTry = time.Now().Unix()
+	file, err := os.CreateTemp(server.Dir, "temp-bgsave-*.gdb")
+	if err != nil {
+		return err
+	}
+	session, err := persistence.NewSnapshotSession(server.Dbs, file, persistence.NewSnapshotFileEncoder)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	state := &bgSaveState{
+		session:  session,
+		tempPath: file.Name(),
+		start:    time.Now(),
+	}
+	server.bgSave = state
+	server.dirtyBeforeBgSave = server.dirty
+	server.Loop.AddTimeEvent(NORMAL, SnapshotStepInterval, bgSaveStepHandler, state)
+	log.Printf("[BGSAVE] Background saving started\n")
+	return nil
+}
+
+// bgSaveStepHandler 后台快照时间事件, 推进快照会话, 完成后替换快照文件
+func bgSaveStepHandler(loop *AeLoop, id int, extra interface{}) {
+	server := loop.server
+	state := extra.(*bgSaveState)
+	state.session.Step(SnapshotStepBudget)
+	select {
+	case err := <-state.session.Done():
+		loop.RemoveTimeEvent(id)
+		server.bgSave = nil
+		if err == nil {
+			err = persistence.RenameFile(state.tempPath, server.snapshotPath())
+		}
+		if err != nil {
+			os.Remove(state.tempPath)
+			server.lastBgSaveOk = false
+			log.Printf("[BGSAVE ERROR] Background save snapshot error, err = %s\n", err)
+			return
+		}
+		// changes during the background save are not saved
+		server.dirty -= server.dirtyBeforeBgSave
+		server.lastSave = time.Now().Unix()
+		server.lastBgSaveOk = true
+		server.lastSaveDuration = time.Since(state.start)
+		log.Printf("[BGSAVE] Background save snapshot success, %d keys saved, cost %s\n", state.session.Keys(), server.lastSaveDuration)
+	default:
+	}
+}
+
+// saveIfNeeded 满足任一自动快照规则时开始BGSAVE
+// 上一次BGSAVE失败时, 间隔BgSaveRetryDelay后才重试
+func (server *Server) saveIfNeeded() {
+	if server.bgSave != nil {
+		return
+	}
+	now := time.Now().Unix()
+	if !server.lastBgSaveOk && now-server.lastBgSaveTry <= BgSaveRetryDelay {
+		return
+	}
+	for _, point := range server.SavePoints {
+		if server.dirty >= point.Changes && now-server.lastSave > point.Seconds {
+			log.Printf("[BGSAVE] %d changes in %d seconds, saving...\n", point.Changes, point.Seconds)
+			if err := server.BgSave(); err != nil {
+				server.lastBgSaveOk = false
+				log.Printf("[BGSAVE ERROR] Automatic background save error, err = %s\n", err)
+			}
+			return
+		}
+	}
+}
+
+// IncrDirty 增加上次保存以来的修改次数
+func (server *Server) IncrDirty(delta int64) {
+	server.dirty += delta
+}
+
+// LastSave 上次成功保存的unix时间(秒)
+func (server *Server) LastSave() int64 {
+	return server.lastSave
+}
+
+// beforeWriteCommand 写命令执行前通知正在进行的后台快照会话(写前复制)
+func (server *Server) beforeWriteCommand(dbIndex int, args []*DbObject) {
+	if server.bgSave == nil && server.aofRewrite == nil {
+		return
+	}
+	for _, key := range service.CommandKeys(args) {
+		server.BeforeWriteKey(dbIndex, key)
+	}
+}
+
+// BeforeWriteKey 修改数据库dbIndex的key之前通知正在进行的后台快照
+func (server *Server) BeforeWriteKey(dbIndex int, key *DbObject) {
+	if server.bgSave != nil {
+		server.bgSave.session.BeforeWrite(dbIndex, key)
+	}
+	if server.aofRewrite != nil {
+		server.aofRewrite.session.BeforeWrite(dbIndex, key)
+	}
+}
+
+// Import 导入快照或Redis RDB文件中的key, 已存在的key被覆盖
+// path是dir下的相对路径, 客户端不能读取dir以外的文件; 文件不能超过ImportMaxFileSize
+// 先解码整个文件再写入数据库, 文件损坏或包含不支持的类型时数据库不变
+// 每批key写入前按maxmemory淘汰(尚未写入的key不计入使用量), 无法淘汰时停止导入并返回ErrorOOM, 已导入的key保留
+// 开启AOF时导入的key以重建命令追加到AOF
+func (server *Server) Import(path string) (int64, error) {
+	start := time.Now()
+	path, err := server.importPath(path)
+	if err != nil {
+		return 0, err
+	}
+	entries := make([]importEntry, 0)
+	// size of entries not imported yet
+	var pending int64 = 0
+	err = persistence.ReadSnapshotFile(path, func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		if err := persistence.CheckDbIndex(dbIndex, len(server.Dbs)); err != nil {
+			return err
+		}
+		size := EstimateSize(key, val, DefaultSizeSamples)
+		pending += size
+		entries = append(entries, importEntry{dbIndex: dbIndex, key: key, val: val, expireTime: expireTime, size: size})
+		return nil
+	})
+	if err != nil {
+		log.Printf("[IMPORT ERROR] Import %s error, err = %s\n", path, err)
+		return 0, err
+	}
+	var imported int64 = 0
+	for len(entries) > 0 {
+		// keys evicted are deleted in AOF after the keys imported before
+		if err = server.freeMemory(pending); err != nil {
+			log.Printf("[IMPORT ERROR] Import %s error, %d keys imported, err = %s\n", path, imported, err)
+			return imported, err
+		}
+		batch := entries[:util.MinInt(len(entries), ImportBatchSize)]
+		entries = entries[len(batch):]
+		if err = server.importBatch(batch); err != nil {
+			return imported, err
+		}
+		imported += int64(len(batch))
+		for _, e := range batch {
+			pending -= e.size
+		}
+	}
+	log.Printf("[IMPORT] Import %s success, %d keys imported, cost %s\n", path, imported, time.Since(start))
+	return imported, nil
+}
+
+// importPath IMPORT文件在dir下的路径, 拒绝绝对路径、包含..的路径和过大的文件
+func (server *Server) importPath(path string) (string, error) {
+	if path == "" || filepath.IsAbs(path) {
+		return "", ErrorImportPath
+	}
+	for _, element := range strings.Split(filepath.ToSlash(path), "/") {
+		if element == ".." {
+			return "", ErrorImportPath
+		}
+	}
+	path = filepath.Join(server.Dir, path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > ImportMaxFileSize {
+		return "", ErrorImportTooLarge
+	}
+	return path, nil
+}
+
+// importEntry 导入文件中的一个key
+type importEntry struct {
+	dbIndex    int
+	key, val   *DbObject
+	expireTime int64
+	// estimated size of key and value
+	size int64
+}
+
+// importBatch 写入一批key并追加到AOF
+func (server *Server) importBatch(batch []importEntry) error {
+	buffer := &bytes.Buffer{}
+	encoder := persistence.NewAofRewriteEncoder(buffer)
+	selected := server.aofSelectedDb
+	for _, e := range batch {
+		server.BeforeWriteKey(e.dbIndex, e.key)
+		if err := server.Dbs[e.dbIndex].SetKeyObject(e.key, e.val, e.expireTime); err != nil {
+			return err
+		}
+		server.dirty += 1
+		if server.aof == nil {
+			continue
+		}
+		if e.dbIndex != selected {
+			if err := encoder.WriteSelectDb(e.dbIndex); err != nil {
+				return err
+			}
+			selected = e.dbIndex
+		}
+		if err := encoder.WriteEntry(e.key, e.val, e.expireTime); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	server.writeAppendOnly(buffer.Bytes())
+	server.aofSelectedDb = selected
+	return nil
+}
+
+// loadData 启动时加载数据
+// 开启AOF时从AOF恢复, 否则从快照恢复; 文件不存在时以空数据库启动
+func (server *Server) loadData() error {
+	if server.AppendOnly {
+		return server.loadAppendOnly()
+	}
+	return server.loadSnapshot()
+}
+
+func (server *Server) loadSnapshot() error {
+	start := time.Now()
+	err := persistence.LoadSnapshot(server.snapshotPath(), server.Dbs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("[LOAD DATA] Snapshot file %s does not exist, start with empty database\n", server.snapshotPath())
+			return nil
+		}
+		log.Printf("[LOAD DATA ERROR] Load snapshot error, err = %s\n", err)
+		return err
+	}
+	log.Printf("[LOAD DATA] Load snapshot success, %d keys loaded, cost %s\n", server.datasetSize(), time.Since(start))
+	return nil
+}
@@ -142,10 +142,7 @@ func handleInlineRequest(client *Client) error {
 	client.queryBuffer = client.queryBuffer[crlfIndex+2:]
 	client.args = make([]*DbObject, len(values))
 	for index, val := range values {
-		client.args[index] = &DbObject{
-			Type: STR,
-			Val:  val,
-		}
+		client.args[index] = NewStr(val)
 	}
 	client.isQueryProcessing = false
 	client.canDoNextCommandHandle = true
@@ -161,7 +158,8 @@ func handleBulkRequest(client *Client) error {
 	if client.bulkNum == 0 {
 		crlfIndex := client.findCrlfFromQueryBuffer()
 		if crlfIndex == -1 {
-			return errors.New(fmt.Sprintf("Query Length overflows\n"))
+			// the rest of the header is read later, the query length is checked when reading
+			return nil
 		}
 		bNum, err := client.getNumberFromQueryBuffer(1, crlfIndex)
 		if err != nil {
@@ -175,7 +173,7 @@ func handleBulkRequest(client *Client) error {
 		client.queryLength -= crlfIndex + 2
 	}
 	for client.bulkNum > 0 {
-		if len(client.queryBuffer) == 0 {
+		if client.queryLength == 0 {
 			break
 		}
 		// find bulkLength
@@ -201,10 +199,7 @@ func handleBulkRequest(client *Client) error {
 			break
 		}
 		// build client arg
-		newArg := &DbObject{
-			Type: STR,
-			Val:  string(client.queryBuffer[:client.bulkLength]),
-		}
+		newArg := NewStr(string(client.queryBuffer[:client.bulkLength]))
 		client.args = append(client.args, newArg)
 		client.queryBuffer = append(client.queryBuffer[client.bulkLength+2:])
 		client.queryLength -= client.bulkLength + 2
@@ -228,7 +223,27 @@ func processCommand(client *Client) {
 		return
 	}
 	log.Printf("[PROCESSING COMMAND] Processing command of client %d, command type : %s\n", client.fd, client.args[0].StrVal())
-	msg := service.Handle(client.args, client.server.Db)
+	isWrite := service.IsWriteCommand(client.args[0].StrVal())
+	if isWrite {
+		// evict keys before writing, commands which may use more memory are rejected if failed
+		if err := client.server.freeMemoryIfNeeded(); err != nil && service.IsDenyOomCommand(client.args[0].StrVal()) {
+			client.args = make([]*DbObject, 0)
+			client.AddReplyStr(service.ErrorReply(err.Error()))
+			return
+		}
+		// notify background snapshots before keys are modified
+		client.server.beforeWriteCommand(client.context.db, client.args)
+	}
+	// the write command is appended to AOF after the database it is executed in
+	dbIndex := client.context.db
+	client.context.beginCommand()
+	msg := service.Handle(client.args, client.context.database(), client.context)
+	// append write commands which changed the database to AOF,
+	// commands not applied (e.g. EXPIRE of a key not exists) change nothing and are not written,
+	// keys expired when accessed by them are written as DEL by propagateDel
+	if isWrite && client.context.dirty != 0 && !service.IsErrorReply(msg) {
+		client.server.feedAppendOnly(dbIndex, service.AppendOnlyArgs(client.args, client.context))
+	}
 	// reset args
 	client.args = make([]*DbObject, 0)
 	if msg == util.ERROR_QUIT {
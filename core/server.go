@@ -2,30 +2,122 @@ package core
 
 import (
 	"errors"
+	. "goRedis/data_structure"
 	. "goRedis/db"
 	"goRedis/net"
+	"goRedis/persistence"
 	"goRedis/service"
 	"log"
+	"os"
+	"time"
 )
 
 // DataBase server core lib
 
 type Server struct {
-	Fd             int
-	Db             *Database
+	Fd int
+	// databases, selected by index
+	Dbs            []*Database
 	Clients        map[int]*Client
 	Loop           *AeLoop
 	Port           int
 	MaxConnection  int32
 	MaxQueryLength int32
+	// persistence
+	Dir        string
+	DbFileName string
+	SavePoints []SavePoint
+	// background save in progress, nil if not
+	bgSave *bgSaveState
+	// changes since the last successful save
+	dirty int64
+	// dirty when the current background save started
+	dirtyBeforeBgSave int64
+	// unix time (second) of the last successful save
+	lastSave int64
+	// status and unix time (second) of the last background save try
+	lastBgSaveOk  bool
+	lastBgSaveTry int64
+	// duration of the last successful save
+	lastSaveDuration time.Duration
+	// append only file
+	AppendOnly     bool
+	AppendDirName  string
+	AppendFileName string
+	AppendFsync    string
+	// truncate the incomplete command at the end of the last AOF when loading
+	AofLoadTruncated bool
+	// the incr file opened for appending
+	aof         *persistence.AppendOnlyFile
+	aofManifest *persistence.AofManifest
+	// db index selected in the current incr file, -1 if SELECT is not written yet
+	aofSelectedDb int
+	// size of the base file, and size of all files except the current incr file
+	aofBaseSize    int64
+	aofHistorySize int64
+	// aof rewrite
+	AutoAofRewritePercentage int64
+	AutoAofRewriteMinSize    int64
+	aofRewrite               *aofRewriteState
+	// aof size after the last rewrite (or at startup)
+	aofRewriteBaseSize int64
+	// active expire
+	ActiveExpireCpuPercent int64
+	// the database active expire cycle starts with
+	activeExpireDb int
+	// incremental rehash in cron
+	ActiveRehashing bool
+	// the database rehashed in the next cron
+	rehashDb int
+	// maxmemory
+	Maxmemory       int64
+	MaxmemoryPolicy string
+	evictor         *Evictor
+	evictedKeys     int64
+	// size of freed values not yet reclaimed by GC, and GC cycles when it is counted
+	pendingFree   int64
+	pendingFreeGc uint64
+	// free deleted values
+	lazyfree *Lazyfree
+	// memory used before loading data, and the peak of used memory
+	startupMemory int64
+	peakMemory    int64
 }
 
 func NewServer(config *Config) (*Server, error) {
 	// create listening socket
 	server := &Server{
-		Port:           config.Port,
-		MaxConnection:  config.MaxConnection,
-		MaxQueryLength: config.MaxQueryLength,
+		Port:             config.Port,
+		MaxConnection:    config.MaxConnection,
+		MaxQueryLength:   config.MaxQueryLength,
+		Dir:              config.Dir,
+		DbFileName:       config.DbFileName,
+		SavePoints:       config.Save,
+		lastSave:         time.Now().Unix(),
+		lastBgSaveOk:     true,
+		lastSaveDuration: -1,
+		AppendOnly:       config.AppendOnly,
+		AppendDirName:    config.AppendDirName,
+		AppendFileName:   config.AppendFileName,
+		AppendFsync:      config.AppendFsync,
+		AofLoadTruncated: config.AofLoadTruncated,
+		aofSelectedDb:    -1,
+		// aof rewrite
+		AutoAofRewritePercentage: config.AutoAofRewritePercentage,
+		AutoAofRewriteMinSize:    config.AutoAofRewriteMinSize,
+		// active expire
+		ActiveExpireCpuPercent: config.ActiveExpireCpuPercent,
+		ActiveRehashing:        config.ActiveRehashing,
+		// maxmemory
+		Maxmemory:       config.Maxmemory,
+		MaxmemoryPolicy: config.MaxmemoryPolicy,
+		evictor:         NewEvictor(config.MaxmemorySamples),
+		lazyfree: NewLazyfree(LazyfreeOptions{
+			LazyEviction:  config.LazyfreeLazyEviction,
+			LazyExpire:    config.LazyfreeLazyExpire,
+			LazyUserDel:   config.LazyfreeLazyUserDel,
+			LazyUserFlush: config.LazyfreeLazyUserFlush,
+		}),
 	}
 	// listening fd
 	fd := net.TcpServer(config.Port)
@@ -35,14 +127,83 @@ func NewServer(config *Config) (*Server, error) {
 	server.Fd = fd
 	loop, err := AeLoopCreate(server)
 	if err != nil {
+		net.Close(fd)
 		return nil, err
 	}
 	server.Loop = loop
-	server.Db = NewDatabase()
+	// thresholds of compact encodings are used by all values
+	ListMaxListpackEntries, ListMaxListpackValue = config.ListMaxListpackEntries, config.ListMaxListpackValue
+	SetMaxIntsetEntries = config.SetMaxIntsetEntries
+	HashMaxListpackEntries, HashMaxListpackValue = config.HashMaxListpackEntries, config.HashMaxListpackValue
+	ZsetMaxListpackEntries, ZsetMaxListpackValue = config.ZsetMaxListpackEntries, config.ZsetMaxListpackValue
+	server.Dbs = make([]*Database, config.Databases)
+	for index := range server.Dbs {
+		server.Dbs[index] = NewDatabase()
+		server.Dbs[index].SetEvictionPolicy(server.MaxmemoryPolicy)
+		// values deleted from all databases are freed by the same goroutine
+		server.Dbs[index].SetLazyfree(server.lazyfree)
+		// keys expired when accessed are deleted like active expire
+		dbIndex := index
+		server.Dbs[index].SetExpireHook(func(key *DbObject) {
+			server.propagateDel(dbIndex, key)
+		})
+	}
 	server.Clients = make(map[int]*Client)
+	server.startupMemory = server.updatePeakMemory()
+	// load data before accepting clients
+	if err = server.loadData(); err != nil {
+		server.Shutdown()
+		return nil, err
+	}
+	// changes made by loading do not need to be saved
+	server.dirty = 0
+	if server.AppendOnly {
+		if err = server.startAppendOnly(); err != nil {
+			server.Shutdown()
+			return nil, err
+		}
+	}
 	return server, nil
 }
 
+// Shutdown 停止事件循环并释放服务器占用的资源, 可以在事件循环以外的goroutine调用
+// 关闭客户端连接和监听socket, 终止后台快照和AOF重写并删除临时文件, 关闭AOF文件
+// 数据不会被保存
+func (server *Server) Shutdown() {
+	server.Loop.AeStop()
+	for _, client := range server.Clients {
+		FreeClient(client)
+	}
+	if err := net.Close(server.Fd); err != nil {
+		log.Printf("[SHUTDOWN ERROR] Close listening socket error, err = %s\n", err)
+	}
+	if server.bgSave != nil {
+		abortSnapshotSession(server.bgSave.session, server.bgSave.tempPath)
+		server.bgSave = nil
+	}
+	if server.aofRewrite != nil {
+		abortSnapshotSession(server.aofRewrite.session, server.aofRewrite.tempPath)
+		server.aofRewrite = nil
+	}
+	if server.aof != nil {
+		if err := server.aof.Close(); err != nil {
+			log.Printf("[SHUTDOWN ERROR] Close append only file error, err = %s\n", err)
+		}
+		server.aof = nil
+	}
+	if err := server.Loop.close(); err != nil {
+		log.Printf("[SHUTDOWN ERROR] Close ae loop error, err = %s\n", err)
+	}
+	log.Printf("[SHUTDOWN] Server on port %d is shut down\n", server.Port)
+}
+
+// abortSnapshotSession 终止后台快照会话, 等待写入goroutine关闭文件后删除临时文件
+func abortSnapshotSession(session *persistence.SnapshotSession, tempPath string) {
+	session.Abort()
+	<-session.Done()
+	os.Remove(tempPath)
+}
+
 // AcceptHandler Accept a connection request of client
 // 监听socket处理连接请求的EPOLL回调函数
 // 建立连接, 创建Client并加入到Server中, 并注册EPOLLIN(readQueryFromClient)事件
@@ -59,3 +220,27 @@ func AcceptHandler(loop *AeLoop, fd int, extra interface{}) {
 	client.AddReplyStr(service.WELCOME)
 	log.Printf("[LISTENING SOCKET ACCEPT HANDLER] Accept client success, connection build, fd = %d\n", client.fd)
 }
+
+// Databases 所有数据库, 下标即db index
+func (server *Server) Databases() []*Database {
+	return server.Dbs
+}
+
+// dbIndex db在Dbs中的下标, SWAPDB只交换数据库的内容, 下标不变
+func (server *Server) dbIndex(db *Database) int {
+	for index, d := range server.Dbs {
+		if d == db {
+			return index
+		}
+	}
+	return -1
+}
+
+// datasetSize key数量, 所有数据库之和
+func (server *Server) datasetSize() int64 {
+	var keys int64 = 0
+	for _, db := range server.Dbs {
+		keys += db.Size()
+	}
+	return keys
+}
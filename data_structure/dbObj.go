@@ -28,10 +28,16 @@ type DbObject struct {
 	Type DbObjectType
 	Val  DbObjectVal
 	Hash int64 // hash cache, hash cannot be negative, then hash can be initialized as -1 if not computed
+	// access info of a value in database, used by eviction
+	// LRU clock, or LFU data (last decrement time in minutes << 8 | logarithmic counter)
+	Lru uint32
 }
 
 // IntVal return -1 if invalid
 func (obj *DbObject) IntVal() (ans int64, err error) {
+	if v, ok := obj.Val.(int64); ok {
+		return v, nil
+	}
 	if obj.Type == STR {
 		val, err := strconv.ParseInt(obj.StrVal(), 10, 64)
 		if err != nil {
@@ -46,11 +52,34 @@ func (obj *DbObject) IntVal() (ans int64, err error) {
 func (obj *DbObject) StrVal() string {
 	if obj.Type != STR {
 		return ""
+	} else if v, ok := obj.Val.(int64); ok {
+		return strconv.FormatInt(v, 10)
 	} else {
 		return obj.Val.(string)
 	}
 }
 
+// IsIntEncoded
+// judge whether a string object is stored as an integer
+func (obj *DbObject) IsIntEncoded() bool {
+	_, ok := obj.Val.(int64)
+	return obj.Type == STR && ok
+}
+
+// TryIntEncoding
+// store a string object as an integer if the string is the canonical form of an integer
+// e.g. "123" is encoded but "0123" and "+1" are not, so StrVal returns the same string
+func (obj *DbObject) TryIntEncoding() *DbObject {
+	s, ok := obj.Val.(string)
+	if obj.Type != STR || !ok || len(s) > 20 {
+		return obj
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+		obj.Val = v
+	}
+	return obj
+}
+
 func NewObject(t DbObjectType, v DbObjectVal) *DbObject {
 	return &DbObject{
 		Type: t,
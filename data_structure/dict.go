@@ -2,19 +2,25 @@ package data_structure
 
 import (
 	"errors"
+	"math/bits"
 	"math/rand"
+	"time"
 )
 
 // EqualFunction 链地址，哈希冲突时判断相等
 // HashFunction key -> hash value
 
 const (
-	RehashStep          int     = 1
-	RehashRatio         float64 = 0.75 // load factor
-	DefaultInitSize     int64   = 1 << 4
-	MaxSize             int64   = 1 << 30
-	MaxInitSize         int64   = 1 << 10
-	MaxRandomGetAttempt int     = 100
+	RehashStep  int     = 1
+	RehashRatio float64 = 0.75 // load factor
+	// shrink if the load factor is lower than this
+	MinFillRatio float64 = 0.1
+	// buckets rehashed between two budget checks of RehashFor
+	rehashBucketsPerCheck int   = 100
+	DefaultInitSize       int64 = 1 << 4
+	MaxSize               int64 = 1 << 30
+	MaxInitSize           int64 = 1 << 10
+	MaxRandomGetAttempt   int   = 100
 )
 
 var (
@@ -27,6 +33,14 @@ type Entry struct {
 	next *Entry // 链地址
 }
 
+func (entry *Entry) Key() *DbObject {
+	return entry.key
+}
+
+func (entry *Entry) Val() *DbObject {
+	return entry.val
+}
+
 type HashTable struct {
 	table []*Entry
 	size  int64
@@ -41,6 +55,8 @@ type Dict struct {
 	hashTables [2]*HashTable
 	// rehashIndex == -1 代表当前没有进行rehash
 	rehashIndex int64
+	// rehashPaused > 0 代表rehash被暂停(例如快照期间entry不能在哈希表间移动)
+	rehashPaused int
 }
 
 func NewHashTable(size int64) *HashTable {
@@ -95,19 +111,21 @@ func (dict *Dict) keyIndex(key *DbObject, hashTableIndex int) int64 {
 	if h == -1 || hashTableIndex >= 2 {
 		return -1
 	}
-	return h % dict.hashTables[hashTableIndex].mask
+	// size is a power of 2, so that a bucket of a larger table always maps to the same bucket of a
+	// smaller one, which Scan depends on
+	return h & dict.hashTables[hashTableIndex].mask
 }
 
-// expand -> rehash
+// resize -> rehash
 
-// expand
-// if ok, start rehash
-func (dict *Dict) expand(nextSize int64) {
-	// is already expanded or is expanding
-	if dict.isRehashing() || (dict.hashTables[0].size >= nextSize) {
+// resize
+// if ok, start rehash to a hash table of size
+func (dict *Dict) resize(size int64) {
+	// is already resizing
+	if dict.isRehashing() || dict.hashTables[0].size == size {
 		return
 	}
-	newHashTable := NewHashTable(nextSize)
+	newHashTable := NewHashTable(size)
 	// start rehashIndex
 	dict.rehashIndex = 0
 	dict.hashTables[1] = newHashTable
@@ -128,46 +146,88 @@ func (dict *Dict) expandIfNeeded() {
 		if nextSize > MaxSize {
 			return
 		}
-		dict.expand(nextSize)
+		dict.resize(nextSize)
+	}
+}
+
+// shrinkIfNeeded
+// shrink the hash table if the load factor is lower than MinFillRatio
+// not started while rehash is paused, otherwise an iterating snapshot would see entries moved
+func (dict *Dict) shrinkIfNeeded() {
+	size := dict.hashTables[0].size
+	if dict.isRehashing() || dict.rehashPaused > 0 || size <= DefaultInitSize {
+		return
+	}
+	used := dict.hashTables[0].used
+	if float64(used)/float64(size) >= MinFillRatio {
+		return
+	}
+	// keep the load factor lower than RehashRatio, or it will be expanded again
+	newSize := nextSize(int64(float64(used)/RehashRatio) + 1)
+	if newSize < DefaultInitSize {
+		newSize = DefaultInitSize
 	}
+	dict.resize(newSize)
 }
 
 func (dict *Dict) isRehashing() bool {
 	return dict.rehashIndex != -1
 }
 
-// rehashStep
-// do a rehash step
-func (dict *Dict) rehashStep() {
-	if !dict.isRehashing() {
-		return
+// rehash
+// move entries of at most step buckets from hashTables[0] to hashTables[1]
+// at most step * 10 empty buckets are visited, so a sparse table does not block for long
+// return whether rehash is still in progress
+func (dict *Dict) rehash(step int) bool {
+	if dict.rehashPaused > 0 || !dict.isRehashing() {
+		return dict.isRehashing()
+	}
+	emptyVisits := step * 10
+	for ; step > 0 && dict.hashTables[0].used > 0; step -= 1 {
+		for dict.hashTables[0].table[dict.rehashIndex] == nil {
+			dict.rehashIndex += 1
+			emptyVisits -= 1
+			if emptyVisits == 0 {
+				return true
+			}
+		}
+		// data remove
+		current := dict.hashTables[0].table[dict.rehashIndex]
+		for current != nil {
+			nextEntry := current.next
+			newIndex := dict.keyIndex(current.key, 1)
+			// 头插法
+			head := dict.hashTables[1].table[newIndex]
+			dict.hashTables[1].table[newIndex] = current
+			current.next = head
+			current = nextEntry
+			// update used
+			dict.hashTables[1].used += 1
+			dict.hashTables[0].used -= 1
+		}
+		dict.hashTables[0].table[dict.rehashIndex] = nil
+		dict.rehashIndex += 1
 	}
-	// the end of rehash
-	dict.endRehash()
-	for ; dict.hashTables[0].table[dict.rehashIndex] == nil; dict.rehashIndex += 1 {
-	}
-	// data remove
-	current := dict.hashTables[0].table[dict.rehashIndex]
-	for current != nil {
-		nextEntry := current.next
-		newIndex := dict.keyIndex(current.key, 1)
-		// 头插法
-		head := dict.hashTables[1].table[newIndex]
-		dict.hashTables[1].table[newIndex] = current
-		current.next = head
-		current = nextEntry
-		// update used
-		dict.hashTables[1].used += 1
-		dict.hashTables[0].used -= 1
-	}
-	dict.hashTables[0].table[dict.rehashIndex] = nil
 	dict.endRehash()
+	return dict.isRehashing()
 }
 
-func (dict *Dict) rehash(step int) {
-	for i := 0; i < step && dict.isRehashing(); i += 1 {
-		dict.rehashStep()
+// RehashFor
+// rehash within budget, used to finish rehash of dicts not accessed
+// a sparse dict (e.g. entries deleted while rehash was paused) starts shrinking first
+// return whether any bucket is rehashed
+func (dict *Dict) RehashFor(budget time.Duration) bool {
+	dict.shrinkIfNeeded()
+	if dict.rehashPaused > 0 || !dict.isRehashing() {
+		return false
+	}
+	start := time.Now()
+	for dict.rehash(rehashBucketsPerCheck) {
+		if time.Since(start) >= budget {
+			break
+		}
 	}
+	return true
 }
 
 func (dict *Dict) endRehash() {
@@ -272,6 +332,7 @@ func (dict *Dict) Delete(key *DbObject) error {
 				}
 				// update
 				dict.hashTables[i].used -= 1
+				dict.shrinkIfNeeded()
 				return nil
 			}
 			last = current
@@ -354,6 +415,226 @@ func (dict *Dict) Exist(key *DbObject) (bool, error) {
 	return entry != nil, nil
 }
 
+// Iterate
+// traverse all entries of dict (both hash tables when rehashing), stop if fn returns false
+// dict must not be modified during iterating
+func (dict *Dict) Iterate(fn func(key, val *DbObject) bool) {
+	searchRange := dict.searchIndex()
+	for i := 0; i <= searchRange; i += 1 {
+		for _, current := range dict.hashTables[i].table {
+			for ; current != nil; current = current.next {
+				if !fn(current.key, current.val) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// DictIterator
+// iterate entries of dict one by one
+// the iterator pauses rehash until released, entries can be added or deleted during iterating
+// (added ones may not be returned)
+type DictIterator struct {
+	dict  *Dict
+	table int
+	index int64
+	// next entry is kept since the current one may be deleted
+	next    *Entry
+	started bool
+	done    bool
+}
+
+func (dict *Dict) NewSafeIterator() *DictIterator {
+	return &DictIterator{dict: dict, index: -1}
+}
+
+// Next
+// the next entry, nil if all entries are returned
+func (it *DictIterator) Next() *Entry {
+	if it.done {
+		return nil
+	}
+	if !it.started {
+		it.started = true
+		it.dict.PauseRehash()
+	}
+	for it.next == nil {
+		it.index += 1
+		if it.index >= it.dict.hashTables[it.table].size {
+			if it.table == 1 || !it.dict.isRehashing() {
+				it.Release()
+				return nil
+			}
+			it.table, it.index = 1, 0
+		}
+		it.next = it.dict.hashTables[it.table].table[it.index]
+	}
+	entry := it.next
+	it.next = entry.next
+	return entry
+}
+
+// Release
+// resume rehash paused by the iterator, called automatically when all entries are returned
+func (it *DictIterator) Release() {
+	if it.started && !it.done {
+		it.dict.ResumeRehash()
+	}
+	it.done = true
+	it.next = nil
+}
+
+// PauseRehash
+// pause rehash, entries will not move between hash tables until ResumeRehash
+// pause can be nested
+func (dict *Dict) PauseRehash() {
+	dict.rehashPaused += 1
+}
+
+func (dict *Dict) ResumeRehash() {
+	if dict.rehashPaused > 0 {
+		dict.rehashPaused -= 1
+	}
+}
+
+func (dict *Dict) RehashPaused() bool {
+	return dict.rehashPaused > 0
+}
+
+// WalkBuckets
+// traverse all entries of at most count buckets starting from position cursor
+// positions of hashTables[1] follow those of hashTables[0]
+// return the next position and whether all buckets have been traversed
+// rehash must be paused between calls, otherwise entries may be missed
+func (dict *Dict) WalkBuckets(cursor int64, count int, fn func(key, val *DbObject)) (int64, bool) {
+	for i := 0; i < count; i += 1 {
+		table, index := dict.bucketOf(cursor)
+		if table == nil {
+			return cursor, true
+		}
+		for current := table.table[index]; current != nil; current = current.next {
+			fn(current.key, current.val)
+		}
+		cursor += 1
+	}
+	table, _ := dict.bucketOf(cursor)
+	return cursor, table == nil
+}
+
+// ClearBuckets
+// remove all entries of at most count buckets starting from position cursor, each is called
+// on every removed entry, positions are the same as WalkBuckets
+// return the next position and whether all buckets have been cleared
+// used to dismantle a dict not referenced any more, rehash must not happen between calls
+func (dict *Dict) ClearBuckets(cursor int64, count int, each func(key, val *DbObject)) (int64, bool) {
+	for i := 0; i < count; i += 1 {
+		table, index := dict.bucketOf(cursor)
+		if table == nil {
+			return cursor, true
+		}
+		for current := table.table[index]; current != nil; current = table.table[index] {
+			table.table[index] = current.next
+			current.next = nil
+			table.used -= 1
+			if each != nil {
+				each(current.key, current.val)
+			}
+		}
+		cursor += 1
+	}
+	table, _ := dict.bucketOf(cursor)
+	return cursor, table == nil
+}
+
+// Scan
+// call fn on entries of the bucket at cursor, return the next cursor, 0 if all buckets are visited
+// the cursor is increased from the highest bit (reverse binary), since the size of hash tables is
+// a power of 2, buckets visited in a smaller table are also visited in a larger one, so that all
+// entries present from the start to the end of scanning are returned even if the dict is resized
+// between calls (some entries may be returned more than once)
+// fn must not modify the dict
+func (dict *Dict) Scan(cursor uint64, fn func(key, val *DbObject)) uint64 {
+	if dict.Len() == 0 {
+		return 0
+	}
+	emit := func(table *HashTable, index uint64) {
+		for current := table.table[index]; current != nil; current = current.next {
+			fn(current.key, current.val)
+		}
+	}
+	if !dict.isRehashing() {
+		table := dict.hashTables[0]
+		mask := uint64(table.mask)
+		emit(table, cursor&mask)
+		return nextCursor(cursor, mask)
+	}
+	small, large := dict.hashTables[0], dict.hashTables[1]
+	if small.size > large.size {
+		small, large = large, small
+	}
+	smallMask, largeMask := uint64(small.mask), uint64(large.mask)
+	emit(small, cursor&smallMask)
+	// buckets of the larger table which are expansions of the bucket of the smaller table
+	for {
+		emit(large, cursor&largeMask)
+		cursor = nextCursor(cursor, largeMask)
+		if cursor&(smallMask^largeMask) == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+// nextCursor
+// increase the reversed cursor of a table with mask
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor += 1
+	return bits.Reverse64(cursor)
+}
+
+// Position
+// the bucket position of key (same as WalkBuckets), -1 if key does not exist
+func (dict *Dict) Position(key *DbObject) int64 {
+	var base int64 = 0
+	for i := 0; i <= dict.searchIndex(); i += 1 {
+		index := dict.keyIndex(key, i)
+		if index == -1 {
+			return -1
+		}
+		for current := dict.hashTables[i].table[index]; current != nil; current = current.next {
+			if dict.equalFunc(current.key, key) {
+				return base + index
+			}
+		}
+		base += dict.hashTables[i].size
+	}
+	return -1
+}
+
+// bucketOf
+// hash table and bucket index of a position, nil if position is out of range
+func (dict *Dict) bucketOf(position int64) (*HashTable, int64) {
+	for i := 0; i <= dict.searchIndex(); i += 1 {
+		if position < dict.hashTables[i].size {
+			return dict.hashTables[i], position
+		}
+		position -= dict.hashTables[i].size
+	}
+	return nil, -1
+}
+
+// Len
+// the number of entries in dict
+func (dict *Dict) Len() int64 {
+	if dict.isRehashing() {
+		return dict.hashTables[0].used + dict.hashTables[1].used
+	}
+	return dict.hashTables[0].used
+}
+
 // nextSize
 // the first power of 2 that >= size O(logN)
 func nextSize(size int64) int64 {
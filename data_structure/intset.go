@@ -0,0 +1,152 @@
+package data_structure
+
+import "encoding/binary"
+
+// Intset 有序整数集合
+// 所有整数以相同的宽度(2/4/8字节, little endian)连续存储在contents中
+// 加入的整数超出当前宽度时, 整个集合升级到更大的宽度, 不会降级
+
+const (
+	intsetEnc16 int = 2
+	intsetEnc32 int = 4
+	intsetEnc64 int = 8
+)
+
+type Intset struct {
+	// width of every integer
+	encoding int
+	length   int
+	contents []byte
+}
+
+func NewIntset() *Intset {
+	return &Intset{
+		encoding: intsetEnc16,
+		length:   0,
+		contents: make([]byte, 0),
+	}
+}
+
+// valueEncoding the smallest width to store v
+func valueEncoding(v int64) int {
+	if v < -1<<31 || v > 1<<31-1 {
+		return intsetEnc64
+	} else if v < -1<<15 || v > 1<<15-1 {
+		return intsetEnc32
+	}
+	return intsetEnc16
+}
+
+func (set *Intset) get(index int) int64 {
+	return getWithEncoding(set.contents, index, set.encoding)
+}
+
+func getWithEncoding(contents []byte, index int, encoding int) int64 {
+	p := contents[index*encoding:]
+	switch encoding {
+	case intsetEnc16:
+		return int64(int16(binary.LittleEndian.Uint16(p)))
+	case intsetEnc32:
+		return int64(int32(binary.LittleEndian.Uint32(p)))
+	}
+	return int64(binary.LittleEndian.Uint64(p))
+}
+
+func (set *Intset) put(index int, v int64) {
+	p := set.contents[index*set.encoding:]
+	switch set.encoding {
+	case intsetEnc16:
+		binary.LittleEndian.PutUint16(p, uint16(v))
+	case intsetEnc32:
+		binary.LittleEndian.PutUint32(p, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(p, uint64(v))
+	}
+}
+
+// search 二分查找, 返回v是否存在以及v所在(或应插入)的位置
+func (set *Intset) search(v int64) (bool, int) {
+	left, right := 0, set.length-1
+	for left <= right {
+		mid := (left + right) / 2
+		cur := set.get(mid)
+		if cur == v {
+			return true, mid
+		} else if cur < v {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return false, left
+}
+
+// upgrade 升级到encoding宽度
+func (set *Intset) upgrade(encoding int) {
+	old, oldEncoding := set.contents, set.encoding
+	set.encoding = encoding
+	set.contents = make([]byte, set.length*encoding)
+	for i := 0; i < set.length; i += 1 {
+		set.put(i, getWithEncoding(old, i, oldEncoding))
+	}
+}
+
+// Add
+// return false if v already exists
+func (set *Intset) Add(v int64) bool {
+	if encoding := valueEncoding(v); encoding > set.encoding {
+		set.upgrade(encoding)
+	}
+	exist, pos := set.search(v)
+	if exist {
+		return false
+	}
+	set.contents = append(set.contents, make([]byte, set.encoding)...)
+	copy(set.contents[(pos+1)*set.encoding:], set.contents[pos*set.encoding:])
+	set.length += 1
+	set.put(pos, v)
+	return true
+}
+
+// Remove
+// return false if v does not exist
+func (set *Intset) Remove(v int64) bool {
+	exist, pos := set.search(v)
+	if !exist {
+		return false
+	}
+	copy(set.contents[pos*set.encoding:], set.contents[(pos+1)*set.encoding:])
+	set.length -= 1
+	set.contents = set.contents[:set.length*set.encoding]
+	return true
+}
+
+func (set *Intset) Find(v int64) bool {
+	exist, _ := set.search(v)
+	return exist
+}
+
+func (set *Intset) Len() int {
+	return set.length
+}
+
+// ForEach
+// traverse integers in ascending order, stop if fn returns false
+func (set *Intset) ForEach(fn func(v int64) bool) {
+	for i := 0; i < set.length; i += 1 {
+		if !fn(set.get(i)) {
+			return
+		}
+	}
+}
+
+// Copy 复制整个集合, 与原集合不共享内存
+func (set *Intset) Copy() *Intset {
+	contents := make([]byte, len(set.contents))
+	copy(contents, set.contents)
+	return &Intset{
+		encoding: set.encoding,
+		length:   set.length,
+		contents: contents,
+	}
+}
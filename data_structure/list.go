@@ -155,6 +155,34 @@ func (list *List) Members() []*DbObject {
 	return result
 }
 
+// ForEach
+// traverse values from head to tail, stop if fn returns false
+func (list *List) ForEach(fn func(val *DbObject) bool) {
+	for current := list.head.next; current != list.tail; current = current.next {
+		if !fn(current.val) {
+			return
+		}
+	}
+}
+
+// Walk
+// call fn on at most count values starting from node (the first one if node is nil)
+// return the node to start from in the next call, nil if all values are walked
+// the list must not be modified between calls
+func (list *List) Walk(node *Node, count int, fn func(val *DbObject)) *Node {
+	if node == nil {
+		node = list.head.next
+	}
+	for ; count > 0 && node != list.tail; count -= 1 {
+		fn(node.val)
+		node = node.next
+	}
+	if node == list.tail {
+		return nil
+	}
+	return node
+}
+
 // find O(N) return nil if not exist
 func (list *List) find(toFind *DbObject) *Node {
 	for current := list.head.next; current != list.tail; current = current.next {
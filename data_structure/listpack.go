@@ -0,0 +1,191 @@
+package data_structure
+
+import "encoding/binary"
+
+// Listpack 紧凑列表
+// 所有元素连续存储在一个[]byte中, 每个元素的格式:
+// | uvarint(len) | data | backlen |
+// backlen是前两部分的长度, 从右向左读取(每字节7位, 最高位为1表示左边还有字节), 用于反向遍历
+// 元素以在buf中的偏移量定位, 增删改都需要移动之后的元素, 只适合存储少量的小元素
+
+type Listpack struct {
+	buf    []byte
+	length int
+}
+
+func NewListpack() *Listpack {
+	return &Listpack{
+		buf:    make([]byte, 0),
+		length: 0,
+	}
+}
+
+// encodeEntry 编码一个元素
+func encodeEntry(s string) []byte {
+	entry := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(s)+binary.MaxVarintLen64)
+	n := binary.PutUvarint(entry, uint64(len(s)))
+	entry = append(entry[:n], s...)
+	return append(entry, encodeBacklen(len(entry))...)
+}
+
+// encodeBacklen 低7位在最右边的字节, 除最左边的字节外最高位都为1
+func encodeBacklen(l int) []byte {
+	groups := make([]byte, 0, 2)
+	for {
+		groups = append(groups, byte(l&127))
+		l >>= 7
+		if l == 0 {
+			break
+		}
+	}
+	result := make([]byte, len(groups))
+	for i, g := range groups {
+		if i < len(groups)-1 {
+			g |= 128
+		}
+		result[len(groups)-1-i] = g
+	}
+	return result
+}
+
+// decodeBacklen 从end(不包含)向左读取backlen, 返回backlen的值和它占用的字节数
+func (lp *Listpack) decodeBacklen(end int) (int, int) {
+	value, shift, size := 0, 0, 0
+	for {
+		end -= 1
+		size += 1
+		b := lp.buf[end]
+		value |= int(b&127) << shift
+		shift += 7
+		if b&128 == 0 {
+			return value, size
+		}
+	}
+}
+
+// entryAt 返回p处元素的数据和整个元素的长度
+func (lp *Listpack) entryAt(p int) (string, int) {
+	l, n := binary.Uvarint(lp.buf[p:])
+	start := p + n
+	end := start + int(l)
+	return string(lp.buf[start:end]), end - p + len(encodeBacklen(end-p))
+}
+
+// First
+// offset of the first entry, -1 if empty
+func (lp *Listpack) First() int {
+	if lp.length == 0 {
+		return -1
+	}
+	return 0
+}
+
+// Last
+// offset of the last entry, -1 if empty
+func (lp *Listpack) Last() int {
+	if lp.length == 0 {
+		return -1
+	}
+	return lp.Prev(len(lp.buf))
+}
+
+// Next
+// offset of the entry after p, -1 if p is the last one
+func (lp *Listpack) Next(p int) int {
+	_, size := lp.entryAt(p)
+	if p+size >= len(lp.buf) {
+		return -1
+	}
+	return p + size
+}
+
+// Prev
+// offset of the entry before p (p can be the end of buf), -1 if p is the first one
+func (lp *Listpack) Prev(p int) int {
+	if p <= 0 {
+		return -1
+	}
+	l, size := lp.decodeBacklen(p)
+	return p - size - l
+}
+
+// Get
+// value of the entry at p
+func (lp *Listpack) Get(p int) string {
+	s, _ := lp.entryAt(p)
+	return s
+}
+
+// Insert
+// insert s before the entry at p, append if p < 0 or p is the end of buf
+func (lp *Listpack) Insert(p int, s string) {
+	if p < 0 {
+		p = len(lp.buf)
+	}
+	entry := encodeEntry(s)
+	lp.buf = append(lp.buf, entry...)
+	copy(lp.buf[p+len(entry):], lp.buf[p:])
+	copy(lp.buf[p:], entry)
+	lp.length += 1
+}
+
+func (lp *Listpack) Append(s string) {
+	lp.Insert(-1, s)
+}
+
+// Delete
+// delete the entry at p, return the offset of the next entry (-1 if none)
+func (lp *Listpack) Delete(p int) int {
+	_, size := lp.entryAt(p)
+	lp.buf = append(lp.buf[:p], lp.buf[p+size:]...)
+	lp.length -= 1
+	if p >= len(lp.buf) {
+		return -1
+	}
+	return p
+}
+
+// Replace
+// replace the value of the entry at p with s
+func (lp *Listpack) Replace(p int, s string) {
+	lp.Delete(p)
+	lp.Insert(p, s)
+}
+
+// Find
+// offset of the first entry equals to s from p, every skip + 1 entries are compared, -1 if not found
+func (lp *Listpack) Find(p int, s string, skip int) int {
+	for p >= 0 {
+		if lp.Get(p) == s {
+			return p
+		}
+		for i := 0; i <= skip && p >= 0; i += 1 {
+			p = lp.Next(p)
+		}
+	}
+	return -1
+}
+
+func (lp *Listpack) Len() int {
+	return lp.length
+}
+
+// ForEach
+// traverse entries from head to tail, stop if fn returns false
+func (lp *Listpack) ForEach(fn func(s string) bool) {
+	for p := lp.First(); p >= 0; p = lp.Next(p) {
+		if !fn(lp.Get(p)) {
+			return
+		}
+	}
+}
+
+// Copy 复制整个列表, 与原列表不共享内存
+func (lp *Listpack) Copy() *Listpack {
+	buf := make([]byte, len(lp.buf))
+	copy(buf, lp.buf)
+	return &Listpack{
+		buf:    buf,
+		length: lp.length,
+	}
+}
@@ -0,0 +1,62 @@
+package data_structure
+
+import "unsafe"
+
+// memory size (bytes) of structures, used to estimate memory usage of keys
+// allocator overhead (size classes, fragmentation) is not included
+
+var (
+	ObjectSize       int64 = int64(unsafe.Sizeof(DbObject{}))
+	DictEntrySize    int64 = int64(unsafe.Sizeof(Entry{}))
+	ListNodeSize     int64 = int64(unsafe.Sizeof(Node{}))
+	SkipListNodeSize int64 = int64(unsafe.Sizeof(SkipListNode{}))
+	stringHeaderSize int64 = int64(unsafe.Sizeof(""))
+	pointerSize      int64 = int64(unsafe.Sizeof(uintptr(0)))
+	intSize          int64 = int64(unsafe.Sizeof(int64(0)))
+)
+
+// StrObjectSize
+// size of a string object, including the string header boxed in Val and the bytes
+func StrObjectSize(obj *DbObject) int64 {
+	if obj.IsIntEncoded() {
+		return ObjectSize + intSize
+	}
+	return ObjectSize + stringHeaderSize + int64(len(obj.StrVal()))
+}
+
+// MemoryUsage
+// size of the dict, its hash tables and entries, keys and values are not included
+func (dict *Dict) MemoryUsage() int64 {
+	size := int64(unsafe.Sizeof(*dict))
+	for _, table := range dict.hashTables {
+		if table != nil {
+			size += int64(unsafe.Sizeof(*table)) + table.size*pointerSize + table.used*DictEntrySize
+		}
+	}
+	return size
+}
+
+// MemoryUsage
+// size of the list and its nodes (including two dummy nodes), values are not included
+func (list *List) MemoryUsage() int64 {
+	return int64(unsafe.Sizeof(*list)) + int64(list.length+2)*ListNodeSize
+}
+
+// MemoryUsage
+// size of the skip list and its nodes, scores and values are not included
+// n is the number of nodes, which is not recorded by skip list
+func (skipList *SkipList) MemoryUsage(n int64) int64 {
+	return int64(unsafe.Sizeof(*skipList)) + (n+1)*SkipListNodeSize
+}
+
+// MemoryUsage
+// size of the intset and its contents
+func (set *Intset) MemoryUsage() int64 {
+	return int64(unsafe.Sizeof(*set)) + int64(cap(set.contents))
+}
+
+// MemoryUsage
+// size of the listpack and its buffer, entries are stored in the buffer
+func (lp *Listpack) MemoryUsage() int64 {
+	return int64(unsafe.Sizeof(*lp)) + int64(cap(lp.buf))
+}
@@ -110,6 +110,20 @@ func (skipList *SkipList) Delete(score, value *DbObject) error {
 	// else not exist
 }
 
+// RemoveFirst
+// remove the node of the lowest score, return false if the skip list is empty
+func (skipList *SkipList) RemoveFirst() bool {
+	first := skipList.root.next[0]
+	if first == nil {
+		return false
+	}
+	// levels of first start from the root
+	for i := 0; i < maxLevel && skipList.root.next[i] == first; i += 1 {
+		skipList.root.next[i] = first.next[i]
+	}
+	return true
+}
+
 // Range
 // Range values whose score is from left to right
 func (skipList *SkipList) Range(left, right *DbObject) ([]*DbObject, []*DbObject) {
@@ -130,6 +144,33 @@ func (skipList *SkipList) Range(left, right *DbObject) ([]*DbObject, []*DbObject
 	return scores, values
 }
 
+// Members
+// return all scores and values in ascending order of score
+func (skipList *SkipList) Members() ([]*DbObject, []*DbObject) {
+	scores := make([]*DbObject, 0)
+	values := make([]*DbObject, 0)
+	for current := skipList.root.next[0]; current != nil; current = current.next[0] {
+		scores = append(scores, current.score)
+		values = append(values, current.val)
+	}
+	return scores, values
+}
+
+// Walk
+// call fn on at most count nodes in ascending order of score starting from node (the first one
+// if node is nil), return the node to start from in the next call, nil if all nodes are walked
+// the skip list must not be modified between calls
+func (skipList *SkipList) Walk(node *SkipListNode, count int, fn func(score, val *DbObject)) *SkipListNode {
+	if node == nil {
+		node = skipList.root.next[0]
+	}
+	for ; count > 0 && node != nil; count -= 1 {
+		fn(node.score, node.val)
+		node = node.next[0]
+	}
+	return node
+}
+
 func (skipList *SkipList) find(score *DbObject) []*SkipListNode {
 	result := make([]*SkipListNode, maxLevel)
 	current := skipList.root
@@ -12,9 +12,11 @@ import (
 type defaultNewDataStructure func() interface{}
 
 const (
-	// default expire time : 1 hour (nano)
-	DefaultExpireTime int64 = 3600 * 1000000000
-	MaxIntegerNumber  int64 = 1 << 60
+	MaxIntegerNumber int64 = 1 << 60
+	// keys sampled in a loop of active expire cycle
+	ActiveExpireSamples int64 = 20
+	// number of databases if not configured
+	DefaultDatabases int = 16
 )
 
 var defaultDataStructure map[DbObjectType]defaultNewDataStructure
@@ -24,6 +26,14 @@ type Database struct {
 	data *Dict
 	// 过期
 	expire *Dict
+	// expired keys deleted, lazily or by active expire cycle
+	expiredKeys int64
+	// maxmemory policy, decides how access info of values is kept
+	evictionPolicy string
+	// deleted values are freed by lazyfree, nil if memory is not accounted
+	lazyfree *Lazyfree
+	// called with an expired key before it is deleted when accessed, nil if not needed
+	beforeExpire func(key *DbObject)
 }
 
 func init() {
@@ -37,12 +47,14 @@ func init() {
 
 // string
 
+// SetStr
+// expireTime < 0 means the key never expires, the old expire time is discarded
 func (db *Database) SetStr(key, value *DbObject, expireTime int64) error {
 	if err := db.doSetStr(key, value); err != nil {
 		return err
 	}
 	// set expire
-	return db.expire.Set(key, NewObjectByInt(expireTime))
+	return db.doSetExpire(key, expireTime)
 }
 
 func (db *Database) GetStr(key *DbObject) (*DbObject, error) {
@@ -114,33 +126,60 @@ func (db *Database) RenameKey(key *DbObject, newName *DbObject) error {
 	if err != nil {
 		return err
 	}
-	// key must in the db
-	expiredTime, err := db.doGetExpired(key)
-	if err != nil {
+	// -1 if the key never expires
+	expireTime := db.GetExpireTime(key)
+	// the value is moved, not freed
+	if err = db.doRemove(key); err != nil {
 		return err
 	}
-	if err = db.RemoveKey(key); err != nil {
+	// expire time of newName (if exists) is replaced
+	return db.SetKeyObject(newName, obj, expireTime)
+}
+
+// SetExpire
+// set the expire time (unix nano) of a key only if it exists in db
+func (db *Database) SetExpire(key *DbObject, expireTime int64) error {
+	obj, err := db.doGet(key)
+	if err != nil {
 		return err
 	}
-	if err = db.doSet(newName, obj); err != nil {
-		return err
+	if obj == nil {
+		return ErrorKeyNotExist
 	}
-	// set expiredTime
-	if err = db.expire.Set(newName, NewObjectByInt(expiredTime)); err != nil {
-		return err
+	return db.expire.Set(key, NewObjectByInt(expireTime))
+}
+
+// Persist
+// remove the expire time of a key, return false if the key does not exist or never expires
+func (db *Database) Persist(key *DbObject) bool {
+	if ext, _ := db.Exist(key); !ext {
+		return false
 	}
-	return nil
+	if _, err := db.expire.Get(key); err != nil {
+		return false
+	}
+	return db.expire.Delete(key) == nil
 }
 
 // RemoveKey
-// remove a key only if it exists in db
+// remove a key only if it exists in db, the value is freed in background if LazyUserDel
 func (db *Database) RemoveKey(key *DbObject) error {
+	return db.removeKey(key, db.lazyfree.Options().LazyUserDel)
+}
+
+// UnlinkKey
+// remove a key only if it exists in db, a large value is always freed in background
+func (db *Database) UnlinkKey(key *DbObject) error {
+	return db.removeKey(key, true)
+}
+
+func (db *Database) removeKey(key *DbObject, async bool) error {
 	ext, err := db.Exist(key)
 	if err != nil {
 		return err
 	}
 	if ext {
-		if err = db.doRemove(key); err != nil {
+		if err = db.doFree(key, async); err != nil {
 			return err
 		}
 		return nil
@@ -148,6 +187,130 @@ func (db *Database) RemoveKey(key *DbObject) error {
 	return ErrorKeyNotExist
 }
 
+// Flush
+// remove all keys, return the number of keys removed
+// dicts are detached in O(1), and freed in background if async
+// dicts still walked by background snapshots (rehash paused) are freed when the snapshots finish
+func (db *Database) Flush(async bool) int64 {
+	data, expire := db.data, db.expire
+	removed := data.Len()
+	db.data = NewDict(StrHash, StrEqual)
+	db.expire = NewDict(StrHash, StrEqual)
+	if !data.RehashPaused() {
+		db.lazyfree.Free(NewObject(DICT, data), async)
+		db.lazyfree.Free(NewObject(DICT, expire), async)
+	}
+	return removed
+}
+
+// View
+// a database sharing the dicts of db, it keeps the keys of db at this time
+// after db is flushed or swapped, used by background snapshots
+func (db *Database) View() *Database {
+	return &Database{
+		data:           db.data,
+		expire:         db.expire,
+		evictionPolicy: db.evictionPolicy,
+		lazyfree:       db.lazyfree,
+	}
+}
+
+// Shares
+// whether db and other share the same dicts
+func (db *Database) Shares(other *Database) bool {
+	return db.data == other.data
+}
+
+// MoveKey
+// move key and its expire time to target, return false if key does not exist or target has it
+func (db *Database) MoveKey(key *DbObject, target *Database) (bool, error) {
+	val, err := db.GetKey(key)
+	if err != nil {
+		return false, nil
+	}
+	if _, err = target.GetKey(key); err == nil {
+		return false, nil
+	}
+	expireTime := db.GetExpireTime(key)
+	// the value is moved, not freed
+	if err = db.doRemove(key); err != nil {
+		return false, err
+	}
+	return true, target.SetKeyObject(key, val, expireTime)
+}
+
+// CopyKey
+// copy the value and expire time of key to newKey of target (may be db itself)
+// return false if key does not exist, or newKey exists and replace is false
+func (db *Database) CopyKey(key *DbObject, target *Database, newKey *DbObject, replace bool) (bool, error) {
+	val, err := db.GetKey(key)
+	if err != nil {
+		return false, nil
+	}
+	if _, err = target.GetKey(newKey); err == nil {
+		if !replace {
+			return false, nil
+		}
+		if err = target.removeKey(newKey, target.lazyfree.Options().LazyUserDel); err != nil {
+			return false, err
+		}
+	}
+	return true, target.SetKeyObject(newKey, CloneValue(val), db.GetExpireTime(key))
+}
+
+// RenameKeyIfNotExist
+// rename key only if newName does not exist, return false if newName exists
+func (db *Database) RenameKeyIfNotExist(key *DbObject, newName *DbObject) (bool, error) {
+	if _, err := db.GetKey(key); err != nil {
+		return false, err
+	}
+	if ext, _ := db.Exist(newName); ext {
+		return false, nil
+	}
+	return true, db.RenameKey(key, newName)
+}
+
+// RandomKey
+// a random key which is not expired, expired keys sampled are deleted
+// return nil if the database is empty, or no alive key is found within MaxRandomGetAttempt
+func (db *Database) RandomKey() *DbObject {
+	for i := 0; i < MaxRandomGetAttempt; i += 1 {
+		entry := db.data.RandomGet()
+		if entry == nil {
+			return nil
+		}
+		if !db.deleteIfExpired(entry.Key()) {
+			return entry.Key()
+		}
+	}
+	return nil
+}
+
+// Swap
+// swap keys of two databases, used by SWAPDB
+// clients keep the index of their database, so they see the keys of the other one after swapping
+func (db *Database) Swap(other *Database) {
+	db.data, other.data = other.data, db.data
+	db.expire, other.expire = other.expire, db.expire
+}
+
+// SetLazyfree
+// values deleted are freed by lazyfree
+func (db *Database) SetLazyfree(lazyfree *Lazyfree) {
+	db.lazyfree = lazyfree
+}
+
+func (db *Database) Lazyfree() *Lazyfree {
+	return db.lazyfree
+}
+
+// SetExpireHook
+// beforeExpire is called with every expired key deleted when it is accessed,
+// like beforeDelete of ActiveExpireCycle
+func (db *Database) SetExpireHook(beforeExpire func(key *DbObject)) {
+	db.beforeExpire = beforeExpire
+}
+
 // GetKeyIfExist
 // get the value of key in db only if it exists now
 func (db *Database) GetKeyIfExist(key *DbObject, expectedType DbObjectType) (*DbObject, error) {
@@ -158,6 +321,16 @@ func (db *Database) GetKeyIfExist(key *DbObject, expectedType DbObjectType) (*Db
 	return obj, nil
 }
 
+// GetKey
+// get the value of key whatever its type is, only if it exists now
+func (db *Database) GetKey(key *DbObject) (*DbObject, error) {
+	obj, _ := db.doGet(key)
+	if obj == nil {
+		return nil, ErrorKeyNotExist
+	}
+	return obj, nil
+}
+
 // GetKeyObject
 // if key not exist, then add a default key (except string key)
 func (db *Database) GetKeyObject(key *DbObject, expectedType DbObjectType) (*DbObject, error) {
@@ -165,7 +338,7 @@ func (db *Database) GetKeyObject(key *DbObject, expectedType DbObjectType) (*DbO
 	if err != nil || obj == nil {
 		// add when not exist or expired
 		if errors.Is(err, ErrorKeyNotExist) || err == util.ERROR_EXPIRED {
-			obj, err = db.doAddDefault(key, expectedType, DefaultExpireTime+getTime())
+			obj, err = db.doAddDefault(key, expectedType)
 			if err != nil {
 				return nil, err
 			}
@@ -176,6 +349,177 @@ func (db *Database) GetKeyObject(key *DbObject, expectedType DbObjectType) (*DbO
 	return obj, nil
 }
 
+// ForEach
+// traverse all keys which are not expired, stop if fn returns false
+// expireTime is -1 if the key has no expire time
+// keys can be deleted during traversing, rehash is paused until the traversal ends
+func (db *Database) ForEach(fn func(key, val *DbObject, expireTime int64) bool) {
+	current := getTime()
+	it := db.data.NewSafeIterator()
+	defer it.Release()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		expireTime, err := db.doGetExpired(entry.Key())
+		if err != nil {
+			expireTime = -1
+		} else if current >= expireTime {
+			// expired, skip
+			continue
+		}
+		if !fn(entry.Key(), entry.Val(), expireTime) {
+			return
+		}
+	}
+}
+
+// WalkKeys
+// traverse keys of at most count buckets of data dict starting from cursor, see Dict.WalkBuckets
+// expired keys are not filtered, expireTime is -1 if the key has no expire time
+func (db *Database) WalkKeys(cursor int64, count int, fn func(key, val *DbObject, expireTime int64)) (int64, bool) {
+	return db.data.WalkBuckets(cursor, count, func(key, val *DbObject) {
+		fn(key, val, db.GetExpireTime(key))
+	})
+}
+
+// Scan
+// scan keys from cursor, see Dict.Scan, expireTime is -1 if the key has no expire time
+// expired keys are not filtered, fn must not modify the database
+func (db *Database) Scan(cursor uint64, count int, fn func(key, val *DbObject, expireTime int64)) uint64 {
+	return scanDict(db.data, cursor, count, func(key, val *DbObject) {
+		fn(key, val, db.GetExpireTime(key))
+	})
+}
+
+// KeyPosition
+// the bucket position of key in data dict, -1 if not exist
+func (db *Database) KeyPosition(key *DbObject) int64 {
+	return db.data.Position(key)
+}
+
+// Peek
+// get value and expire time of key without any side effect (expired key will not be deleted)
+// return nil if key does not exist
+func (db *Database) Peek(key *DbObject) (*DbObject, int64) {
+	val, _ := db.data.Get(key)
+	if val == nil {
+		return nil, -1
+	}
+	return val, db.GetExpireTime(key)
+}
+
+// PeekAlive
+// get value of key without any side effect, return nil if key does not exist or is expired
+// used by commands inspecting keys, access info of the key is not updated
+func (db *Database) PeekAlive(key *DbObject) *DbObject {
+	val, expireTime := db.Peek(key)
+	if val == nil || (expireTime >= 0 && getTime() >= expireTime) {
+		return nil
+	}
+	return val
+}
+
+// GetExpireTime
+// expire time (unix nano) of key, -1 if the key has no expire time
+func (db *Database) GetExpireTime(key *DbObject) int64 {
+	expireTime, err := db.doGetExpired(key)
+	if err != nil {
+		return -1
+	}
+	return expireTime
+}
+
+// PauseRehash
+// keep keys of data dict in their buckets, used by background snapshot
+func (db *Database) PauseRehash() {
+	db.data.PauseRehash()
+}
+
+func (db *Database) ResumeRehash() {
+	db.data.ResumeRehash()
+}
+
+// IncrementallyRehash
+// rehash data dict, or expire dict if data dict is not rehashing, within budget
+// return whether any rehash work is done
+func (db *Database) IncrementallyRehash(budget time.Duration) bool {
+	if db.data.RehashFor(budget) {
+		return true
+	}
+	return db.expire.RehashFor(budget)
+}
+
+// SetKeyObject
+// set a value object of key directly, used when loading persistence files
+// if key already exists, replace it
+// expireTime < 0 means the key never expires
+func (db *Database) SetKeyObject(key, val *DbObject, expireTime int64) error {
+	if err := db.doSet(key, val); err != nil {
+		return err
+	}
+	return db.doSetExpire(key, expireTime)
+}
+
+// ActiveExpireCycle
+// sample keys with an expire time and delete the expired ones,
+// sample again while more than a quarter of the sample was expired and deadline is not reached
+// beforeDelete is called with every expired key before it is deleted
+// return the number of deleted keys
+func (db *Database) ActiveExpireCycle(deadline time.Time, beforeDelete func(key *DbObject)) int64 {
+	var deleted int64 = 0
+	for {
+		samples := db.expire.Len()
+		if samples == 0 {
+			break
+		}
+		if samples > ActiveExpireSamples {
+			samples = ActiveExpireSamples
+		}
+		current := getTime()
+		var expired int64 = 0
+		for i := int64(0); i < samples; i += 1 {
+			entry := db.expire.RandomGet()
+			if entry == nil {
+				break
+			}
+			expireTime, _ := entry.Val().IntVal()
+			if current < expireTime {
+				continue
+			}
+			key := entry.Key()
+			if beforeDelete != nil {
+				beforeDelete(key)
+			}
+			if err := db.doFree(key, db.lazyfree.Options().LazyExpire); err != nil {
+				break
+			}
+			expired += 1
+		}
+		deleted += expired
+		db.expiredKeys += expired
+		if expired*4 <= samples || !time.Now().Before(deadline) {
+			break
+		}
+	}
+	return deleted
+}
+
+// ExpiredKeys
+// the number of expired keys deleted since the database is created
+func (db *Database) ExpiredKeys() int64 {
+	return db.expiredKeys
+}
+
+// Size
+// the number of keys in database (including expired keys not deleted yet)
+func (db *Database) Size() int64 {
+	return db.data.Len()
+}
+
+// Expires
+// the number of keys with an expire time
+func (db *Database) Expires() int64 {
+	return db.expire.Len()
+}
+
 // doSetStr
 // if the key exist, do update; otherwise, do add
 func (db *Database) doSetStr(key, val *DbObject) error {
@@ -186,6 +530,15 @@ func (db *Database) doSetStr(key, val *DbObject) error {
 	if err != nil && err != ErrorKeyNotExist {
 		return err
 	}
+	// integers are stored compactly
+	val.TryIntEncoding()
+	if oldVal != nil {
+		// overwriting is an access of the key
+		val.Lru = oldVal.Lru
+		db.touch(val)
+	} else {
+		db.initAccess(val)
+	}
 	err = db.data.Set(key, val)
 	if err != nil {
 		return err
@@ -203,6 +556,7 @@ func (db *Database) doGet(key *DbObject) (*DbObject, error) {
 	if db.deleteIfExpired(key) {
 		return nil, util.ERROR_EXPIRED
 	}
+	db.touch(val)
 	return val, err
 }
 
@@ -219,6 +573,7 @@ func (db *Database) doGetByType(key *DbObject, expectedType DbObjectType) (*DbOb
 	if db.deleteIfExpired(key) {
 		return nil, util.ERROR_EXPIRED
 	}
+	db.touch(val)
 	return val, nil
 }
 
@@ -231,9 +586,9 @@ func (db *Database) doGetExpired(key *DbObject) (int64, error) {
 	return expired.IntVal()
 }
 
-// add an empty data structure of key to database
-// if success, return the obj added
-func (db *Database) doAddDefault(key *DbObject, expectedType DbObjectType, expireTime int64) (*DbObject, error) {
+// doAddDefault
+// add an empty value of expectedType, the key never expires
+func (db *Database) doAddDefault(key *DbObject, expectedType DbObjectType) (*DbObject, error) {
 	_, exist := defaultDataStructure[expectedType]
 	if !exist {
 		return nil, errors.New("Illegal value type")
@@ -241,21 +596,45 @@ func (db *Database) doAddDefault(key *DbObject, expectedType DbObjectType, expir
 	var defaultFunc defaultNewDataStructure = defaultDataStructure[expectedType]
 	ds := defaultFunc()
 	obj := NewObject(expectedType, ds)
+	db.initAccess(obj)
 	if err := db.data.Set(key, obj); err != nil {
 		return nil, err
 	}
-	// expired time
-	db.expire.Set(key, NewObjectByInt(expireTime))
+	// expire time left by an old key
+	db.doSetExpire(key, -1)
 	return obj, nil
 }
 
 // doRemove
-// remove the key if it exists
+// remove the key if it exists, the key may have no expire time
 func (db *Database) doRemove(key *DbObject) error {
 	if err := db.data.Delete(key); err != nil {
 		return err
 	}
-	if err := db.expire.Delete(key); err != nil {
+	return db.doSetExpire(key, -1)
+}
+
+// doFree
+// remove the key and free its value
+func (db *Database) doFree(key *DbObject, async bool) error {
+	val, err := db.data.Get(key)
+	if err != nil {
+		return err
+	}
+	if err = db.doRemove(key); err != nil {
+		return err
+	}
+	db.lazyfree.Free(val, async)
+	return nil
+}
+
+// doSetExpire
+// set the expire time of key, expireTime < 0 means the key never expires
+func (db *Database) doSetExpire(key *DbObject, expireTime int64) error {
+	if expireTime >= 0 {
+		return db.expire.Set(key, NewObjectByInt(expireTime))
+	}
+	if err := db.expire.Delete(key); err != nil && !errors.Is(err, ErrorKeyNotExist) {
 		return err
 	}
 	return nil
@@ -265,6 +644,11 @@ func (db *Database) doRemove(key *DbObject) error {
 // do set key and value
 // if key already exists, update it whatever
 func (db *Database) doSet(key, val *DbObject) error {
+	if val.Lru == 0 {
+		// a value moved from another key keeps its access info
+		db.initAccess(val)
+	}
+	val.TryIntEncoding()
 	return db.data.Set(key, val)
 }
 
@@ -273,14 +657,19 @@ func (db *Database) deleteIfExpired(key *DbObject) bool {
 	current := getTime()
 	if key != nil {
 		expire, _ := db.expire.Get(key)
+		if expire == nil {
+			// never expires
+			return false
+		}
 		expireTime, _ := expire.IntVal()
 		if current >= expireTime {
-			if err := db.data.Delete(key); err != nil {
-				return false
+			if db.beforeExpire != nil {
+				db.beforeExpire(key)
 			}
-			if err := db.expire.Delete(key); err != nil {
+			if err := db.doFree(key, db.lazyfree.Options().LazyExpire); err != nil {
 				return false
 			}
+			db.expiredKeys += 1
 			return true
 		}
 	}
@@ -0,0 +1,62 @@
+package db
+
+import . "goRedis/data_structure"
+
+// value encoding core lib
+// small values are stored in compact encodings (intset, listpack), and converted to the
+// normal structures once they grow past the thresholds, conversion is never reverted
+
+const (
+	EncodingInt        string = "int"
+	EncodingRaw        string = "raw"
+	EncodingListpack   string = "listpack"
+	EncodingLinkedList string = "linkedlist"
+	EncodingIntset     string = "intset"
+	EncodingHashtable  string = "hashtable"
+	EncodingSkipList   string = "skiplist"
+)
+
+// thresholds of compact encodings, set from config at startup
+// entries: max number of elements (fields of hash, members of zset)
+// value: max length (bytes) of every element
+var (
+	ListMaxListpackEntries int = 128
+	ListMaxListpackValue   int = 64
+	SetMaxIntsetEntries    int = 512
+	HashMaxListpackEntries int = 128
+	HashMaxListpackValue   int = 64
+	ZsetMaxListpackEntries int = 128
+	ZsetMaxListpackValue   int = 64
+)
+
+// Encoding
+// the encoding of a value in database
+func Encoding(val *DbObject) string {
+	switch val.Type {
+	case STR:
+		if val.IsIntEncoded() {
+			return EncodingInt
+		}
+		return EncodingRaw
+	case LINKDLIST:
+		return val.Val.(*LinkedList).Encoding()
+	case SET:
+		return val.Val.(*Set).Encoding()
+	case HASH:
+		return val.Val.(*Hash).Encoding()
+	case ZSET:
+		return val.Val.(*Zset).Encoding()
+	}
+	return ""
+}
+
+// canonicalInt
+// parse s as an integer only if s is the canonical form of the integer
+func canonicalInt(s string) (int64, bool) {
+	obj := NewStr(s).TryIntEncoding()
+	if !obj.IsIntEncoded() {
+		return 0, false
+	}
+	v, _ := obj.IntVal()
+	return v, true
+}
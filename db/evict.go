@@ -0,0 +1,322 @@
+package db
+
+import (
+	. "goRedis/data_structure"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// eviction core lib
+// keys are evicted by approximated LRU / LFU / TTL like Redis:
+// some keys of every database are sampled, the best candidates are kept in a pool across
+// evictions and the best one in the pool is evicted
+
+// maxmemory policies
+const (
+	NoEviction     string = "noeviction"
+	AllKeysLru     string = "allkeys-lru"
+	VolatileLru    string = "volatile-lru"
+	AllKeysLfu     string = "allkeys-lfu"
+	VolatileLfu    string = "volatile-lfu"
+	AllKeysRandom  string = "allkeys-random"
+	VolatileRandom string = "volatile-random"
+	VolatileTtl    string = "volatile-ttl"
+)
+
+const (
+	// candidates kept in the eviction pool
+	EvictionPoolSize int = 16
+	// keys sampled from every database when populating the pool
+	DefaultEvictionSamples int = 5
+	// populating attempts of an eviction, RandomGet may fail on sparse dicts
+	maxEvictionAttempts int = 16
+	// LRU clock, 24 bits with resolution of 1s
+	LruClockMax        uint32 = 1<<24 - 1
+	LruClockResolution int64  = 1000
+	// LFU counter, increased logarithmically and decreased by 1 every LfuDecayTime minutes
+	LfuInitVal   uint32  = 5
+	LfuLogFactor float64 = 10
+	LfuDecayTime uint32  = 1
+)
+
+// ValidEvictionPolicy
+// judge whether policy is a maxmemory policy
+func ValidEvictionPolicy(policy string) bool {
+	switch policy {
+	case NoEviction, AllKeysLru, VolatileLru, AllKeysLfu, VolatileLfu, AllKeysRandom, VolatileRandom, VolatileTtl:
+		return true
+	}
+	return false
+}
+
+func isLfuPolicy(policy string) bool {
+	return policy == AllKeysLfu || policy == VolatileLfu
+}
+
+func isVolatilePolicy(policy string) bool {
+	return policy == VolatileLru || policy == VolatileLfu || policy == VolatileRandom || policy == VolatileTtl
+}
+
+// SetEvictionPolicy
+// access info of values is kept as LFU data with LFU policies, otherwise as LRU clock
+func (db *Database) SetEvictionPolicy(policy string) {
+	db.evictionPolicy = policy
+}
+
+// UsesLfu
+// judge whether access info of values is kept as LFU data
+func (db *Database) UsesLfu() bool {
+	return isLfuPolicy(db.evictionPolicy)
+}
+
+// initAccess
+// init access info of a value added to database
+func (db *Database) initAccess(val *DbObject) {
+	if isLfuPolicy(db.evictionPolicy) {
+		val.Lru = lfuTimeInMinutes()<<8 | LfuInitVal
+	} else {
+		val.Lru = LruClock()
+	}
+}
+
+// touch
+// update access info of a value when it is accessed
+func (db *Database) touch(val *DbObject) {
+	if isLfuPolicy(db.evictionPolicy) {
+		counter := lfuLogIncr(lfuDecrAndReturn(val))
+		val.Lru = lfuTimeInMinutes()<<8 | counter
+	} else {
+		val.Lru = LruClock()
+	}
+}
+
+// LRU
+
+func LruClock() uint32 {
+	return uint32(time.Now().UnixNano()/int64(time.Millisecond)/LruClockResolution) & LruClockMax
+}
+
+// IdleTime
+// ms since the value is accessed, only meaningful if access info is LRU clock
+func IdleTime(val *DbObject) int64 {
+	clock := LruClock()
+	if clock >= val.Lru {
+		return int64(clock-val.Lru) * LruClockResolution
+	}
+	// wrapped around
+	return int64(LruClockMax-val.Lru+clock) * LruClockResolution
+}
+
+// LFU
+
+func lfuTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & math.MaxUint16
+}
+
+// lfuTimeElapsed
+// minutes since ldt
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return math.MaxUint16 - ldt + now
+}
+
+// lfuLogIncr
+// the greater the counter is, the less likely it is increased
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := float64(counter) - float64(LfuInitVal)
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1.0/(base*LfuLogFactor+1) {
+		counter += 1
+	}
+	return counter
+}
+
+// lfuDecrAndReturn
+// counter decreased by the time elapsed since the last decrement, val is not modified
+func lfuDecrAndReturn(val *DbObject) uint32 {
+	counter := val.Lru & math.MaxUint8
+	periods := lfuTimeElapsed(val.Lru>>8) / LfuDecayTime
+	if periods > counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// Frequency
+// logarithmic access frequency, only meaningful if access info is LFU data
+func Frequency(val *DbObject) uint32 {
+	return lfuDecrAndReturn(val)
+}
+
+// eviction pool
+
+type evictionCandidate struct {
+	db  *Database
+	key *DbObject
+	// the greater the better to evict
+	score uint64
+}
+
+// Evictor
+// eviction pool shared by all databases
+type Evictor struct {
+	// ascending order of score
+	pool    []*evictionCandidate
+	samples int
+	// database where the random policies start looking for a key, in round robin
+	nextDb int
+}
+
+func NewEvictor(samples int) *Evictor {
+	if samples <= 0 {
+		samples = DefaultEvictionSamples
+	}
+	return &Evictor{
+		pool:    make([]*evictionCandidate, 0, EvictionPoolSize),
+		samples: samples,
+	}
+}
+
+// Evict
+// choose a key of dbs by policy and delete it, beforeDelete is called before the key is deleted
+// return the database, key and value evicted, db is nil if there is no key to evict
+func (evictor *Evictor) Evict(dbs []*Database, policy string, beforeDelete func(db *Database, key, val *DbObject)) (*Database, *DbObject, *DbObject) {
+	if policy == NoEviction {
+		return nil, nil, nil
+	}
+	for attempt := 0; attempt < maxEvictionAttempts; attempt += 1 {
+		keys := int64(0)
+		for _, db := range dbs {
+			keys += db.evictionDict(policy).Len()
+		}
+		if keys == 0 {
+			return nil, nil, nil
+		}
+		db, key := evictor.choose(dbs, policy)
+		if db == nil {
+			continue
+		}
+		val, err := db.data.Get(key)
+		if err != nil {
+			continue
+		}
+		if beforeDelete != nil {
+			beforeDelete(db, key, val)
+		}
+		if err = db.doFree(key, db.lazyfree.Options().LazyEviction); err != nil {
+			continue
+		}
+		return db, key, val
+	}
+	return nil, nil, nil
+}
+
+// choose
+// choose a key to evict, nil if failed
+func (evictor *Evictor) choose(dbs []*Database, policy string) (*Database, *DbObject) {
+	if policy == AllKeysRandom || policy == VolatileRandom {
+		// any key of the next database which is not empty, so that evictions are spread over databases
+		for i := 0; i < len(dbs); i += 1 {
+			evictor.nextDb = (evictor.nextDb + 1) % len(dbs)
+			db := dbs[evictor.nextDb]
+			if db.evictionDict(policy).Len() == 0 {
+				continue
+			}
+			if entry := db.evictionDict(policy).RandomGet(); entry != nil {
+				return db, entry.Key()
+			}
+		}
+		return nil, nil
+	}
+	for _, db := range dbs {
+		evictor.populate(db, policy)
+	}
+	// the best candidate still in its database
+	for len(evictor.pool) > 0 {
+		best := evictor.pool[len(evictor.pool)-1]
+		evictor.pool = evictor.pool[:len(evictor.pool)-1]
+		if ext, _ := best.db.evictionDict(policy).Exist(best.key); ext {
+			return best.db, best.key
+		}
+	}
+	return nil, nil
+}
+
+// populate
+// sample keys of db and insert them into the pool
+func (evictor *Evictor) populate(db *Database, policy string) {
+	dict := db.evictionDict(policy)
+	samples := int64(evictor.samples)
+	if dict.Len() < samples {
+		samples = dict.Len()
+	}
+	for i := int64(0); i < samples; i += 1 {
+		entry := dict.RandomGet()
+		if entry == nil {
+			continue
+		}
+		val := entry.Val()
+		if dict == db.expire {
+			// the value of expire dict is expire time
+			if val, _ = db.data.Get(entry.Key()); val == nil {
+				continue
+			}
+		}
+		var score uint64
+		switch policy {
+		case AllKeysLru, VolatileLru:
+			score = uint64(IdleTime(val))
+		case AllKeysLfu, VolatileLfu:
+			score = uint64(math.MaxUint8 - lfuDecrAndReturn(val))
+		case VolatileTtl:
+			// the sooner the key expires the better
+			expireTime, _ := entry.Val().IntVal()
+			score = math.MaxUint64 - uint64(expireTime)
+		}
+		evictor.insert(&evictionCandidate{db: db, key: entry.Key(), score: score})
+	}
+}
+
+// insert
+// insert a candidate in order, the worst one is dropped if the pool is full
+func (evictor *Evictor) insert(candidate *evictionCandidate) {
+	for _, c := range evictor.pool {
+		if c.db == candidate.db && StrEqual(c.key, candidate.key) {
+			return
+		}
+	}
+	index := 0
+	for index < len(evictor.pool) && evictor.pool[index].score < candidate.score {
+		index += 1
+	}
+	if len(evictor.pool) == EvictionPoolSize {
+		if index == 0 {
+			// worse than all candidates
+			return
+		}
+		// drop the worst one
+		evictor.pool = evictor.pool[1:]
+		index -= 1
+	}
+	evictor.pool = append(evictor.pool, nil)
+	copy(evictor.pool[index+1:], evictor.pool[index:])
+	evictor.pool[index] = candidate
+}
+
+// evictionDict
+// keys of expire dict are evicted by volatile policies
+func (db *Database) evictionDict(policy string) *Dict {
+	if isVolatilePolicy(policy) {
+		return db.expire
+	}
+	return db.data
+}
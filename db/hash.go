@@ -7,14 +7,21 @@ import (
 
 // Hash Key
 // Both key and value must be string
+// small hashes are stored in a listpack as field, value pairs, converted to dict when they grow
+
+var (
+	errorFieldNotExist error = errors.New("Field does not exist in the hash key")
+)
 
 type Hash struct {
 	data *Dict
+	// not nil if the hash is listpack encoded
+	packed *Listpack
 }
 
 func NewHash() *Hash {
 	return &Hash{
-		data: NewDict(StrHash, StrEqual),
+		packed: NewListpack(),
 	}
 }
 
@@ -22,10 +29,34 @@ func NewDefaultHashValueObj() interface{} {
 	return NewHash()
 }
 
+// findField
+// offset of field in the listpack, -1 if not found
+func (hash *Hash) findField(key *DbObject) int {
+	return hash.packed.Find(hash.packed.First(), key.StrVal(), 1)
+}
+
+// convert
+// convert the listpack to dict
+func (hash *Hash) convert() {
+	packed := hash.packed
+	hash.packed = nil
+	hash.data = NewDict(StrHash, StrEqual)
+	for p := packed.First(); p >= 0; p = packed.Next(packed.Next(p)) {
+		hash.data.Set(NewStr(packed.Get(p)), NewStr(packed.Get(packed.Next(p))))
+	}
+}
+
 func (hash *Hash) Get(key *DbObject) (*DbObject, error) {
+	if hash.packed != nil {
+		p := hash.findField(key)
+		if p < 0 {
+			return nil, errorFieldNotExist
+		}
+		return NewStr(hash.packed.Get(hash.packed.Next(p))), nil
+	}
 	obj, err := hash.data.Get(key)
 	if errors.Is(err, ErrorKeyNotExist) {
-		return nil, errors.New("Field does not exist in the hash key")
+		return nil, errorFieldNotExist
 	}
 	return obj, err
 }
@@ -34,13 +65,100 @@ func (hash *Hash) Set(key, val *DbObject) error {
 	if val == nil || val.Type != STR {
 		return errors.New("Illegal value type, the value of hash key must be STR")
 	}
+	if hash.packed != nil {
+		p := hash.findField(key)
+		if p >= 0 && len(val.StrVal()) <= HashMaxListpackValue {
+			hash.packed.Replace(hash.packed.Next(p), val.StrVal())
+			return nil
+		}
+		if p < 0 && int(hash.Len()) < HashMaxListpackEntries &&
+			len(key.StrVal()) <= HashMaxListpackValue && len(val.StrVal()) <= HashMaxListpackValue {
+			hash.packed.Append(key.StrVal())
+			hash.packed.Append(val.StrVal())
+			return nil
+		}
+		hash.convert()
+	}
 	return hash.data.Set(key, val)
 }
 
 func (hash *Hash) Delete(key *DbObject) error {
+	if hash.packed != nil {
+		p := hash.findField(key)
+		if p < 0 {
+			return errorFieldNotExist
+		}
+		// field and value
+		hash.packed.Delete(hash.packed.Delete(p))
+		return nil
+	}
 	err := hash.data.Delete(key)
 	if errors.Is(err, ErrorKeyNotExist) {
-		return errors.New("Field does not exist in the hash key")
+		return errorFieldNotExist
 	}
 	return err
 }
+
+// ForEach
+// traverse all fields and values of the hash key, stop if fn returns false
+func (hash *Hash) ForEach(fn func(field, value *DbObject) bool) {
+	if hash.packed != nil {
+		for p := hash.packed.First(); p >= 0; p = hash.packed.Next(hash.packed.Next(p)) {
+			if !fn(NewStr(hash.packed.Get(p)), NewStr(hash.packed.Get(hash.packed.Next(p)))) {
+				return
+			}
+		}
+		return
+	}
+	hash.data.Iterate(fn)
+}
+
+// Scan
+// scan fields and values from cursor, all of them are returned if the hash is listpack encoded
+func (hash *Hash) Scan(cursor uint64, count int, fn func(field, value *DbObject)) uint64 {
+	if hash.packed != nil {
+		hash.ForEach(func(field, value *DbObject) bool {
+			fn(field, value)
+			return true
+		})
+		return 0
+	}
+	return scanDict(hash.data, cursor, count, fn)
+}
+
+// MemoryUsage
+// size of dict (fields and values are not included), or the listpack
+func (hash *Hash) MemoryUsage() int64 {
+	if hash.packed != nil {
+		return hash.packed.MemoryUsage()
+	}
+	return hash.data.MemoryUsage()
+}
+
+func (hash *Hash) Len() int64 {
+	if hash.packed != nil {
+		return int64(hash.packed.Len() / 2)
+	}
+	return hash.data.Len()
+}
+
+func (hash *Hash) Encoding() string {
+	if hash.packed != nil {
+		return EncodingListpack
+	}
+	return EncodingHashtable
+}
+
+// Clone
+// a deep copy of the hash with the same encoding
+func (hash *Hash) Clone() *Hash {
+	if hash.packed != nil {
+		return &Hash{packed: hash.packed.Copy()}
+	}
+	clone := &Hash{data: NewDict(StrHash, StrEqual)}
+	hash.data.Iterate(func(field, value *DbObject) bool {
+		clone.data.Set(NewStr(field.StrVal()), NewStr(value.StrVal()))
+		return true
+	})
+	return clone
+}
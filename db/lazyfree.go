@@ -0,0 +1,198 @@
+package db
+
+import (
+	. "goRedis/data_structure"
+	"runtime"
+	"sync/atomic"
+)
+
+// lazy free core lib
+// memory of a deleted value is reclaimed by go GC, the value is detached from the keyspace in O(1)
+// and its size is estimated by sampling, reported to memory accounting (used memory minus memory
+// not yet reclaimed). large values are handed to a background goroutine which dismantles them,
+// unlinking their nodes and entries in batches (O(n)) so GC reclaims a plain heap of small objects
+// a value handed to the goroutine must not be referenced by the keyspace any more
+
+const (
+	// values with more elements are freed in background
+	LazyfreeThreshold int64 = 64
+	// values waiting for the goroutine, freed inline if the queue is full
+	maxLazyfreeJobs int = 1 << 16
+	// nodes or buckets unlinked by the goroutine before yielding
+	lazyfreeBatch int = 1024
+)
+
+// LazyfreeOptions
+// whether values deleted by eviction, expiration, DEL and FLUSHALL/FLUSHDB are freed in background
+// UNLINK and FLUSHALL/FLUSHDB ASYNC always free values in background
+type LazyfreeOptions struct {
+	LazyEviction  bool
+	LazyExpire    bool
+	LazyUserDel   bool
+	LazyUserFlush bool
+}
+
+// lazyfreeJob
+// a value and its size estimated when it is detached
+type lazyfreeJob struct {
+	val  *DbObject
+	size int64
+}
+
+type Lazyfree struct {
+	options LazyfreeOptions
+	jobs    chan lazyfreeJob
+	// accessed by both event loop and the goroutine
+	pending    int64
+	freed      int64
+	freedBytes int64
+}
+
+func NewLazyfree(options LazyfreeOptions) *Lazyfree {
+	lazyfree := &Lazyfree{
+		options: options,
+		jobs:    make(chan lazyfreeJob, maxLazyfreeJobs),
+	}
+	go lazyfree.freeLoop()
+	return lazyfree
+}
+
+// Options
+// options of a nil Lazyfree are all false
+func (lazyfree *Lazyfree) Options() LazyfreeOptions {
+	if lazyfree == nil {
+		return LazyfreeOptions{}
+	}
+	return lazyfree.options
+}
+
+// FreeEffort
+// number of allocations to traverse when freeing a value
+func FreeEffort(val *DbObject) int64 {
+	if compactEncoded(val) {
+		return 1
+	}
+	switch v := val.Val.(type) {
+	case *LinkedList:
+		return int64(v.Len())
+	case *Set:
+		return int64(v.Length())
+	case *Hash:
+		return v.Len()
+	case *Zset:
+		return v.Len()
+	case *Dict:
+		return v.Len()
+	}
+	return 1
+}
+
+// Free
+// free a value detached from the keyspace, in background if async and the value is large
+// values are dropped without accounting if lazyfree is nil
+func (lazyfree *Lazyfree) Free(val *DbObject, async bool) {
+	if lazyfree == nil || val == nil {
+		return
+	}
+	// the same estimate whether the value is freed inline or in background
+	size := ValueSize(val, DefaultSizeSamples)
+	if async && FreeEffort(val) > LazyfreeThreshold {
+		select {
+		case lazyfree.jobs <- lazyfreeJob{val: val, size: size}:
+			atomic.AddInt64(&lazyfree.pending, 1)
+			return
+		default:
+		}
+	}
+	lazyfree.release(size)
+}
+
+// release
+// account memory of a dropped value
+func (lazyfree *Lazyfree) release(size int64) {
+	atomic.AddInt64(&lazyfree.freedBytes, size)
+	atomic.AddInt64(&lazyfree.freed, 1)
+}
+
+func (lazyfree *Lazyfree) freeLoop() {
+	for job := range lazyfree.jobs {
+		dismantle(job.val)
+		lazyfree.release(job.size)
+		atomic.AddInt64(&lazyfree.pending, -1)
+	}
+}
+
+// dismantle
+// unlink nodes and entries of a value in batches, yielding between batches
+// large values in a keyspace are dismantled too
+func dismantle(val *DbObject) {
+	if compactEncoded(val) {
+		return
+	}
+	switch v := val.Val.(type) {
+	case *LinkedList:
+		dismantleList(v.data)
+	case *Set:
+		dismantleDict(v.dict, nil)
+		dismantleList(v.list)
+	case *Hash:
+		dismantleDict(v.data, nil)
+	case *Zset:
+		dismantleDict(v.dict, nil)
+		for removed := 1; v.skipList.RemoveFirst(); removed += 1 {
+			if removed%lazyfreeBatch == 0 {
+				runtime.Gosched()
+			}
+		}
+	case *Dict:
+		dismantleDict(v, func(key, val *DbObject) {
+			if FreeEffort(val) > LazyfreeThreshold {
+				dismantle(val)
+			}
+		})
+	}
+}
+
+func dismantleList(list *List) {
+	for removed := 1; !list.Empty(); removed += 1 {
+		list.RemoveFirst()
+		if removed%lazyfreeBatch == 0 {
+			runtime.Gosched()
+		}
+	}
+}
+
+func dismantleDict(dict *Dict, each func(key, val *DbObject)) {
+	var cursor int64 = 0
+	for cleared := false; !cleared; {
+		cursor, cleared = dict.ClearBuckets(cursor, lazyfreeBatch, each)
+		runtime.Gosched()
+	}
+}
+
+// PendingObjects
+// values waiting to be freed in background
+func (lazyfree *Lazyfree) PendingObjects() int64 {
+	if lazyfree == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&lazyfree.pending)
+}
+
+// FreedObjects
+// values freed in total
+func (lazyfree *Lazyfree) FreedObjects() int64 {
+	if lazyfree == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&lazyfree.freed)
+}
+
+// TakeFreedBytes
+// memory freed since the last call
+func (lazyfree *Lazyfree) TakeFreedBytes() int64 {
+	if lazyfree == nil {
+		return 0
+	}
+	return atomic.SwapInt64(&lazyfree.freedBytes, 0)
+}
@@ -2,13 +2,18 @@ package db
 
 import . "goRedis/data_structure"
 
+// LinkedList key
+// small lists are stored in a listpack, converted to a linked list when they grow
+
 type LinkedList struct {
 	data *List
+	// not nil if the list is listpack encoded
+	packed *Listpack
 }
 
 func NewLinkedList() *LinkedList {
 	return &LinkedList{
-		data: NewList(StrEqual),
+		packed: NewListpack(),
 	}
 }
 
@@ -16,22 +21,124 @@ func NewDefaultLinkedListValueObj() interface{} {
 	return NewLinkedList()
 }
 
+// convertIfNeeded
+// convert the listpack to a linked list if value can not be added to it
+func (list *LinkedList) convertIfNeeded(value *DbObject) {
+	if list.packed == nil {
+		return
+	}
+	if list.packed.Len() < ListMaxListpackEntries && len(value.StrVal()) <= ListMaxListpackValue {
+		return
+	}
+	list.data = NewList(StrEqual)
+	list.packed.ForEach(func(s string) bool {
+		list.data.AppendLast(NewStr(s))
+		return true
+	})
+	list.packed = nil
+}
+
 func (list *LinkedList) Lpush(value *DbObject) {
+	list.convertIfNeeded(value)
+	if list.packed != nil {
+		list.packed.Insert(list.packed.First(), value.StrVal())
+		return
+	}
 	list.data.AppendFirst(value)
 }
 
 func (list *LinkedList) Lpop() *DbObject {
+	if list.packed != nil {
+		p := list.packed.First()
+		if p < 0 {
+			return nil
+		}
+		value := NewStr(list.packed.Get(p))
+		list.packed.Delete(p)
+		return value
+	}
 	return list.data.RemoveFirst()
 }
 
 func (list *LinkedList) Rpush(value *DbObject) {
+	list.convertIfNeeded(value)
+	if list.packed != nil {
+		list.packed.Append(value.StrVal())
+		return
+	}
 	list.data.AppendLast(value)
 }
 
 func (list *LinkedList) Rpop() *DbObject {
+	if list.packed != nil {
+		p := list.packed.Last()
+		if p < 0 {
+			return nil
+		}
+		value := NewStr(list.packed.Get(p))
+		list.packed.Delete(p)
+		return value
+	}
 	return list.data.RemoveLast()
 }
 
 func (list *LinkedList) Len() int {
+	if list.packed != nil {
+		return list.packed.Len()
+	}
 	return list.data.Length()
 }
+
+func (list *LinkedList) Members() []*DbObject {
+	if list.packed != nil {
+		members := make([]*DbObject, 0, list.packed.Len())
+		list.packed.ForEach(func(s string) bool {
+			members = append(members, NewStr(s))
+			return true
+		})
+		return members
+	}
+	return list.data.Members()
+}
+
+// ForEach
+// traverse values from head to tail, stop if fn returns false
+func (list *LinkedList) ForEach(fn func(value *DbObject) bool) {
+	if list.packed != nil {
+		list.packed.ForEach(func(s string) bool {
+			return fn(NewStr(s))
+		})
+		return
+	}
+	list.data.ForEach(fn)
+}
+
+// MemoryUsage
+// size of the linked list (values are not included), or the listpack
+func (list *LinkedList) MemoryUsage() int64 {
+	if list.packed != nil {
+		return list.packed.MemoryUsage()
+	}
+	return list.data.MemoryUsage()
+}
+
+func (list *LinkedList) Encoding() string {
+	if list.packed != nil {
+		return EncodingListpack
+	}
+	return EncodingLinkedList
+}
+
+// Clone
+// a deep copy of the list with the same encoding
+func (list *LinkedList) Clone() *LinkedList {
+	if list.packed != nil {
+		return &LinkedList{packed: list.packed.Copy()}
+	}
+	clone := &LinkedList{data: NewList(StrEqual)}
+	list.data.ForEach(func(value *DbObject) bool {
+		clone.data.AppendLast(NewStr(value.StrVal()))
+		return true
+	})
+	return clone
+}
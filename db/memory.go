@@ -0,0 +1,125 @@
+package db
+
+import (
+	. "goRedis/data_structure"
+	"unsafe"
+)
+
+// memory usage estimation core lib
+// go runtime does not report memory usage per object, the size of a key is estimated from
+// the structures holding it, elements of aggregate values are sampled
+
+const (
+	// elements measured by default, the average is used for the others
+	DefaultSizeSamples int = 5
+)
+
+// EstimateSize
+// estimated memory usage (bytes) of a key, its value and the entry in database
+// samples elements of aggregate values are measured, all elements if samples <= 0
+func EstimateSize(key, val *DbObject, samples int) int64 {
+	return DictEntrySize + StrObjectSize(key) + ValueSize(val, samples)
+}
+
+// ValueSize
+// estimated memory usage (bytes) of a value, samples is the same as EstimateSize
+func ValueSize(val *DbObject, samples int) int64 {
+	if compactEncoded(val) {
+		// elements are stored in the intset or listpack
+		return ObjectSize + compactSize(val)
+	}
+	var size int64 = 0
+	switch val.Type {
+	case STR:
+		size += StrObjectSize(val)
+	case LINKDLIST:
+		list := val.Val.(*LinkedList)
+		size += ObjectSize + int64(unsafe.Sizeof(*list)) + list.MemoryUsage()
+		size += sampleSize(int64(list.Len()), samples, func(measure func(int64) bool) {
+			list.ForEach(func(value *DbObject) bool {
+				return measure(StrObjectSize(value))
+			})
+		})
+	case SET:
+		set := val.Val.(*Set)
+		size += ObjectSize + int64(unsafe.Sizeof(*set)) + set.MemoryUsage()
+		size += sampleSize(int64(set.Length()), samples, func(measure func(int64) bool) {
+			set.ForEach(func(member *DbObject) bool {
+				// member and the object wrapping its list node
+				return measure(StrObjectSize(member) + ObjectSize)
+			})
+		})
+	case HASH:
+		hash := val.Val.(*Hash)
+		size += ObjectSize + int64(unsafe.Sizeof(*hash)) + hash.MemoryUsage()
+		size += sampleSize(hash.Len(), samples, func(measure func(int64) bool) {
+			hash.ForEach(func(field, value *DbObject) bool {
+				return measure(StrObjectSize(field) + StrObjectSize(value))
+			})
+		})
+	case ZSET:
+		zset := val.Val.(*Zset)
+		size += ObjectSize + int64(unsafe.Sizeof(*zset)) + zset.MemoryUsage()
+		size += sampleSize(zset.Len(), samples, func(measure func(int64) bool) {
+			zset.ForEach(func(member, score *DbObject) bool {
+				return measure(StrObjectSize(member) + StrObjectSize(score))
+			})
+		})
+	case DICT:
+		// keys of a database
+		dict := val.Val.(*Dict)
+		size += ObjectSize + dict.MemoryUsage()
+		size += sampleSize(dict.Len(), samples, func(measure func(int64) bool) {
+			dict.Iterate(func(key, val *DbObject) bool {
+				return measure(StrObjectSize(key) + ValueSize(val, samples))
+			})
+		})
+	}
+	return size
+}
+
+func compactEncoded(val *DbObject) bool {
+	switch Encoding(val) {
+	case EncodingListpack, EncodingIntset:
+		return true
+	}
+	return false
+}
+
+// compactSize
+// size of a compact encoded aggregate value
+func compactSize(val *DbObject) int64 {
+	switch v := val.Val.(type) {
+	case *LinkedList:
+		return int64(unsafe.Sizeof(*v)) + v.MemoryUsage()
+	case *Set:
+		return int64(unsafe.Sizeof(*v)) + v.MemoryUsage()
+	case *Hash:
+		return int64(unsafe.Sizeof(*v)) + v.MemoryUsage()
+	case *Zset:
+		return int64(unsafe.Sizeof(*v)) + v.MemoryUsage()
+	}
+	return 0
+}
+
+// sampleSize
+// total size of n elements, estimated by the average size of samples elements
+// traverse calls measure with the size of every element until measure returns false
+func sampleSize(n int64, samples int, traverse func(measure func(int64) bool)) int64 {
+	var measured, total int64 = 0, 0
+	traverse(func(size int64) bool {
+		measured += 1
+		total += size
+		return samples <= 0 || measured < int64(samples)
+	})
+	if measured == 0 {
+		return 0
+	}
+	return total * n / measured
+}
+
+// Overhead
+// memory usage of the hash tables and entries of data dict and expire dict, keys and values are not included
+func (db *Database) Overhead() (int64, int64) {
+	return db.data.MemoryUsage(), db.expire.MemoryUsage()
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	. "goRedis/data_structure"
+)
+
+// scan core lib
+// keys and elements of aggregate values are scanned by cursor without blocking the event loop
+// compact encoded values (listpack, intset) are small, all elements are returned in one call
+
+const (
+	// elements returned in a call without COUNT
+	DefaultScanCount int = 10
+	// at most count * ScanMaxEmptyVisits buckets are visited in a call
+	ScanMaxEmptyVisits int = 10
+)
+
+// scanDict
+// scan dict from cursor until at least count entries are returned or all buckets are visited
+// return the next cursor, 0 if scanning is finished
+func scanDict(dict *Dict, cursor uint64, count int, fn func(key, val *DbObject)) uint64 {
+	returned := 0
+	maxVisits := count * ScanMaxEmptyVisits
+	for {
+		cursor = dict.Scan(cursor, func(key, val *DbObject) {
+			returned += 1
+			fn(key, val)
+		})
+		maxVisits -= 1
+		if cursor == 0 || returned >= count || maxVisits <= 0 {
+			return cursor
+		}
+	}
+}
@@ -7,16 +7,19 @@ import (
 
 // Set key
 // dict key type must be STR, value must be the Node ptr in the list
+// a set of integers only is stored in an intset, converted to dict and list when it grows
+// or a member is not an integer
 
 type Set struct {
 	dict *Dict
 	list *List
+	// not nil if the set is intset encoded
+	intset *Intset
 }
 
 func NewSet() *Set {
 	return &Set{
-		dict: NewDict(StrHash, StrEqual),
-		list: NewList(StrEqual),
+		intset: NewIntset(),
 	}
 }
 
@@ -25,6 +28,15 @@ func NewDefaultSetValueObj() interface{} {
 }
 
 func (set *Set) Add(key *DbObject) error {
+	if set.intset != nil {
+		if v, ok := canonicalInt(key.StrVal()); ok && (set.intset.Find(v) || set.intset.Len() < SetMaxIntsetEntries) {
+			if !set.intset.Add(v) {
+				return errors.New("key already exists")
+			}
+			return nil
+		}
+		set.convert()
+	}
 	ext, err := set.dict.Exist(key)
 	if !ext {
 		// add only if not exist
@@ -37,6 +49,12 @@ func (set *Set) Add(key *DbObject) error {
 }
 
 func (set *Set) Remove(key *DbObject) error {
+	if set.intset != nil {
+		if v, ok := canonicalInt(key.StrVal()); ok && set.intset.Remove(v) {
+			return nil
+		}
+		return ErrorKeyNotExist
+	}
 	ext, err := set.dict.Exist(key)
 	if ext {
 		return set.doRemove(key)
@@ -48,13 +66,91 @@ func (set *Set) Remove(key *DbObject) error {
 }
 
 func (set *Set) Members() []*DbObject {
+	if set.intset != nil {
+		members := make([]*DbObject, 0, set.intset.Len())
+		set.ForEach(func(member *DbObject) bool {
+			members = append(members, member)
+			return true
+		})
+		return members
+	}
 	return set.list.Members()
 }
 
 func (set *Set) Length() int {
+	if set.intset != nil {
+		return set.intset.Len()
+	}
 	return set.list.Length()
 }
 
+// Contains
+// judge whether member is in the set
+func (set *Set) Contains(member *DbObject) bool {
+	if set.intset != nil {
+		v, ok := canonicalInt(member.StrVal())
+		return ok && set.intset.Find(v)
+	}
+	ext, _ := set.dict.Exist(member)
+	return ext
+}
+
+// ForEach
+// traverse members in insertion order (ascending order if intset encoded), stop if fn returns false
+func (set *Set) ForEach(fn func(member *DbObject) bool) {
+	if set.intset != nil {
+		set.intset.ForEach(func(v int64) bool {
+			return fn(NewObjectByInt(v))
+		})
+		return
+	}
+	set.list.ForEach(fn)
+}
+
+// Scan
+// scan members from cursor, all of them are returned if the set is intset encoded
+func (set *Set) Scan(cursor uint64, count int, fn func(member *DbObject)) uint64 {
+	if set.intset != nil {
+		set.ForEach(func(member *DbObject) bool {
+			fn(member)
+			return true
+		})
+		return 0
+	}
+	return scanDict(set.dict, cursor, count, func(key, val *DbObject) {
+		fn(key)
+	})
+}
+
+// MemoryUsage
+// size of dict and list (members are not included), or the intset
+func (set *Set) MemoryUsage() int64 {
+	if set.intset != nil {
+		return set.intset.MemoryUsage()
+	}
+	return set.dict.MemoryUsage() + set.list.MemoryUsage()
+}
+
+func (set *Set) Encoding() string {
+	if set.intset != nil {
+		return EncodingIntset
+	}
+	return EncodingHashtable
+}
+
+// convert
+// convert the intset to dict and list
+func (set *Set) convert() {
+	intset := set.intset
+	set.intset = nil
+	set.dict = NewDict(StrHash, StrEqual)
+	set.list = NewList(StrEqual)
+	intset.ForEach(func(v int64) bool {
+		set.doAdd(NewObjectByInt(v))
+		return true
+	})
+}
+
 func (set *Set) Inter(other *Set) []*DbObject {
 	if set.Length() > other.Length() {
 		return doInter(other, set)
@@ -70,7 +166,7 @@ func (set *Set) Union(other *Set) []*DbObject {
 		result = append(result, m)
 	}
 	for _, m := range memB {
-		if ext, _ := set.dict.Exist(m); !ext {
+		if !set.Contains(m) {
 			result = append(result, m)
 		}
 	}
@@ -106,9 +202,26 @@ func doInter(a, b *Set) []*DbObject {
 	members := a.Members()
 	result := make([]*DbObject, 0)
 	for _, m := range members {
-		if ext, _ := b.dict.Exist(m); ext {
+		if b.Contains(m) {
 			result = append(result, m)
 		}
 	}
 	return result
 }
+
+// Clone
+// a deep copy of the set with the same encoding
+func (set *Set) Clone() *Set {
+	if set.intset != nil {
+		return &Set{intset: set.intset.Copy()}
+	}
+	clone := &Set{
+		dict: NewDict(StrHash, StrEqual),
+		list: NewList(StrEqual),
+	}
+	set.ForEach(func(member *DbObject) bool {
+		clone.doAdd(NewStr(member.StrVal()))
+		return true
+	})
+	return clone
+}
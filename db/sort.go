@@ -0,0 +1,198 @@
+package db
+
+import (
+	"errors"
+	. "goRedis/data_structure"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SORT core lib
+// elements of a list, set or zset are sorted by themselves or by weights stored in other keys,
+// a pattern is a key name with the first '*' replaced by the element, "key->field" refers to
+// a field of hash, "#" refers to the element itself
+
+var (
+	ErrorSortScore error = errors.New("One or more scores can't be converted into double")
+)
+
+type SortOptions struct {
+	// pattern of weight keys, "" to sort by elements, elements are not sorted if it has no '*'
+	By string
+	// patterns of values returned for every element, elements are returned if empty
+	Gets []string
+	// elements returned after sorting, Count < 0 means all elements after Offset
+	Offset int
+	Count  int
+	Desc   bool
+	// compare in lexicographic order instead of numeric order
+	Alpha bool
+}
+
+type sortElement struct {
+	value *DbObject
+	// weight of the element, nil if the weight key does not exist
+	weight *DbObject
+	score  float64
+}
+
+// Sort
+// sort elements of key, missing values of Gets are nil
+// return an empty result if key does not exist
+func (db *Database) Sort(key *DbObject, options *SortOptions) ([]*DbObject, error) {
+	values, err := db.sortValues(key)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]*sortElement, len(values))
+	for i, value := range values {
+		elements[i] = &sortElement{value: value, weight: value}
+	}
+	if options.By == "" || strings.Contains(options.By, "*") {
+		if err = db.sortElements(elements, options); err != nil {
+			return nil, err
+		}
+	}
+	elements = limitElements(elements, options.Offset, options.Count)
+	if len(options.Gets) == 0 {
+		result := make([]*DbObject, len(elements))
+		for i, element := range elements {
+			result[i] = element.value
+		}
+		return result, nil
+	}
+	result := make([]*DbObject, 0, len(elements)*len(options.Gets))
+	for _, element := range elements {
+		for _, pattern := range options.Gets {
+			result = append(result, db.lookupByPattern(pattern, element.value))
+		}
+	}
+	return result, nil
+}
+
+// sortValues
+// elements of a list (from head to tail), set or zset (in ascending order of score)
+func (db *Database) sortValues(key *DbObject) ([]*DbObject, error) {
+	val, err := db.GetKey(key)
+	if err != nil {
+		return []*DbObject{}, nil
+	}
+	switch val.Type {
+	case LINKDLIST:
+		return val.Val.(*LinkedList).Members(), nil
+	case SET:
+		return val.Val.(*Set).Members(), nil
+	case ZSET:
+		_, members := val.Val.(*Zset).Members()
+		return members, nil
+	}
+	return nil, errors.New("Illegal key type")
+}
+
+// sortElements
+// look up weights and sort, elements with the same score (or weight if ALPHA) are compared by themselves
+func (db *Database) sortElements(elements []*sortElement, options *SortOptions) error {
+	for _, element := range elements {
+		if options.By != "" {
+			element.weight = db.lookupByPattern(options.By, element.value)
+		}
+		if options.Alpha || element.weight == nil {
+			// a missing weight is 0
+			continue
+		}
+		score, err := strconv.ParseFloat(element.weight.StrVal(), 64)
+		if err != nil {
+			return ErrorSortScore
+		}
+		element.score = score
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		cmp := compareSortElements(elements[i], elements[j], options.Alpha)
+		if options.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return nil
+}
+
+func compareSortElements(a, b *sortElement, alpha bool) int {
+	if cmp := compareSortWeights(a, b, alpha); cmp != 0 {
+		return cmp
+	}
+	// the order of elements with the same score should not be undefined
+	return strings.Compare(a.value.StrVal(), b.value.StrVal())
+}
+
+// compareSortWeights
+// compare scores, or weights as strings if alpha
+func compareSortWeights(a, b *sortElement, alpha bool) int {
+	if !alpha {
+		if a.score < b.score {
+			return -1
+		} else if a.score > b.score {
+			return 1
+		}
+		return 0
+	}
+	// missing weights are the smallest
+	if a.weight == nil || b.weight == nil {
+		if a.weight != nil {
+			return 1
+		} else if b.weight != nil {
+			return -1
+		}
+		return 0
+	}
+	return strings.Compare(a.weight.StrVal(), b.weight.StrVal())
+}
+
+// limitElements
+// elements in [offset, offset+count), all elements after offset if count < 0
+func limitElements(elements []*sortElement, offset, count int) []*sortElement {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(elements) {
+		return elements[:0]
+	}
+	end := len(elements)
+	if count >= 0 && offset+count < end {
+		end = offset + count
+	}
+	return elements[offset:end]
+}
+
+// lookupByPattern
+// the value pattern refers to for element, nil if the key (or field) does not exist, the key
+// is not a string (or hash for "->field"), or pattern has no '*'
+func (db *Database) lookupByPattern(pattern string, element *DbObject) *DbObject {
+	if pattern == "#" {
+		return element
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		keyPattern, field = pattern[:star+1+arrow], pattern[star+1+arrow+2:]
+	}
+	key := NewStr(keyPattern[:star] + element.StrVal() + keyPattern[star+1:])
+	val, err := db.GetKey(key)
+	if err != nil {
+		return nil
+	}
+	if field == "" {
+		if val.Type != STR {
+			return nil
+		}
+		return val
+	}
+	if val.Type != HASH {
+		return nil
+	}
+	value, _ := val.Val.(*Hash).Get(NewStr(field))
+	return value
+}
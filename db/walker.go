@@ -0,0 +1,140 @@
+package db
+
+import (
+	. "goRedis/data_structure"
+)
+
+// element walker core lib
+// elements of a large value are encoded by background snapshots across event loop steps,
+// the walker keeps the position in the value between steps
+
+// ElementWalker
+// walk elements of a value incrementally, the value must not be modified until the walk is done
+// rehash of the dict of a hash value is paused during the walk
+type ElementWalker struct {
+	val      *DbObject
+	started  bool
+	done     bool
+	listNode *Node
+	zsetNode *SkipListNode
+	// bucket position of the dict of a hash value
+	position int64
+}
+
+func NewElementWalker(val *DbObject) *ElementWalker {
+	return &ElementWalker{val: val}
+}
+
+// Len
+// the number of elements of the value
+func (walker *ElementWalker) Len() int64 {
+	switch v := walker.val.Val.(type) {
+	case *LinkedList:
+		return int64(v.Len())
+	case *Set:
+		return int64(v.Length())
+	case *Hash:
+		return v.Len()
+	case *Zset:
+		return v.Len()
+	}
+	return 0
+}
+
+// Walk
+// call fn on about count elements, return whether all elements are walked
+// element is a list value, set member, hash field or zset member, extra is the value of
+// the field or the score of the member (nil for list and set)
+// compact encoded values are walked at once
+func (walker *ElementWalker) Walk(count int, fn func(element, extra *DbObject)) bool {
+	if walker.done {
+		return true
+	}
+	first := !walker.started
+	walker.started = true
+	if compactEncoded(walker.val) {
+		walker.forEach(fn)
+		walker.done = true
+		return true
+	}
+	switch v := walker.val.Val.(type) {
+	case *LinkedList:
+		walker.listNode = v.data.Walk(walker.listNode, count, func(val *DbObject) {
+			fn(val, nil)
+		})
+		walker.done = walker.listNode == nil
+	case *Set:
+		// members are kept in the list too
+		walker.listNode = v.list.Walk(walker.listNode, count, func(member *DbObject) {
+			fn(member, nil)
+		})
+		walker.done = walker.listNode == nil
+	case *Hash:
+		if first {
+			v.data.PauseRehash()
+		}
+		walker.position, walker.done = v.data.WalkBuckets(walker.position, count, fn)
+		if walker.done {
+			v.data.ResumeRehash()
+		}
+	case *Zset:
+		walker.zsetNode = v.skipList.Walk(walker.zsetNode, count, func(score, member *DbObject) {
+			fn(member, score)
+		})
+		walker.done = walker.zsetNode == nil
+	default:
+		walker.done = true
+	}
+	return walker.done
+}
+
+// Release
+// stop the walk before it is done
+func (walker *ElementWalker) Release() {
+	if hash, ok := walker.val.Val.(*Hash); ok && walker.started && !walker.done {
+		hash.data.ResumeRehash()
+	}
+	walker.done = true
+}
+
+func (walker *ElementWalker) forEach(fn func(element, extra *DbObject)) {
+	switch v := walker.val.Val.(type) {
+	case *LinkedList:
+		v.ForEach(func(value *DbObject) bool {
+			fn(value, nil)
+			return true
+		})
+	case *Set:
+		v.ForEach(func(member *DbObject) bool {
+			fn(member, nil)
+			return true
+		})
+	case *Hash:
+		v.ForEach(func(field, value *DbObject) bool {
+			fn(field, value)
+			return true
+		})
+	case *Zset:
+		v.ForEach(func(member, score *DbObject) bool {
+			fn(member, score)
+			return true
+		})
+	}
+}
+
+// CloneValue
+// a deep copy of a value in database, access info is not copied
+func CloneValue(val *DbObject) *DbObject {
+	switch val.Type {
+	case LINKDLIST:
+		return NewObject(LINKDLIST, val.Val.(*LinkedList).Clone())
+	case SET:
+		return NewObject(SET, val.Val.(*Set).Clone())
+	case HASH:
+		return NewObject(HASH, val.Val.(*Hash).Clone())
+	case ZSET:
+		return NewObject(ZSET, val.Val.(*Zset).Clone())
+	}
+	// strings are immutable
+	return NewObject(val.Type, val.Val)
+}
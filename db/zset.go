@@ -2,6 +2,7 @@ package db
 
 import (
 	"errors"
+	"fmt"
 	. "goRedis/data_structure"
 )
 
@@ -9,15 +10,20 @@ const (
 	MaxScore int64 = 1 << 60
 )
 
+// Zset key
+// small zsets are stored in a listpack as member, score pairs in ascending order of score,
+// converted to dict and skip list when they grow
+
 type Zset struct {
 	dict     *Dict
 	skipList *SkipList
+	// not nil if the zset is listpack encoded
+	packed *Listpack
 }
 
 func NewZset() *Zset {
 	return &Zset{
-		dict:     NewDict(StrHash, StrEqual),
-		skipList: NewSkipList(STR, StrEqual),
+		packed: NewListpack(),
 	}
 }
 
@@ -25,7 +31,41 @@ func NewDefaultZsetValueObj() interface{} {
 	return NewZset()
 }
 
+// findMember
+// offset of member in the listpack, -1 if not found
+func (zset *Zset) findMember(member *DbObject) int {
+	return zset.packed.Find(zset.packed.First(), member.StrVal(), 1)
+}
+
+// scoreAt
+// score of the member at p in the listpack
+func (zset *Zset) scoreAt(p int) *DbObject {
+	return NewStr(zset.packed.Get(zset.packed.Next(p))).TryIntEncoding()
+}
+
+// convert
+// convert the listpack to dict and skip list
+func (zset *Zset) convert() {
+	packed := zset.packed
+	zset.packed = nil
+	zset.dict = NewDict(StrHash, StrEqual)
+	zset.skipList = NewSkipList(STR, StrEqual)
+	// a member is inserted before members with the same score in skip list, so add members backwards
+	for p := packed.Last(); p >= 0; p = packed.Prev(packed.Prev(p)) {
+		member, score := NewStr(packed.Get(packed.Prev(p))), NewStr(packed.Get(p)).TryIntEncoding()
+		zset.dict.Set(member, score)
+		zset.skipList.Add(score, member)
+	}
+}
+
 func (zset *Zset) GetScore(member *DbObject) (int64, error) {
+	if zset.packed != nil {
+		p := zset.findMember(member)
+		if p < 0 {
+			return 0, ErrorKeyNotExist
+		}
+		return zset.scoreAt(p).IntVal()
+	}
 	obj, err := zset.dict.Get(member)
 	if err != nil {
 		return 0, err
@@ -35,12 +75,11 @@ func (zset *Zset) GetScore(member *DbObject) (int64, error) {
 }
 
 func (zset *Zset) UpdateScore(member *DbObject, score int64) error {
-	obj, err := zset.dict.Get(member)
-	if obj == nil || err != nil {
+	if _, err := zset.GetScore(member); err != nil {
 		return errors.New("No member to update")
 	}
 	// delete
-	if err = zset.Remove(member); err != nil {
+	if err := zset.Remove(member); err != nil {
 		return err
 	}
 	// set new member
@@ -54,6 +93,16 @@ func (zset *Zset) AddMember(member *DbObject, score int64) error {
 	if score > MaxScore {
 		return errors.New("Score value overflows")
 	}
+	if zset.packed != nil {
+		if zset.findMember(member) >= 0 {
+			return errors.New("Member already exists")
+		}
+		if int(zset.Len()) < ZsetMaxListpackEntries && len(member.StrVal()) <= ZsetMaxListpackValue {
+			zset.packedInsert(member, score)
+			return nil
+		}
+		zset.convert()
+	}
 	obj, err := zset.dict.Get(member)
 	if obj != nil {
 		return errors.New("Member already exists")
@@ -67,13 +116,123 @@ func (zset *Zset) AddMember(member *DbObject, score int64) error {
 	return nil
 }
 
+// packedInsert
+// insert member before the first member whose score >= score, the same order as skip list
+func (zset *Zset) packedInsert(member *DbObject, score int64) {
+	p := zset.packed.First()
+	for ; p >= 0; p = zset.packed.Next(zset.packed.Next(p)) {
+		if current, _ := zset.scoreAt(p).IntVal(); current >= score {
+			break
+		}
+	}
+	zset.packed.Insert(p, member.StrVal())
+	if p < 0 {
+		zset.packed.Append(NewObjectByInt(score).StrVal())
+	} else {
+		zset.packed.Insert(zset.packed.Next(p), NewObjectByInt(score).StrVal())
+	}
+}
+
 // ZRange
 // return scores and values
 func (zset *Zset) ZRange(left, right int64) ([]*DbObject, []*DbObject) {
+	if zset.packed != nil {
+		scores := make([]*DbObject, 0)
+		members := make([]*DbObject, 0)
+		zset.packedForEach(func(member, score *DbObject) bool {
+			value, _ := score.IntVal()
+			if value > right {
+				return false
+			}
+			if value >= left {
+				scores = append(scores, score)
+				members = append(members, member)
+			}
+			return true
+		})
+		return scores, members
+	}
 	return zset.skipList.Range(NewObjectByInt(left), NewObjectByInt(right))
 }
 
+// Members
+// return scores and members of the whole zset in ascending order of score
+func (zset *Zset) Members() ([]*DbObject, []*DbObject) {
+	if zset.packed != nil {
+		scores := make([]*DbObject, 0, zset.Len())
+		members := make([]*DbObject, 0, zset.Len())
+		zset.packedForEach(func(member, score *DbObject) bool {
+			scores = append(scores, score)
+			members = append(members, member)
+			return true
+		})
+		return scores, members
+	}
+	return zset.skipList.Members()
+}
+
+// ForEach
+// traverse members and scores in no particular order, stop if fn returns false
+func (zset *Zset) ForEach(fn func(member, score *DbObject) bool) {
+	if zset.packed != nil {
+		zset.packedForEach(fn)
+		return
+	}
+	zset.dict.Iterate(fn)
+}
+
+// Scan
+// scan members and scores from cursor, all of them are returned if the zset is listpack encoded
+func (zset *Zset) Scan(cursor uint64, count int, fn func(member, score *DbObject)) uint64 {
+	if zset.packed != nil {
+		zset.packedForEach(func(member, score *DbObject) bool {
+			fn(member, score)
+			return true
+		})
+		return 0
+	}
+	return scanDict(zset.dict, cursor, count, fn)
+}
+
+func (zset *Zset) packedForEach(fn func(member, score *DbObject) bool) {
+	for p := zset.packed.First(); p >= 0; p = zset.packed.Next(zset.packed.Next(p)) {
+		if !fn(NewStr(zset.packed.Get(p)), zset.scoreAt(p)) {
+			return
+		}
+	}
+}
+
+// MemoryUsage
+// size of dict and skip list (members and scores are not included), or the listpack
+func (zset *Zset) MemoryUsage() int64 {
+	if zset.packed != nil {
+		return zset.packed.MemoryUsage()
+	}
+	return zset.dict.MemoryUsage() + zset.skipList.MemoryUsage(zset.dict.Len())
+}
+
+func (zset *Zset) Len() int64 {
+	if zset.packed != nil {
+		return int64(zset.packed.Len() / 2)
+	}
+	return zset.dict.Len()
+}
+
+func (zset *Zset) Encoding() string {
+	if zset.packed != nil {
+		return EncodingListpack
+	}
+	return EncodingSkipList
+}
+
 func (zset *Zset) Remove(member *DbObject) error {
+	if zset.packed != nil {
+		if p := zset.findMember(member); p >= 0 {
+			// member and score
+			zset.packed.Delete(zset.packed.Delete(p))
+		}
+		return nil
+	}
 	score, err := zset.dict.Get(member)
 	if err != nil {
 		return nil
@@ -84,11 +243,10 @@ func (zset *Zset) Remove(member *DbObject) error {
 }
 
 func (zset *Zset) Incr(member *DbObject, incr int64) error {
-	obj, err := zset.dict.Get(member)
+	score, err := zset.GetScore(member)
 	if err != nil {
 		return err
 	}
-	score, _ := obj.IntVal()
 	score += incr
 	if score > MaxScore {
 		return errors.New("Score value overflows")
@@ -101,5 +259,33 @@ func (zset *Zset) Incr(member *DbObject, incr int64) error {
 
 /* TEST CODE */
 func (zset *Zset) Print() {
+	if zset.packed != nil {
+		zset.packedForEach(func(member, score *DbObject) bool {
+			fmt.Printf("score : %s ,   value : %s\n", score.StrVal(), member.StrVal())
+			return true
+		})
+		return
+	}
 	zset.skipList.Print()
 }
+
+// Clone
+// a deep copy of the zset with the same encoding
+func (zset *Zset) Clone() *Zset {
+	if zset.packed != nil {
+		return &Zset{packed: zset.packed.Copy()}
+	}
+	clone := &Zset{
+		dict:     NewDict(StrHash, StrEqual),
+		skipList: NewSkipList(STR, StrEqual),
+	}
+	scores, members := zset.Members()
+	// add members backwards to keep the order of members with the same score, see convert
+	for i := len(members) - 1; i >= 0; i -= 1 {
+		score, _ := scores[i].IntVal()
+		member, query := NewStr(members[i].StrVal()), NewObjectByInt(score)
+		clone.dict.Set(member, query)
+		clone.skipList.Add(query, member)
+	}
+	return clone
+}
@@ -21,7 +21,8 @@ func main() {
 	}
 	server.Loop.AddFileEvent(server.Fd, core.READABLE, core.AcceptHandler, nil)
 	log.Printf("[MAIN INIT TCP SERVER] Init tcp server success\n")
-	// TODO TimeEvents
+	// serverCron 周期任务
+	server.Loop.AddTimeEvent(core.NORMAL, core.CronInterval, core.ServerCron, nil)
 	// AEMAIN EPOLL主循环
 	server.Loop.AeMain()
 }
@@ -0,0 +1,346 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/util"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// database append only file (AOF) lib
+// every write command is appended to the file in RESP bulk format
+// *<argc>\r\n$<len>\r\n<arg>\r\n ...
+
+// fsync policy
+const (
+	FsyncAlways   string = "always"
+	FsyncEverySec string = "everysec"
+	FsyncNo       string = "no"
+)
+
+const (
+	// max length of a bulk string in AOF
+	MaxBulkLength int = 512 << 20
+	// max number of args of a command in AOF
+	MaxArgc int = 1 << 20
+	// args preallocated for a command, more args are appended as they are read
+	preallocArgc int = 1024
+)
+
+var (
+	ErrorAofCorrupted error = errors.New("Append only file is corrupted")
+)
+
+// AofTruncatedError
+// the last command of the AOF of Path is incomplete, usually written when the server crashed,
+// commands before Offset are complete
+type AofTruncatedError struct {
+	Path   string
+	Offset int64
+}
+
+func (err *AofTruncatedError) Error() string {
+	return fmt.Sprintf("Append only file %s ends with an incomplete command at offset %d", err.Path, err.Offset)
+}
+
+func (err *AofTruncatedError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+type AppendOnlyFile struct {
+	file  *os.File
+	path  string
+	fsync string
+	// size of the file
+	size int64
+	// whether there are bytes written but not fsynced
+	dirty bool
+}
+
+// ValidFsyncPolicy
+// judge whether policy is a valid fsync policy
+func ValidFsyncPolicy(policy string) bool {
+	return policy == FsyncAlways || policy == FsyncEverySec || policy == FsyncNo
+}
+
+// OpenAppendOnlyFile
+// open (create if not exist) an AOF for appending
+func OpenAppendOnlyFile(path string, fsync string) (*AppendOnlyFile, error) {
+	if !ValidFsyncPolicy(fsync) {
+		return nil, fmt.Errorf("Illegal fsync policy %s", fsync)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &AppendOnlyFile{
+		file:  file,
+		path:  path,
+		fsync: fsync,
+		size:  info.Size(),
+		dirty: false,
+	}, nil
+}
+
+// Write
+// append raw bytes (complete commands) to AOF
+func (aof *AppendOnlyFile) Write(buf []byte) error {
+	n, err := aof.file.Write(buf)
+	aof.size += int64(n)
+	if err != nil {
+		return err
+	}
+	aof.dirty = true
+	if aof.fsync == FsyncAlways {
+		return aof.Fsync()
+	}
+	return nil
+}
+
+// Fsync
+// fsync the file only if there are bytes not fsynced
+func (aof *AppendOnlyFile) Fsync() error {
+	if !aof.dirty {
+		return nil
+	}
+	if err := aof.file.Sync(); err != nil {
+		return err
+	}
+	aof.dirty = false
+	return nil
+}
+
+func (aof *AppendOnlyFile) Size() int64 {
+	return aof.size
+}
+
+func (aof *AppendOnlyFile) Path() string {
+	return aof.path
+}
+
+// Close
+// fsync and close the file
+func (aof *AppendOnlyFile) Close() error {
+	if err := aof.Fsync(); err != nil {
+		aof.file.Close()
+		return err
+	}
+	return aof.file.Close()
+}
+
+// EncodeCommand
+// encode a command to RESP bulk format
+func EncodeCommand(args []*DbObject) []byte {
+	var builder strings.Builder
+	builder.WriteString("*")
+	builder.WriteString(strconv.Itoa(len(args)))
+	builder.WriteString("\r\n")
+	for _, arg := range args {
+		s := arg.StrVal()
+		builder.WriteString("$")
+		builder.WriteString(strconv.Itoa(len(s)))
+		builder.WriteString("\r\n")
+		builder.WriteString(s)
+		builder.WriteString("\r\n")
+	}
+	return []byte(builder.String())
+}
+
+// AofRewriteEncoder
+// encode keys to the minimal command stream which rebuilds them
+type AofRewriteEncoder struct {
+	writer *bufio.Writer
+}
+
+func NewAofRewriteEncoder(w io.Writer) *AofRewriteEncoder {
+	return &AofRewriteEncoder{
+		writer: bufio.NewWriter(w),
+	}
+}
+
+// WriteEntry
+// expireTime (unix nano) >= 0 is written as PEXPIREAT
+func (enc *AofRewriteEncoder) WriteEntry(key, val *DbObject, expireTime int64) error {
+	switch val.Type {
+	case STR:
+		if err := enc.write(NewStr("SET"), key, val); err != nil {
+			return err
+		}
+	case LINKDLIST, SET, HASH, ZSET:
+		var err error
+		NewElementWalker(val).Walk(math.MaxInt, func(element, extra *DbObject) {
+			if err == nil {
+				err = enc.WriteElement(key, val, element, extra)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrorUnsupportedDataType
+	}
+	return enc.EndEntry(key, expireTime)
+}
+
+// BeginEntry
+// an aggregate value written in parts is rebuilt by the commands of its elements
+func (enc *AofRewriteEncoder) BeginEntry(key, val *DbObject, expireTime int64) error {
+	return nil
+}
+
+// WriteElement
+// write the command which adds an element returned by ElementWalker of val
+func (enc *AofRewriteEncoder) WriteElement(key, val, element, extra *DbObject) error {
+	switch val.Type {
+	case LINKDLIST:
+		return enc.write(NewStr("RPUSH"), key, element)
+	case SET:
+		return enc.write(NewStr("SADD"), key, element)
+	case HASH:
+		return enc.write(NewStr("HSET"), key, element, extra)
+	case ZSET:
+		return enc.write(NewStr("ZADD"), key, extra, element)
+	}
+	return ErrorUnsupportedDataType
+}
+
+// EndEntry
+// expire time is set after the key is rebuilt
+func (enc *AofRewriteEncoder) EndEntry(key *DbObject, expireTime int64) error {
+	if expireTime < 0 {
+		return nil
+	}
+	return enc.write(NewStr("PEXPIREAT"), key, NewObjectByInt(expireTime/int64(time.Millisecond)))
+}
+
+// WriteSelectDb
+// keys written after it belong to db index
+func (enc *AofRewriteEncoder) WriteSelectDb(index int) error {
+	return enc.write(NewStr("SELECT"), NewObjectByInt(int64(index)))
+}
+
+func (enc *AofRewriteEncoder) Flush() error {
+	return enc.writer.Flush()
+}
+
+func (enc *AofRewriteEncoder) WriteEnd() error {
+	return enc.writer.Flush()
+}
+
+func (enc *AofRewriteEncoder) write(args ...*DbObject) error {
+	_, err := enc.writer.Write(EncodeCommand(args))
+	return err
+}
+
+// ReadAppendOnlyFile
+// decode commands from AOF stream and call handler for every command
+// return the offset of the end of the last complete command and
+// io.ErrUnexpectedEOF if the stream ends with an incomplete command
+func ReadAppendOnlyFile(r io.Reader, handler func(args []*DbObject) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset int64 = 0
+	for {
+		args, n, err := readCommand(reader)
+		if err == io.EOF && n == 0 {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, unexpectedEOF(err)
+		}
+		if err = handler(args); err != nil {
+			return offset, err
+		}
+		offset += n
+	}
+}
+
+// LoadAppendOnlyFile
+// replay all commands in AOF of path
+// return an error satisfying os.IsNotExist if the file does not exist,
+// *AofTruncatedError if the file ends with an incomplete command
+func LoadAppendOnlyFile(path string, handler func(args []*DbObject) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := ReadAppendOnlyFile(file, handler)
+	if err == io.ErrUnexpectedEOF {
+		return &AofTruncatedError{Path: path, Offset: offset}
+	}
+	return err
+}
+
+// readCommand
+// read a RESP bulk command, return args and bytes read
+func readCommand(reader *bufio.Reader) ([]*DbObject, int64, error) {
+	var n int64 = 0
+	line, err := reader.ReadString('\n')
+	n += int64(len(line))
+	if err != nil {
+		return nil, n, err
+	}
+	argc, err := parseLength(line, '*')
+	if err != nil {
+		return nil, n, err
+	}
+	if argc > MaxArgc {
+		return nil, n, ErrorAofCorrupted
+	}
+	// argc is not trusted until the args are read
+	args := make([]*DbObject, 0, util.MinInt(argc, preallocArgc))
+	for i := 0; i < argc; i += 1 {
+		line, err = reader.ReadString('\n')
+		n += int64(len(line))
+		if err != nil {
+			return nil, n, err
+		}
+		length, err := parseLength(line, '$')
+		if err != nil {
+			return nil, n, err
+		}
+		if length > MaxBulkLength {
+			return nil, n, ErrorAofCorrupted
+		}
+		// the buffer grows with the bytes actually read, a truncated file never allocates length
+		buf, err := io.ReadAll(io.LimitReader(reader, int64(length+2)))
+		n += int64(len(buf))
+		if err != nil {
+			return nil, n, err
+		}
+		if len(buf) < length+2 {
+			return nil, n, io.ErrUnexpectedEOF
+		}
+		if buf[length] != '\r' || buf[length+1] != '\n' {
+			return nil, n, ErrorAofCorrupted
+		}
+		args = append(args, NewStr(string(buf[:length])))
+	}
+	return args, n, nil
+}
+
+// parseLength
+// parse line like "*3\r\n" or "$5\r\n"
+func parseLength(line string, head byte) (int, error) {
+	if len(line) < 4 || line[0] != head || !strings.HasSuffix(line, "\r\n") {
+		return 0, ErrorAofCorrupted
+	}
+	length, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || length < 0 {
+		return 0, ErrorAofCorrupted
+	}
+	return length, nil
+}
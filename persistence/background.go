@@ -0,0 +1,408 @@
+package persistence
+
+import (
+	"bytes"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// fork-less background snapshot lib
+// golang can not fork safely, so a snapshot is taken incrementally in the event loop:
+// 1. rehash of data dicts is paused, keys stay in their buckets during the snapshot
+// 2. every Step walks some buckets within a time budget and encodes the keys, databases are
+//    walked one by one in order of index
+// 3. before a write command modifies a key that has not been walked, BeforeWrite encodes
+//    its old value first (copy before write), so the result is the view at the start time
+// 4. encoded chunks are written to file by a writer goroutine, event loop never waits for disk
+// the dicts of databases at the start time are walked, a flushed or swapped database keeps its
+// old dicts walked, values with many elements are encoded in parts across steps
+
+const (
+	// buckets walked between two budget checks
+	walkBucketsPerCheck int = 64
+	// elements encoded between two budget checks, values with more elements are encoded in parts
+	walkElementsPerCheck int = 1024
+	// max chunks waiting for the writer goroutine, Step does nothing when full
+	maxPendingChunks int = 1024
+)
+
+// EntryEncoder
+// encoder used by SnapshotSession, implemented by SnapshotEncoder and AofRewriteEncoder
+type EntryEncoder interface {
+	// keys written after it belong to db index
+	WriteSelectDb(index int) error
+	WriteEntry(key, val *DbObject, expireTime int64) error
+	// an aggregate value written in parts: BeginEntry, WriteElement of every element returned
+	// by ElementWalker, then EndEntry, no other entry is written in between
+	BeginEntry(key, val *DbObject, expireTime int64) error
+	WriteElement(key, val, element, extra *DbObject) error
+	EndEntry(key *DbObject, expireTime int64) error
+	Flush() error
+	WriteEnd() error
+}
+
+// partialEntry
+// a large value encoded in parts
+type partialEntry struct {
+	dbIndex    int
+	key        *DbObject
+	val        *DbObject
+	expireTime int64
+	walker     *ElementWalker
+	// BeginEntry is written
+	begun bool
+}
+
+type chunk struct {
+	data []byte
+	err  error
+}
+
+type SnapshotSession struct {
+	dbs []*Database
+	// databases sharing the dicts of dbs at the start time, walked instead of dbs
+	views   []*Database
+	encoder EntryEncoder
+	// output of encoder, sent to the writer goroutine after every step
+	pending *bytes.Buffer
+	// index of the database being walked and the next bucket position to walk in it
+	dbIndex int
+	cursor  int64
+	// db index of the last entry encoded, -1 if no entry is encoded
+	selected int
+	// keys encoded (or created) before the walk reaches them, of every database
+	visited []map[string]struct{}
+	// large values waiting to be encoded in parts, the first one is being encoded
+	entries []*partialEntry
+	// point in time of the snapshot (unix nano), keys expired before it are skipped
+	startTime int64
+	// all keys are encoded and chunks is closed
+	finished bool
+	chunks   chan chunk
+	done     chan error
+	// encode error occurred in BeforeWrite
+	err error
+	// number of keys encoded
+	keys int64
+}
+
+// NewSnapshotSession
+// start a background snapshot of dbs, the result is written to file (closed when finished)
+// newEncoder is called with the session buffer, it may write the file header
+func NewSnapshotSession(dbs []*Database, file *os.File, newEncoder func(w io.Writer) (EntryEncoder, error)) (*SnapshotSession, error) {
+	session := &SnapshotSession{
+		dbs:       dbs,
+		views:     make([]*Database, len(dbs)),
+		pending:   &bytes.Buffer{},
+		dbIndex:   0,
+		cursor:    0,
+		selected:  -1,
+		visited:   make([]map[string]struct{}, len(dbs)),
+		startTime: time.Now().UnixNano(),
+		finished:  false,
+		chunks:    make(chan chunk, maxPendingChunks),
+		done:      make(chan error, 1),
+	}
+	encoder, err := newEncoder(session.pending)
+	if err != nil {
+		return nil, err
+	}
+	session.encoder = encoder
+	for index, db := range dbs {
+		session.visited[index] = make(map[string]struct{})
+		session.views[index] = db.View()
+		session.views[index].PauseRehash()
+	}
+	go session.writeLoop(file)
+	return session, nil
+}
+
+// Step
+// walk buckets within budget, finish the session if all buckets are walked
+// at most one chunk is sent to the writer goroutine in a step
+func (session *SnapshotSession) Step(budget time.Duration) {
+	if session.finished || len(session.chunks) >= cap(session.chunks) {
+		return
+	}
+	if session.err != nil {
+		session.finish(session.err)
+		return
+	}
+	start := time.Now()
+	walked := session.walked()
+	var err error
+	for !walked && err == nil && time.Since(start) < budget {
+		if len(session.entries) > 0 {
+			err = session.writePartial(walkElementsPerCheck)
+		} else {
+			index := session.dbIndex
+			var dbWalked bool
+			session.cursor, dbWalked = session.views[index].WalkKeys(session.cursor, walkBucketsPerCheck, func(key, val *DbObject, expireTime int64) {
+				if _, ext := session.visited[index][key.StrVal()]; ext || err != nil {
+					return
+				}
+				if NewElementWalker(val).Len() > int64(walkElementsPerCheck) {
+					session.addPartial(index, key, val, expireTime)
+				} else {
+					err = session.writeEntry(index, key, val, expireTime)
+				}
+			})
+			if dbWalked {
+				session.dbIndex, session.cursor = index+1, 0
+			}
+		}
+		walked = session.walked()
+	}
+	if err == nil && walked {
+		err = session.encoder.WriteEnd()
+	}
+	if err == nil {
+		err = session.flush()
+	}
+	if err != nil || walked {
+		session.finish(err)
+	}
+}
+
+// Finish
+// walk all remaining buckets at once
+func (session *SnapshotSession) Finish() {
+	for !session.finished {
+		session.Step(time.Hour)
+	}
+}
+
+// BeforeWrite
+// must be called before a key of database dbIndex is modified (or created / deleted)
+func (session *SnapshotSession) BeforeWrite(dbIndex int, key *DbObject) {
+	if session.finished {
+		return
+	}
+	index := session.viewIndex(dbIndex)
+	if index == -1 {
+		// the database is flushed after the start time
+		return
+	}
+	for i, entry := range session.entries {
+		if entry.dbIndex != index || entry.key.StrVal() != key.StrVal() {
+			continue
+		}
+		if i == 0 && entry.begun {
+			// the value being encoded is finished before it is modified
+			if session.err == nil {
+				session.err = session.writePartial(math.MaxInt)
+			}
+		} else {
+			entry.val = CloneValue(entry.val)
+			entry.walker = NewElementWalker(entry.val)
+		}
+		return
+	}
+	if index < session.dbIndex {
+		// the database is already walked
+		return
+	}
+	if _, ext := session.visited[index][key.StrVal()]; ext {
+		return
+	}
+	view := session.views[index]
+	position := view.KeyPosition(key)
+	if index == session.dbIndex && position != -1 && position < session.cursor {
+		// already walked
+		return
+	}
+	session.visited[index][key.StrVal()] = struct{}{}
+	if position != -1 && session.err == nil {
+		val, expireTime := view.Peek(key)
+		if len(session.entries) > 0 {
+			// entries can not be written in the middle of a value being encoded
+			session.addPartial(index, key, CloneValue(val), expireTime)
+		} else {
+			// reported by the next Step
+			session.err = session.writeEntry(index, key, val, expireTime)
+		}
+	}
+}
+
+// Abort
+// stop an unfinished session, Done receives ErrorSnapshotAborted after the file is closed
+func (session *SnapshotSession) Abort() {
+	if !session.finished {
+		session.finish(ErrorSnapshotAborted)
+	}
+}
+
+// Done
+// receive the result after the writer goroutine exits
+func (session *SnapshotSession) Done() <-chan error {
+	return session.done
+}
+
+func (session *SnapshotSession) Keys() int64 {
+	return session.keys
+}
+
+// writeEntry
+// encode a key of database dbIndex at once
+func (session *SnapshotSession) writeEntry(dbIndex int, key, val *DbObject, expireTime int64) error {
+	if expireTime >= 0 && expireTime <= session.startTime {
+		return nil
+	}
+	if err := session.selectDb(dbIndex); err != nil {
+		return err
+	}
+	session.keys += 1
+	return session.encoder.WriteEntry(key, val, expireTime)
+}
+
+// selectDb
+// select database dbIndex if the last key is of another one
+func (session *SnapshotSession) selectDb(dbIndex int) error {
+	if dbIndex == session.selected {
+		return nil
+	}
+	if err := session.encoder.WriteSelectDb(dbIndex); err != nil {
+		return err
+	}
+	session.selected = dbIndex
+	return nil
+}
+
+// addPartial
+// add a value encoded in parts later, val must not be modified until it is encoded
+func (session *SnapshotSession) addPartial(dbIndex int, key, val *DbObject, expireTime int64) {
+	if expireTime >= 0 && expireTime <= session.startTime {
+		return
+	}
+	session.entries = append(session.entries, &partialEntry{
+		dbIndex:    dbIndex,
+		key:        key,
+		val:        val,
+		expireTime: expireTime,
+		walker:     NewElementWalker(val),
+	})
+}
+
+// writePartial
+// encode about count elements of the first partial entry, small values are encoded at once
+func (session *SnapshotSession) writePartial(count int) error {
+	entry := session.entries[0]
+	if !entry.begun && entry.walker.Len() <= int64(walkElementsPerCheck) {
+		session.entries = session.entries[1:]
+		return session.writeEntry(entry.dbIndex, entry.key, entry.val, entry.expireTime)
+	}
+	if !entry.begun {
+		if err := session.selectDb(entry.dbIndex); err != nil {
+			return err
+		}
+		session.keys += 1
+		if err := session.encoder.BeginEntry(entry.key, entry.val, entry.expireTime); err != nil {
+			return err
+		}
+		entry.begun = true
+	}
+	var err error
+	done := entry.walker.Walk(count, func(element, extra *DbObject) {
+		if err == nil {
+			err = session.encoder.WriteElement(entry.key, entry.val, element, extra)
+		}
+	})
+	if err != nil || !done {
+		return err
+	}
+	session.entries = session.entries[1:]
+	return session.encoder.EndEntry(entry.key, entry.expireTime)
+}
+
+// walked
+// all databases are walked and all values are encoded
+func (session *SnapshotSession) walked() bool {
+	return session.dbIndex >= len(session.views) && len(session.entries) == 0
+}
+
+// viewIndex
+// index of the view walked for database dbIndex (it differs after SWAPDB),
+// -1 if the database is flushed after the start time
+func (session *SnapshotSession) viewIndex(dbIndex int) int {
+	for index, view := range session.views {
+		if view.Shares(session.dbs[dbIndex]) {
+			return index
+		}
+	}
+	return -1
+}
+
+// flush
+// send pending bytes to the writer goroutine, return nil if nothing to send
+func (session *SnapshotSession) flush() error {
+	if err := session.encoder.Flush(); err != nil {
+		return err
+	}
+	if session.pending.Len() > 0 {
+		data := make([]byte, session.pending.Len())
+		copy(data, session.pending.Bytes())
+		session.pending.Reset()
+		session.chunks <- chunk{data: data}
+	}
+	return nil
+}
+
+// finish
+// close chunks (send err first if not nil), then the writer goroutine will exit
+func (session *SnapshotSession) finish(err error) {
+	if err != nil {
+		session.chunks <- chunk{err: err}
+	}
+	close(session.chunks)
+	session.finished = true
+	session.visited = nil
+	for _, entry := range session.entries {
+		entry.walker.Release()
+	}
+	session.entries = nil
+	for _, view := range session.views {
+		view.ResumeRehash()
+		if !session.shared(view) {
+			// the dicts of a flushed database are freed after the walk
+			view.Flush(true)
+		}
+	}
+}
+
+// shared
+// whether the dicts of view are still used by a database
+func (session *SnapshotSession) shared(view *Database) bool {
+	for _, db := range session.dbs {
+		if db.Shares(view) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLoop
+// writer goroutine, write chunks to file, then fsync and close it
+func (session *SnapshotSession) writeLoop(file *os.File) {
+	var err error
+	for c := range session.chunks {
+		if err != nil {
+			continue
+		}
+		if c.err != nil {
+			err = c.err
+			continue
+		}
+		_, err = file.Write(c.data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	session.done <- err
+}
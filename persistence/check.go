@@ -0,0 +1,129 @@
+package persistence
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"io"
+	"os"
+	"sort"
+)
+
+// persistence file check lib, used by goredis-check-snapshot and goredis-check-aof
+
+// TypeStats
+// statistics of keys of a data type
+type TypeStats struct {
+	Keys     int64
+	Elements int64
+	Expires  int64
+}
+
+// KeyStats
+// per-type and per-db key statistics
+type KeyStats struct {
+	Types map[DbObjectType]*TypeStats
+	Dbs   map[int]int64
+}
+
+func NewKeyStats() *KeyStats {
+	return &KeyStats{
+		Types: make(map[DbObjectType]*TypeStats),
+		Dbs:   make(map[int]int64),
+	}
+}
+
+// Add
+// count a key, expireTime < 0 means no expire time
+func (stats *KeyStats) Add(dbIndex int, key, val *DbObject, expireTime int64) {
+	typeStats := stats.Types[val.Type]
+	if typeStats == nil {
+		typeStats = &TypeStats{}
+		stats.Types[val.Type] = typeStats
+	}
+	typeStats.Keys += 1
+	typeStats.Elements += elementsOf(val)
+	if expireTime >= 0 {
+		typeStats.Expires += 1
+	}
+	stats.Dbs[dbIndex] += 1
+}
+
+// Print
+// print statistics in human readable format
+func (stats *KeyStats) Print(w io.Writer) {
+	var total int64 = 0
+	dbIndexes := make([]int, 0, len(stats.Dbs))
+	for dbIndex, keys := range stats.Dbs {
+		dbIndexes = append(dbIndexes, dbIndex)
+		total += keys
+	}
+	sort.Ints(dbIndexes)
+	fmt.Fprintf(w, "keys: %d\n", total)
+	for _, dbIndex := range dbIndexes {
+		fmt.Fprintf(w, "db%d: keys=%d\n", dbIndex, stats.Dbs[dbIndex])
+	}
+	for _, t := range []DbObjectType{STR, LINKDLIST, SET, HASH, ZSET} {
+		if typeStats := stats.Types[t]; typeStats != nil {
+			fmt.Fprintf(w, "%s: keys=%d elements=%d expires=%d\n", TypeName(t), typeStats.Keys, typeStats.Elements, typeStats.Expires)
+		}
+	}
+}
+
+// TypeName
+// name of data type as reported to users
+func TypeName(t DbObjectType) string {
+	switch t {
+	case STR:
+		return "string"
+	case LINKDLIST, LIST:
+		return "list"
+	case SET:
+		return "set"
+	case HASH:
+		return "hash"
+	case ZSET:
+		return "zset"
+	default:
+		return "unknown"
+	}
+}
+
+func elementsOf(val *DbObject) int64 {
+	switch val.Type {
+	case LINKDLIST:
+		return int64(val.Val.(*LinkedList).Len())
+	case SET:
+		return int64(val.Val.(*Set).Length())
+	case HASH:
+		return val.Val.(*Hash).Len()
+	case ZSET:
+		return val.Val.(*Zset).Len()
+	default:
+		return 1
+	}
+}
+
+// CheckSnapshot
+// decode a snapshot stream like ReadSnapshot, return the offset where decoding stopped
+func CheckSnapshot(r io.Reader, handler SnapshotHandler) (int64, error) {
+	return readSnapshot(newSnapshotReader(r), handler)
+}
+
+// TruncateAppendOnlyFile
+// truncate AOF of path to size and fsync it
+func TruncateAppendOnlyFile(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,63 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	"hash/crc64"
+)
+
+// DUMP / RESTORE payload lib
+// | value type(1) | value (snapshot encoding) | version(2, little endian) | crc64 checksum of all bytes before(8, little endian) |
+
+var (
+	ErrorDumpPayloadCorrupted error = errors.New("DUMP payload version or checksum are wrong")
+)
+
+// DumpValue
+// serialize a value to an opaque payload
+func DumpValue(val *DbObject) ([]byte, error) {
+	valueType, err := snapshotType(val)
+	if err != nil {
+		return nil, err
+	}
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte(valueType)
+	enc := NewSnapshotEncoder(buffer)
+	if err = enc.WriteValue(val); err != nil {
+		return nil, err
+	}
+	if err = enc.Flush(); err != nil {
+		return nil, err
+	}
+	var footer [10]byte
+	binary.LittleEndian.PutUint16(footer[:2], SnapshotVersion)
+	buffer.Write(footer[:2])
+	binary.LittleEndian.PutUint64(footer[2:], crc64.Checksum(buffer.Bytes(), crcTable))
+	buffer.Write(footer[2:])
+	return buffer.Bytes(), nil
+}
+
+// RestoreValue
+// deserialize a payload created by DumpValue
+// checksum is verified before the version, a payload of newer version is rejected
+func RestoreValue(payload []byte) (*DbObject, error) {
+	if len(payload) < 11 {
+		return nil, ErrorDumpPayloadCorrupted
+	}
+	body := payload[:len(payload)-8]
+	if binary.LittleEndian.Uint64(payload[len(body):]) != crc64.Checksum(body, crcTable) {
+		return nil, ErrorDumpPayloadCorrupted
+	}
+	if version := binary.LittleEndian.Uint16(body[len(body)-2:]); version > SnapshotVersion {
+		return nil, fmt.Errorf("%w: DUMP payload version %d", ErrorUnsupportedVersion, version)
+	}
+	reader := bytes.NewReader(body[1 : len(body)-2])
+	val, err := ReadValue(reader, body[0])
+	if err != nil || reader.Len() != 0 {
+		return nil, ErrorDumpPayloadCorrupted
+	}
+	return val, nil
+}
@@ -0,0 +1,236 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// multi-part append only file manifest lib
+// an append only directory contains a base file (snapshot format), incremental AOF segments
+// and a manifest listing them in loading order, one file per line:
+// file appendonly.aof.1.base.gdb seq 1 type b
+// file appendonly.aof.1.incr.aof seq 1 type i
+// file appendonly.aof.2.incr.aof seq 2 type i
+// the manifest is always replaced atomically, files not listed in it are garbage
+
+// type of file in manifest
+const (
+	AofBaseType byte = 'b'
+	AofIncrType byte = 'i'
+)
+
+const (
+	AofBaseSuffix     string = ".base.gdb"
+	AofIncrSuffix     string = ".incr.aof"
+	AofManifestSuffix string = ".manifest"
+)
+
+var (
+	ErrorManifestCorrupted error = errors.New("Append only manifest is corrupted")
+)
+
+type AofFileInfo struct {
+	Name string
+	Seq  int64
+	Type byte
+}
+
+type AofManifest struct {
+	// prefix of file names (appendFileName)
+	name string
+	// nil before the first rewrite
+	Base *AofFileInfo
+	// in loading order, the last one is opened for appending
+	Incrs []*AofFileInfo
+}
+
+func NewAofManifest(name string) *AofManifest {
+	return &AofManifest{
+		name:  name,
+		Base:  nil,
+		Incrs: make([]*AofFileInfo, 0),
+	}
+}
+
+// ManifestPath
+// path of the manifest of name in dir
+func ManifestPath(dir, name string) string {
+	return filepath.Join(dir, name+AofManifestSuffix)
+}
+
+// LoadAofManifest
+// read the manifest of name in dir
+// return an error satisfying os.IsNotExist if the manifest does not exist
+func LoadAofManifest(dir, name string) (*AofManifest, error) {
+	file, err := os.Open(ManifestPath(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadAofManifest(name, file)
+}
+
+// ReadAofManifest
+// decode a manifest, empty lines and lines starting with '#' are ignored
+func ReadAofManifest(name string, r io.Reader) (*AofManifest, error) {
+	manifest := NewAofManifest(name)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		info, err := parseAofFileInfo(line)
+		if err != nil {
+			return nil, err
+		}
+		switch info.Type {
+		case AofBaseType:
+			if manifest.Base != nil {
+				return nil, fmt.Errorf("%w: more than one base file", ErrorManifestCorrupted)
+			}
+			manifest.Base = info
+		case AofIncrType:
+			if len(manifest.Incrs) > 0 && manifest.Incrs[len(manifest.Incrs)-1].Seq >= info.Seq {
+				return nil, fmt.Errorf("%w: incr files out of order", ErrorManifestCorrupted)
+			}
+			manifest.Incrs = append(manifest.Incrs, info)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// parseAofFileInfo
+// parse "file <name> seq <seq> type <b|i>"
+func parseAofFileInfo(line string) (*AofFileInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("%w: invalid line %q", ErrorManifestCorrupted, line)
+	}
+	info := &AofFileInfo{}
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			info.Name = fields[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil || seq <= 0 {
+				return nil, fmt.Errorf("%w: invalid seq in line %q", ErrorManifestCorrupted, line)
+			}
+			info.Seq = seq
+		case "type":
+			if len(fields[i+1]) != 1 {
+				return nil, fmt.Errorf("%w: invalid type in line %q", ErrorManifestCorrupted, line)
+			}
+			info.Type = fields[i+1][0]
+		}
+	}
+	// file names must stay in the directory
+	if info.Name == "" || info.Name != filepath.Base(info.Name) || info.Seq == 0 ||
+		(info.Type != AofBaseType && info.Type != AofIncrType) {
+		return nil, fmt.Errorf("%w: invalid line %q", ErrorManifestCorrupted, line)
+	}
+	return info, nil
+}
+
+// Files
+// all files in loading order
+func (manifest *AofManifest) Files() []*AofFileInfo {
+	files := make([]*AofFileInfo, 0, len(manifest.Incrs)+1)
+	if manifest.Base != nil {
+		files = append(files, manifest.Base)
+	}
+	return append(files, manifest.Incrs...)
+}
+
+// LastIncr
+// the incr file opened for appending, nil if there is no incr file
+func (manifest *AofManifest) LastIncr() *AofFileInfo {
+	if len(manifest.Incrs) == 0 {
+		return nil
+	}
+	return manifest.Incrs[len(manifest.Incrs)-1]
+}
+
+// NextIncr
+// info of a new incr file after the last one, the manifest is not changed
+func (manifest *AofManifest) NextIncr() *AofFileInfo {
+	var seq int64 = 1
+	if last := manifest.LastIncr(); last != nil {
+		seq = last.Seq + 1
+	}
+	return &AofFileInfo{
+		Name: fmt.Sprintf("%s.%d%s", manifest.name, seq, AofIncrSuffix),
+		Seq:  seq,
+		Type: AofIncrType,
+	}
+}
+
+// NextBase
+// info of a new base file replacing the current one, the manifest is not changed
+func (manifest *AofManifest) NextBase() *AofFileInfo {
+	var seq int64 = 1
+	if manifest.Base != nil {
+		seq = manifest.Base.Seq + 1
+	}
+	return &AofFileInfo{
+		Name: fmt.Sprintf("%s.%d%s", manifest.name, seq, AofBaseSuffix),
+		Seq:  seq,
+		Type: AofBaseType,
+	}
+}
+
+// Copy
+// a copy of the manifest, modify it and Save to update the manifest atomically
+func (manifest *AofManifest) Copy() *AofManifest {
+	incrs := make([]*AofFileInfo, len(manifest.Incrs))
+	copy(incrs, manifest.Incrs)
+	return &AofManifest{
+		name:  manifest.name,
+		Base:  manifest.Base,
+		Incrs: incrs,
+	}
+}
+
+// Encode
+// write the manifest in text format
+func (manifest *AofManifest) Encode(w io.Writer) error {
+	for _, info := range manifest.Files() {
+		if _, err := fmt.Fprintf(w, "file %s seq %d type %c\n", info.Name, info.Seq, info.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save
+// replace the manifest in dir atomically
+func (manifest *AofManifest) Save(dir string) error {
+	return WriteFileAtomic(ManifestPath(dir, manifest.name), manifest.Encode)
+}
+
+// LoadAofManifestFiles
+// load the base file then replay incr files of manifest in dir
+func LoadAofManifestFiles(dir string, manifest *AofManifest, loadBase SnapshotHandler, replay func(args []*DbObject) error) error {
+	if manifest.Base != nil {
+		if err := ReadSnapshotFile(filepath.Join(dir, manifest.Base.Name), loadBase); err != nil {
+			return fmt.Errorf("load base file %s: %w", manifest.Base.Name, err)
+		}
+	}
+	for _, info := range manifest.Incrs {
+		if err := LoadAppendOnlyFile(filepath.Join(dir, info.Name), replay); err != nil {
+			return fmt.Errorf("load incr file %s: %w", info.Name, err)
+		}
+	}
+	return nil
+}
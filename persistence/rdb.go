@@ -0,0 +1,891 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Redis RDB import lib
+// decode RDB files (version 9 ~ 11) dumped by upstream Redis into database objects
+// | "REDIS" | version(4 ascii digits) | aux fields ... |
+// | SELECTDB | db index(length) | [RESIZEDB ...] | [EXPIRETIME_MS | ms(8)] | type(1) | key(string) | value |
+// ...
+// | EOF | crc64 jones checksum(8, little endian, 0 if disabled) |
+// length: 00xxxxxx | 01xxxxxx xxxxxxxx | 0x80 uint32(big endian) | 0x81 uint64(big endian) | 11xxxxxx special encoding
+// string: | length | bytes | or special encoding int8 / int16 / int32 / LZF
+
+const (
+	RdbMagic      string = "REDIS"
+	RdbMaxVersion int    = 11
+)
+
+// opcode in RDB file
+const (
+	rdbOpFunction2     byte = 0xF5
+	rdbOpFunctionPreGA byte = 0xF6
+	rdbOpModuleAux     byte = 0xF7
+	rdbOpIdle          byte = 0xF8
+	rdbOpFreq          byte = 0xF9
+	rdbOpAux           byte = 0xFA
+	rdbOpResizeDb      byte = 0xFB
+	rdbOpExpireTimeMs  byte = 0xFC
+	rdbOpExpireTime    byte = 0xFD
+	rdbOpSelectDb      byte = 0xFE
+	rdbOpEOF           byte = 0xFF
+)
+
+// value type in RDB file
+const (
+	rdbTypeString           byte = 0
+	rdbTypeList             byte = 1
+	rdbTypeSet              byte = 2
+	rdbTypeZset             byte = 3
+	rdbTypeHash             byte = 4
+	rdbTypeZset2            byte = 5
+	rdbTypeModule           byte = 6
+	rdbTypeModule2          byte = 7
+	rdbTypeHashZipmap       byte = 9
+	rdbTypeListZiplist      byte = 10
+	rdbTypeSetIntset        byte = 11
+	rdbTypeZsetZiplist      byte = 12
+	rdbTypeHashZiplist      byte = 13
+	rdbTypeListQuicklist    byte = 14
+	rdbTypeStreamListpacks  byte = 15
+	rdbTypeHashListpack     byte = 16
+	rdbTypeZsetListpack     byte = 17
+	rdbTypeListQuicklist2   byte = 18
+	rdbTypeStreamListpacks2 byte = 19
+	rdbTypeSetListpack      byte = 20
+	rdbTypeStreamListpacks3 byte = 21
+)
+
+// special string encoding
+const (
+	rdbEncInt8  uint64 = 0
+	rdbEncInt16 uint64 = 1
+	rdbEncInt32 uint64 = 2
+	rdbEncLzf   uint64 = 3
+)
+
+// quicklist node container
+const (
+	quicklistNodePlain  uint64 = 1
+	quicklistNodePacked uint64 = 2
+)
+
+// types which can not be imported, reported by name
+var rdbUnsupportedTypes map[byte]string = map[byte]string{
+	rdbTypeModule:           "module",
+	rdbTypeModule2:          "module",
+	rdbTypeStreamListpacks:  "stream",
+	rdbTypeStreamListpacks2: "stream",
+	rdbTypeStreamListpacks3: "stream",
+}
+
+// crc64 jones (reflected, init 0, no final xor) used by Redis
+var rdbCrcTable *crc64.Table = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+type rdbCrc64 struct {
+	sum uint64
+}
+
+func (c *rdbCrc64) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.sum = rdbCrcTable[byte(c.sum)^b] ^ (c.sum >> 8)
+	}
+	return len(p), nil
+}
+
+func (c *rdbCrc64) Sum(b []byte) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], c.sum)
+	return append(b, buf[:]...)
+}
+
+func (c *rdbCrc64) Reset()         { c.sum = 0 }
+func (c *rdbCrc64) Size() int      { return 8 }
+func (c *rdbCrc64) BlockSize() int { return 1 }
+func (c *rdbCrc64) Sum64() uint64  { return c.sum }
+
+// ReadRdb
+// decode a Redis RDB stream, call handler for every key
+// checksum is verified at the end of stream unless it is disabled (0)
+func ReadRdb(r io.Reader, handler SnapshotHandler) error {
+	reader := &snapshotReader{
+		reader: bufio.NewReader(r),
+		crc:    &rdbCrc64{},
+		offset: 0,
+	}
+	header := make([]byte, len(RdbMagic)+4)
+	if err := reader.readFull(header); err != nil {
+		return unexpectedEOF(err)
+	}
+	if string(header[:len(RdbMagic)]) != RdbMagic {
+		return ErrorSnapshotCorrupted
+	}
+	version, err := strconv.Atoi(string(header[len(RdbMagic):]))
+	if err != nil {
+		return ErrorSnapshotCorrupted
+	}
+	if version < 1 || version > RdbMaxVersion {
+		return fmt.Errorf("%w: RDB version %d", ErrorUnsupportedVersion, version)
+	}
+	dbIndex := 0
+	var expireTime int64 = -1
+	for {
+		op, err := reader.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch op {
+		case rdbOpEOF:
+			// checksum is added since version 5
+			if version < 5 {
+				return nil
+			}
+			sum := reader.crc.Sum64()
+			var buf [8]byte
+			if err = reader.readFull(buf[:]); err != nil {
+				return unexpectedEOF(err)
+			}
+			if expected := binary.LittleEndian.Uint64(buf[:]); expected != 0 && expected != sum {
+				return ErrorChecksumMismatch
+			}
+			return nil
+		case rdbOpSelectDb:
+			index, _, err := readRdbLength(reader)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			dbIndex = int(index)
+		case rdbOpResizeDb:
+			// hash table size hints
+			if _, _, err = readRdbLength(reader); err == nil {
+				_, _, err = readRdbLength(reader)
+			}
+		case rdbOpAux:
+			// redis-ver, used-mem, ... are ignored
+			if _, err = readRdbString(reader); err == nil {
+				_, err = readRdbString(reader)
+			}
+		case rdbOpExpireTime:
+			var buf [4]byte
+			if err = reader.readFull(buf[:]); err == nil {
+				expireTime = int64(binary.LittleEndian.Uint32(buf[:])) * 1000000000
+			}
+		case rdbOpExpireTimeMs:
+			var buf [8]byte
+			if err = reader.readFull(buf[:]); err == nil {
+				expireTime = int64(binary.LittleEndian.Uint64(buf[:])) * 1000000
+			}
+		case rdbOpFreq:
+			// LFU counter of the next key is not kept
+			_, err = reader.ReadByte()
+		case rdbOpIdle:
+			// LRU idle time of the next key is not kept
+			_, _, err = readRdbLength(reader)
+		case rdbOpFunction2:
+			// function libraries are not supported, skip the code
+			_, err = readRdbString(reader)
+		case rdbOpModuleAux, rdbOpFunctionPreGA:
+			return fmt.Errorf("%w: RDB opcode 0x%02x", ErrorUnsupportedDataType, op)
+		default:
+			key, err := readRdbString(reader)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			val, err := readRdbValue(reader, op, key)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if err = handler(dbIndex, NewStr(key), val, expireTime); err != nil {
+				return err
+			}
+			expireTime = -1
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+// ReadSnapshotFile
+// decode a snapshot file of path, which is either a snapshot or a Redis RDB file
+// return an error satisfying os.IsNotExist if the file does not exist
+func ReadSnapshotFile(path string, handler SnapshotHandler) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	if magic, _ := reader.Peek(len(RdbMagic)); string(magic) == RdbMagic {
+		return ReadRdb(reader, handler)
+	}
+	return ReadSnapshot(reader, handler)
+}
+
+// readRdbLength
+// return the length, or the special encoding type if encoded is true
+func readRdbLength(reader *snapshotReader) (uint64, bool, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := reader.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		if b == 0x80 {
+			var buf [4]byte
+			err = reader.readFull(buf[:])
+			return uint64(binary.BigEndian.Uint32(buf[:])), false, err
+		}
+		if b == 0x81 {
+			var buf [8]byte
+			err = reader.readFull(buf[:])
+			return binary.BigEndian.Uint64(buf[:]), false, err
+		}
+		return 0, false, ErrorSnapshotCorrupted
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+func readRdbString(reader *snapshotReader) (string, error) {
+	length, encoded, err := readRdbLength(reader)
+	if err != nil {
+		return "", err
+	}
+	if encoded {
+		switch length {
+		case rdbEncInt8:
+			b, err := reader.ReadByte()
+			return strconv.FormatInt(int64(int8(b)), 10), err
+		case rdbEncInt16:
+			var buf [2]byte
+			err = reader.readFull(buf[:])
+			return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf[:]))), 10), err
+		case rdbEncInt32:
+			var buf [4]byte
+			err = reader.readFull(buf[:])
+			return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf[:]))), 10), err
+		case rdbEncLzf:
+			return readRdbLzfString(reader)
+		}
+		return "", ErrorSnapshotCorrupted
+	}
+	if length > MaxStringLength {
+		return "", ErrorSnapshotCorrupted
+	}
+	buf := make([]byte, length)
+	if err = reader.readFull(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readRdbLzfString
+// | compressed length | uncompressed length | compressed bytes |
+func readRdbLzfString(reader *snapshotReader) (string, error) {
+	compressedLength, _, err := readRdbLength(reader)
+	if err != nil {
+		return "", err
+	}
+	length, _, err := readRdbLength(reader)
+	if err != nil {
+		return "", err
+	}
+	if compressedLength > MaxStringLength || length > MaxStringLength {
+		return "", ErrorSnapshotCorrupted
+	}
+	compressed := make([]byte, compressedLength)
+	if err = reader.readFull(compressed); err != nil {
+		return "", err
+	}
+	buf, err := lzfDecompress(compressed, int(length))
+	return string(buf), err
+}
+
+// lzfDecompress
+// control byte 000lllll: literal run of l+1 bytes
+// control byte lllooooo [llllllll] oooooooo: back reference of length l+2 (l == 7 means extended)
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i += 1
+		if ctrl < 32 {
+			run := ctrl + 1
+			if i+run > len(in) || len(out)+run > length {
+				return nil, ErrorSnapshotCorrupted
+			}
+			out = append(out, in[i:i+run]...)
+			i += run
+			continue
+		}
+		run := ctrl >> 5
+		if run == 7 {
+			if i >= len(in) {
+				return nil, ErrorSnapshotCorrupted
+			}
+			run += int(in[i])
+			i += 1
+		}
+		if i >= len(in) {
+			return nil, ErrorSnapshotCorrupted
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - 1 - int(in[i])
+		i += 1
+		run += 2
+		if ref < 0 || len(out)+run > length {
+			return nil, ErrorSnapshotCorrupted
+		}
+		// the reference may overlap the bytes being copied
+		for j := 0; j < run; j += 1 {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, ErrorSnapshotCorrupted
+	}
+	return out, nil
+}
+
+func readRdbValue(reader *snapshotReader, valueType byte, key string) (*DbObject, error) {
+	if name, ext := rdbUnsupportedTypes[valueType]; ext {
+		return nil, fmt.Errorf("%w: %s (RDB type %d) of key %s", ErrorUnsupportedDataType, name, valueType, key)
+	}
+	switch valueType {
+	case rdbTypeString:
+		s, err := readRdbString(reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewStr(s), nil
+	case rdbTypeList:
+		members, err := readRdbStrings(reader, 1)
+		if err != nil {
+			return nil, err
+		}
+		return newRdbList(members), nil
+	case rdbTypeSet:
+		members, err := readRdbStrings(reader, 1)
+		if err != nil {
+			return nil, err
+		}
+		return newRdbSet(members)
+	case rdbTypeHash:
+		pairs, err := readRdbStrings(reader, 2)
+		if err != nil {
+			return nil, err
+		}
+		return newRdbHash(pairs)
+	case rdbTypeZset, rdbTypeZset2:
+		return readRdbZset(reader, valueType, key)
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		return readRdbQuicklist(reader, valueType)
+	}
+	// types encoded as a single blob
+	blob, err := readRdbString(reader)
+	if err != nil {
+		return nil, err
+	}
+	switch valueType {
+	case rdbTypeHashZipmap:
+		pairs, err := zipmapEntries([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return newRdbHash(pairs)
+	case rdbTypeListZiplist:
+		members, err := ziplistEntries([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return newRdbList(members), nil
+	case rdbTypeSetIntset:
+		members, err := intsetEntries([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return newRdbSet(members)
+	case rdbTypeSetListpack:
+		members, err := listpackEntries([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return newRdbSet(members)
+	case rdbTypeHashZiplist, rdbTypeHashListpack:
+		decode := ziplistEntries
+		if valueType == rdbTypeHashListpack {
+			decode = listpackEntries
+		}
+		pairs, err := decode([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return newRdbHash(pairs)
+	case rdbTypeZsetZiplist, rdbTypeZsetListpack:
+		decode := ziplistEntries
+		if valueType == rdbTypeZsetListpack {
+			decode = listpackEntries
+		}
+		pairs, err := decode([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs)%2 != 0 {
+			return nil, ErrorSnapshotCorrupted
+		}
+		zset := NewZset()
+		for i := 0; i < len(pairs); i += 2 {
+			score, err := strconv.ParseFloat(pairs[i+1], 64)
+			if err != nil {
+				return nil, ErrorSnapshotCorrupted
+			}
+			if err = addRdbZsetMember(zset, key, pairs[i], score); err != nil {
+				return nil, err
+			}
+		}
+		return NewObject(ZSET, zset), nil
+	}
+	return nil, fmt.Errorf("%w: unknown RDB type %d of key %s", ErrorUnsupportedDataType, valueType, key)
+}
+
+// readRdbStrings
+// | length | string ... |, there are length * group strings
+func readRdbStrings(reader *snapshotReader, group uint64) ([]string, error) {
+	length, _, err := readRdbLength(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > MaxStringLength {
+		return nil, ErrorSnapshotCorrupted
+	}
+	strs := make([]string, 0, length*group)
+	for i := uint64(0); i < length*group; i += 1 {
+		s, err := readRdbString(reader)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// readRdbZset
+// | length | member(string) score | ..., score is a double string (ZSET) or binary double (ZSET_2)
+func readRdbZset(reader *snapshotReader, valueType byte, key string) (*DbObject, error) {
+	length, _, err := readRdbLength(reader)
+	if err != nil {
+		return nil, err
+	}
+	zset := NewZset()
+	for i := uint64(0); i < length; i += 1 {
+		member, err := readRdbString(reader)
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if valueType == rdbTypeZset2 {
+			var buf [8]byte
+			if err = reader.readFull(buf[:]); err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+		} else if score, err = readRdbDoubleString(reader); err != nil {
+			return nil, err
+		}
+		if err = addRdbZsetMember(zset, key, member, score); err != nil {
+			return nil, err
+		}
+	}
+	return NewObject(ZSET, zset), nil
+}
+
+// readRdbDoubleString
+// | length(1) | ascii |, length 253 / 254 / 255 means nan / +inf / -inf
+func readRdbDoubleString(reader *snapshotReader) (float64, error) {
+	length, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	if err = reader.readFull(buf); err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, ErrorSnapshotCorrupted
+	}
+	return score, nil
+}
+
+// addRdbZsetMember
+// zset scores are integers, a score which can not be kept exactly is reported
+func addRdbZsetMember(zset *Zset, key, member string, score float64) error {
+	if score != math.Trunc(score) || math.Abs(score) > float64(MaxScore) {
+		return fmt.Errorf("%w: score %v of member %s in zset %s is not an integer", ErrorUnsupportedDataType, score, member, key)
+	}
+	return zset.AddMember(NewStr(member), int64(score))
+}
+
+// readRdbQuicklist
+// QUICKLIST: | length | ziplist(string) ... |
+// QUICKLIST_2: | length | container | listpack or plain element(string) ... |
+func readRdbQuicklist(reader *snapshotReader, valueType byte) (*DbObject, error) {
+	length, _, err := readRdbLength(reader)
+	if err != nil {
+		return nil, err
+	}
+	list := NewLinkedList()
+	for i := uint64(0); i < length; i += 1 {
+		container := quicklistNodePacked
+		if valueType == rdbTypeListQuicklist2 {
+			if container, _, err = readRdbLength(reader); err != nil {
+				return nil, err
+			}
+		}
+		node, err := readRdbString(reader)
+		if err != nil {
+			return nil, err
+		}
+		var members []string
+		switch {
+		case container == quicklistNodePlain:
+			members = []string{node}
+		case container == quicklistNodePacked && valueType == rdbTypeListQuicklist:
+			members, err = ziplistEntries([]byte(node))
+		case container == quicklistNodePacked:
+			members, err = listpackEntries([]byte(node))
+		default:
+			err = ErrorSnapshotCorrupted
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			list.Rpush(NewStr(member))
+		}
+	}
+	return NewObject(LINKDLIST, list), nil
+}
+
+func newRdbList(members []string) *DbObject {
+	list := NewLinkedList()
+	for _, member := range members {
+		list.Rpush(NewStr(member))
+	}
+	return NewObject(LINKDLIST, list)
+}
+
+func newRdbSet(members []string) (*DbObject, error) {
+	set := NewSet()
+	for _, member := range members {
+		if err := set.Add(NewStr(member)); err != nil {
+			return nil, err
+		}
+	}
+	return NewObject(SET, set), nil
+}
+
+func newRdbHash(pairs []string) (*DbObject, error) {
+	if len(pairs)%2 != 0 {
+		return nil, ErrorSnapshotCorrupted
+	}
+	hash := NewHash()
+	for i := 0; i < len(pairs); i += 2 {
+		if err := hash.Set(NewStr(pairs[i]), NewStr(pairs[i+1])); err != nil {
+			return nil, err
+		}
+	}
+	return NewObject(HASH, hash), nil
+}
+
+// compact encodings
+
+// blobReader
+// bounds checked reader of an encoded blob
+type blobReader struct {
+	buf []byte
+	pos int
+}
+
+func (b *blobReader) take(n int) ([]byte, error) {
+	if n < 0 || b.pos+n > len(b.buf) {
+		return nil, ErrorSnapshotCorrupted
+	}
+	data := b.buf[b.pos : b.pos+n]
+	b.pos += n
+	return data, nil
+}
+
+func (b *blobReader) peek() (byte, error) {
+	if b.pos >= len(b.buf) {
+		return 0, ErrorSnapshotCorrupted
+	}
+	return b.buf[b.pos], nil
+}
+
+// ziplistEntries
+// | zlbytes(4) | zltail(4) | zllen(2) | entry ... | 0xFF |
+// entry: | prevlen(1 or 0xFE + 4) | encoding | data |
+func ziplistEntries(buf []byte) ([]string, error) {
+	b := &blobReader{buf: buf, pos: 10}
+	entries := make([]string, 0)
+	for {
+		head, err := b.peek()
+		if err != nil {
+			return nil, err
+		}
+		if head == 0xFF {
+			return entries, nil
+		}
+		prevlen := 1
+		if head == 0xFE {
+			prevlen = 5
+		}
+		if _, err = b.take(prevlen); err != nil {
+			return nil, err
+		}
+		data, err := b.take(1)
+		if err != nil {
+			return nil, err
+		}
+		enc := data[0]
+		var entry string
+		switch {
+		case enc>>6 == 0:
+			data, err = b.take(int(enc & 0x3F))
+			entry = string(data)
+		case enc>>6 == 1:
+			if data, err = b.take(1); err == nil {
+				data, err = b.take(int(enc&0x3F)<<8 | int(data[0]))
+				entry = string(data)
+			}
+		case enc == 0x80:
+			if data, err = b.take(4); err == nil {
+				data, err = b.take(int(binary.BigEndian.Uint32(data)))
+				entry = string(data)
+			}
+		case enc == 0xC0:
+			data, err = b.take(2)
+			entry = formatLittleEndianInt(data)
+		case enc == 0xD0:
+			data, err = b.take(4)
+			entry = formatLittleEndianInt(data)
+		case enc == 0xE0:
+			data, err = b.take(8)
+			entry = formatLittleEndianInt(data)
+		case enc == 0xF0:
+			data, err = b.take(3)
+			entry = formatLittleEndianInt(data)
+		case enc == 0xFE:
+			data, err = b.take(1)
+			entry = formatLittleEndianInt(data)
+		case enc >= 0xF1 && enc <= 0xFD:
+			// 4 bit immediate integer 0 ~ 12
+			entry = strconv.Itoa(int(enc&0x0F) - 1)
+		default:
+			err = ErrorSnapshotCorrupted
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// listpackEntries
+// | total bytes(4) | num elements(2) | entry ... | 0xFF |
+// entry: | encoding | data | backlen(1 ~ 5) |
+func listpackEntries(buf []byte) ([]string, error) {
+	b := &blobReader{buf: buf, pos: 6}
+	entries := make([]string, 0)
+	for {
+		enc, err := b.peek()
+		if err != nil {
+			return nil, err
+		}
+		if enc == 0xFF {
+			return entries, nil
+		}
+		start := b.pos
+		var entry string
+		var data []byte
+		switch {
+		case enc&0x80 == 0:
+			// 7 bit unsigned integer
+			_, err = b.take(1)
+			entry = strconv.Itoa(int(enc))
+		case enc&0xC0 == 0x80:
+			if _, err = b.take(1); err == nil {
+				data, err = b.take(int(enc & 0x3F))
+				entry = string(data)
+			}
+		case enc&0xE0 == 0xC0:
+			// 13 bit signed integer
+			if data, err = b.take(2); err == nil {
+				value := int(enc&0x1F)<<8 | int(data[1])
+				if value >= 1<<12 {
+					value -= 1 << 13
+				}
+				entry = strconv.Itoa(value)
+			}
+		case enc&0xF0 == 0xE0:
+			if data, err = b.take(2); err == nil {
+				data, err = b.take(int(enc&0x0F)<<8 | int(data[1]))
+				entry = string(data)
+			}
+		case enc == 0xF0:
+			if data, err = b.take(5); err == nil {
+				data, err = b.take(int(binary.LittleEndian.Uint32(data[1:])))
+				entry = string(data)
+			}
+		case enc >= 0xF1 && enc <= 0xF4:
+			size := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[enc]
+			if data, err = b.take(1 + size); err == nil {
+				entry = formatLittleEndianInt(data[1:])
+			}
+		default:
+			err = ErrorSnapshotCorrupted
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err = b.take(listpackBacklenSize(b.pos - start)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// listpackBacklenSize
+// bytes used to encode the length of an entry backwards, 7 bits per byte
+func listpackBacklenSize(length int) int {
+	switch {
+	case length <= 127:
+		return 1
+	case length < 16383:
+		return 2
+	case length < 2097151:
+		return 3
+	case length < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// intsetEntries
+// | encoding(4, 2 / 4 / 8) | length(4) | integers(little endian, sorted) |
+func intsetEntries(buf []byte) ([]string, error) {
+	b := &blobReader{buf: buf}
+	header, err := b.take(8)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(header[:4]))
+	length := int(binary.LittleEndian.Uint32(header[4:]))
+	if size != 2 && size != 4 && size != 8 {
+		return nil, ErrorSnapshotCorrupted
+	}
+	entries := make([]string, 0, length)
+	for i := 0; i < length; i += 1 {
+		data, err := b.take(size)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, formatLittleEndianInt(data))
+	}
+	return entries, nil
+}
+
+// zipmapEntries
+// | zmlen(1) | len | key | len | free(1) | value | free bytes | ... | 0xFF |
+// len: 1 byte if < 254, otherwise 0xFE + 4 bytes
+func zipmapEntries(buf []byte) ([]string, error) {
+	b := &blobReader{buf: buf, pos: 1}
+	readLen := func() (int, bool, error) {
+		data, err := b.take(1)
+		if err != nil {
+			return 0, false, err
+		}
+		switch data[0] {
+		case 0xFF:
+			return 0, true, nil
+		case 0xFE:
+			data, err = b.take(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return int(binary.LittleEndian.Uint32(data)), false, nil
+		default:
+			return int(data[0]), false, nil
+		}
+	}
+	entries := make([]string, 0)
+	for {
+		length, end, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return entries, nil
+		}
+		key, err := b.take(length)
+		if err != nil {
+			return nil, err
+		}
+		if length, _, err = readLen(); err != nil {
+			return nil, err
+		}
+		free, err := b.take(1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := b.take(length)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = b.take(int(free[0])); err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(key), string(value))
+	}
+}
+
+// formatLittleEndianInt
+// format a signed little endian integer of 1 ~ 8 bytes
+func formatLittleEndianInt(data []byte) string {
+	var value uint64 = 0
+	for i := len(data) - 1; i >= 0; i -= 1 {
+		value = value<<8 | uint64(data[i])
+	}
+	// sign extend
+	shift := uint(64 - 8*len(data))
+	return strconv.FormatInt(int64(value<<shift)>>shift, 10)
+}
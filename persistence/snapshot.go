@@ -0,0 +1,598 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// database snapshot (point-in-time binary dump) lib
+// file format:
+// | MAGIC(8) | VERSION(2, big endian) |
+// | OpSelectDb | db index(uvarint) |
+// | [OpExpireTime | expire time(int64 ms, little endian)] | value type(1) | key(string) | value |
+// ...
+// | OpEOF | crc64 checksum of all bytes before(8, little endian) |
+// string: | length(uvarint) | bytes |
+// list / set: | length(uvarint) | string ... |
+// hash: | length(uvarint) | field(string) value(string) ... |
+// zset: | length(uvarint) | member(string) score(varint) ... |
+
+const (
+	SnapshotMagic   string = "GOKVSNAP"
+	SnapshotVersion uint16 = 1
+	// max length of a string in snapshot file, to avoid huge allocation when file corrupted
+	MaxStringLength uint64 = 512 << 20
+)
+
+// value type in snapshot file
+const (
+	TypeString byte = 0x00
+	TypeList   byte = 0x01
+	TypeSet    byte = 0x02
+	TypeHash   byte = 0x03
+	TypeZset   byte = 0x04
+)
+
+// opcode in snapshot file
+const (
+	OpExpireTime byte = 0xFC
+	OpSelectDb   byte = 0xFE
+	OpEOF        byte = 0xFF
+)
+
+var (
+	ErrorSnapshotCorrupted   error = errors.New("Snapshot file is corrupted")
+	ErrorChecksumMismatch    error = errors.New("Snapshot checksum mismatch")
+	ErrorUnsupportedVersion  error = errors.New("Snapshot version is not supported")
+	ErrorUnsupportedDataType error = errors.New("Value type is not supported by snapshot")
+	ErrorSnapshotAborted     error = errors.New("Background snapshot is aborted")
+)
+
+var crcTable *crc64.Table = crc64.MakeTable(crc64.ECMA)
+
+// SnapshotHandler
+// called for every key decoded from snapshot
+// expireTime is -1 if the key never expires, otherwise unix nano
+type SnapshotHandler func(dbIndex int, key, val *DbObject, expireTime int64) error
+
+// encode
+
+type SnapshotEncoder struct {
+	writer *bufio.Writer
+	crc    hash.Hash64
+	// writes to both writer and crc
+	out io.Writer
+}
+
+func NewSnapshotEncoder(w io.Writer) *SnapshotEncoder {
+	writer := bufio.NewWriter(w)
+	crc := crc64.New(crcTable)
+	return &SnapshotEncoder{
+		writer: writer,
+		crc:    crc,
+		out:    io.MultiWriter(writer, crc),
+	}
+}
+
+// NewSnapshotFileEncoder
+// create a snapshot encoder and write the file header
+func NewSnapshotFileEncoder(w io.Writer) (EntryEncoder, error) {
+	enc := NewSnapshotEncoder(w)
+	if err := enc.WriteHeader(); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+func (enc *SnapshotEncoder) WriteHeader() error {
+	if _, err := io.WriteString(enc.out, SnapshotMagic); err != nil {
+		return err
+	}
+	var version [2]byte
+	binary.BigEndian.PutUint16(version[:], SnapshotVersion)
+	_, err := enc.out.Write(version[:])
+	return err
+}
+
+func (enc *SnapshotEncoder) WriteSelectDb(index int) error {
+	if err := enc.writeByte(OpSelectDb); err != nil {
+		return err
+	}
+	return enc.writeUvarint(uint64(index))
+}
+
+// WriteEntry
+// write a key value pair, expireTime < 0 means the key never expires
+func (enc *SnapshotEncoder) WriteEntry(key, val *DbObject, expireTime int64) error {
+	if err := enc.writeEntryHeader(key, val, expireTime); err != nil {
+		return err
+	}
+	return enc.WriteValue(val)
+}
+
+// BeginEntry
+// write the header of an aggregate value written in parts, followed by WriteElement of
+// every element and EndEntry
+func (enc *SnapshotEncoder) BeginEntry(key, val *DbObject, expireTime int64) error {
+	if err := enc.writeEntryHeader(key, val, expireTime); err != nil {
+		return err
+	}
+	return enc.writeUvarint(uint64(NewElementWalker(val).Len()))
+}
+
+// WriteElement
+// write an element returned by ElementWalker of val
+func (enc *SnapshotEncoder) WriteElement(key, val, element, extra *DbObject) error {
+	if err := enc.writeString(element.StrVal()); err != nil || extra == nil {
+		return err
+	}
+	if val.Type == ZSET {
+		score, _ := extra.IntVal()
+		return enc.writeVarint(score)
+	}
+	return enc.writeString(extra.StrVal())
+}
+
+func (enc *SnapshotEncoder) EndEntry(key *DbObject, expireTime int64) error {
+	return nil
+}
+
+// writeEntryHeader
+// write expire time, value type and key of an entry
+func (enc *SnapshotEncoder) writeEntryHeader(key, val *DbObject, expireTime int64) error {
+	if expireTime >= 0 {
+		if err := enc.writeByte(OpExpireTime); err != nil {
+			return err
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(expireTime/1000000))
+		if _, err := enc.out.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	valueType, err := snapshotType(val)
+	if err != nil {
+		return err
+	}
+	if err = enc.writeByte(valueType); err != nil {
+		return err
+	}
+	return enc.writeString(key.StrVal())
+}
+
+// WriteValue
+// write the value part of an entry (without value type)
+func (enc *SnapshotEncoder) WriteValue(val *DbObject) error {
+	switch val.Type {
+	case STR:
+		return enc.writeString(val.StrVal())
+	case LINKDLIST, SET, HASH, ZSET:
+		walker := NewElementWalker(val)
+		if err := enc.writeUvarint(uint64(walker.Len())); err != nil {
+			return err
+		}
+		var err error
+		walker.Walk(math.MaxInt, func(element, extra *DbObject) {
+			if err == nil {
+				err = enc.WriteElement(nil, val, element, extra)
+			}
+		})
+		return err
+	}
+	return ErrorUnsupportedDataType
+}
+
+// Flush
+// flush buffered bytes to the underlying writer
+func (enc *SnapshotEncoder) Flush() error {
+	return enc.writer.Flush()
+}
+
+// WriteEnd
+// write EOF and checksum, then flush
+func (enc *SnapshotEncoder) WriteEnd() error {
+	if err := enc.writeByte(OpEOF); err != nil {
+		return err
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], enc.crc.Sum64())
+	if _, err := enc.writer.Write(buf[:]); err != nil {
+		return err
+	}
+	return enc.writer.Flush()
+}
+
+func (enc *SnapshotEncoder) writeByte(b byte) error {
+	_, err := enc.out.Write([]byte{b})
+	return err
+}
+
+func (enc *SnapshotEncoder) writeUvarint(x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	_, err := enc.out.Write(buf[:n])
+	return err
+}
+
+func (enc *SnapshotEncoder) writeVarint(x int64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	_, err := enc.out.Write(buf[:n])
+	return err
+}
+
+func (enc *SnapshotEncoder) writeString(s string) error {
+	if err := enc.writeUvarint(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(enc.out, s)
+	return err
+}
+
+func snapshotType(val *DbObject) (byte, error) {
+	switch val.Type {
+	case STR:
+		return TypeString, nil
+	case LINKDLIST:
+		return TypeList, nil
+	case SET:
+		return TypeSet, nil
+	case HASH:
+		return TypeHash, nil
+	case ZSET:
+		return TypeZset, nil
+	}
+	return 0, ErrorUnsupportedDataType
+}
+
+// decode
+
+// snapshotReader
+// compute checksum and offset of all bytes read
+type snapshotReader struct {
+	reader *bufio.Reader
+	crc    hash.Hash64
+	offset int64
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	return &snapshotReader{
+		reader: bufio.NewReader(r),
+		crc:    crc64.New(crcTable),
+		offset: 0,
+	}
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.crc.Write([]byte{b})
+	r.offset += 1
+	return b, nil
+}
+
+func (r *snapshotReader) readFull(buf []byte) error {
+	n, err := io.ReadFull(r.reader, buf)
+	r.crc.Write(buf[:n])
+	r.offset += int64(n)
+	return err
+}
+
+func (r *snapshotReader) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func (r *snapshotReader) readVarint() (int64, error) {
+	return binary.ReadVarint(r)
+}
+
+func (r *snapshotReader) readString() (string, error) {
+	length, err := r.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	if length > MaxStringLength {
+		return "", ErrorSnapshotCorrupted
+	}
+	buf := make([]byte, length)
+	if err = r.readFull(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// ReadSnapshot
+// decode a snapshot stream, call handler for every key
+// checksum is verified at the end of stream
+func ReadSnapshot(r io.Reader, handler SnapshotHandler) error {
+	_, err := readSnapshot(newSnapshotReader(r), handler)
+	return err
+}
+
+// readSnapshot
+// return the offset where decoding stopped
+func readSnapshot(reader *snapshotReader, handler SnapshotHandler) (int64, error) {
+	if err := readHeader(reader); err != nil {
+		return 0, err
+	}
+	dbIndex := 0
+	var expireTime int64 = -1
+	for {
+		op, err := reader.ReadByte()
+		if err != nil {
+			return reader.offset, unexpectedEOF(err)
+		}
+		switch op {
+		case OpEOF:
+			sum := reader.crc.Sum64()
+			var buf [8]byte
+			// checksum itself is not covered by crc, sum is taken before reading
+			if err = reader.readFull(buf[:]); err != nil {
+				return reader.offset, unexpectedEOF(err)
+			}
+			if binary.LittleEndian.Uint64(buf[:]) != sum {
+				return reader.offset, ErrorChecksumMismatch
+			}
+			return reader.offset, nil
+		case OpSelectDb:
+			index, err := reader.readUvarint()
+			if err != nil {
+				return reader.offset, unexpectedEOF(err)
+			}
+			dbIndex = int(index)
+		case OpExpireTime:
+			var buf [8]byte
+			if err = reader.readFull(buf[:]); err != nil {
+				return reader.offset, unexpectedEOF(err)
+			}
+			expireTime = int64(binary.LittleEndian.Uint64(buf[:])) * 1000000
+		default:
+			key, err := reader.readString()
+			if err != nil {
+				return reader.offset, unexpectedEOF(err)
+			}
+			val, err := readValue(reader, op)
+			if err != nil {
+				return reader.offset, unexpectedEOF(err)
+			}
+			if err = handler(dbIndex, NewStr(key), val, expireTime); err != nil {
+				return reader.offset, err
+			}
+			expireTime = -1
+		}
+	}
+}
+
+func readHeader(reader *snapshotReader) error {
+	header := make([]byte, len(SnapshotMagic)+2)
+	if err := reader.readFull(header); err != nil {
+		return unexpectedEOF(err)
+	}
+	if string(header[:len(SnapshotMagic)]) != SnapshotMagic {
+		return ErrorSnapshotCorrupted
+	}
+	if binary.BigEndian.Uint16(header[len(SnapshotMagic):]) > SnapshotVersion {
+		return ErrorUnsupportedVersion
+	}
+	return nil
+}
+
+// ReadValue
+// decode a value of valueType, which is written by SnapshotEncoder.WriteValue
+func ReadValue(r io.Reader, valueType byte) (*DbObject, error) {
+	val, err := readValue(newSnapshotReader(r), valueType)
+	return val, unexpectedEOF(err)
+}
+
+func readValue(reader *snapshotReader, valueType byte) (*DbObject, error) {
+	switch valueType {
+	case TypeString:
+		s, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		return NewStr(s), nil
+	case TypeList:
+		list := NewLinkedList()
+		err := readStrings(reader, func(s string) error {
+			list.Rpush(NewStr(s))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewObject(LINKDLIST, list), nil
+	case TypeSet:
+		set := NewSet()
+		err := readStrings(reader, func(s string) error {
+			return set.Add(NewStr(s))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewObject(SET, set), nil
+	case TypeHash:
+		hash := NewHash()
+		length, err := reader.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < length; i += 1 {
+			field, err := reader.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := reader.readString()
+			if err != nil {
+				return nil, err
+			}
+			if err = hash.Set(NewStr(field), NewStr(value)); err != nil {
+				return nil, err
+			}
+		}
+		return NewObject(HASH, hash), nil
+	case TypeZset:
+		zset := NewZset()
+		length, err := reader.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < length; i += 1 {
+			member, err := reader.readString()
+			if err != nil {
+				return nil, err
+			}
+			score, err := reader.readVarint()
+			if err != nil {
+				return nil, err
+			}
+			if err = zset.AddMember(NewStr(member), score); err != nil {
+				return nil, err
+			}
+		}
+		return NewObject(ZSET, zset), nil
+	}
+	return nil, fmt.Errorf("%w: unknown value type 0x%02x", ErrorSnapshotCorrupted, valueType)
+}
+
+func readStrings(reader *snapshotReader, fn func(s string) error) error {
+	length, err := reader.readUvarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < length; i += 1 {
+		s, err := reader.readString()
+		if err != nil {
+			return err
+		}
+		if err = fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// snapshot file
+
+// WriteSnapshot
+// write all databases as a snapshot stream
+func WriteSnapshot(w io.Writer, dbs []*Database) error {
+	enc, err := NewSnapshotFileEncoder(w)
+	if err != nil {
+		return err
+	}
+	return writeDatabases(enc, dbs)
+}
+
+// writeDatabases
+// write keys of every non-empty database after selecting it, then the end of stream
+func writeDatabases(enc EntryEncoder, dbs []*Database) error {
+	var err error
+	for index, db := range dbs {
+		if db.Size() == 0 {
+			continue
+		}
+		if err = enc.WriteSelectDb(index); err != nil {
+			return err
+		}
+		db.ForEach(func(key, val *DbObject, expireTime int64) bool {
+			err = enc.WriteEntry(key, val, expireTime)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return enc.WriteEnd()
+}
+
+// SaveSnapshot
+// write databases to a temp file first, then rename it to path atomically
+func SaveSnapshot(path string, dbs []*Database) error {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		return WriteSnapshot(w, dbs)
+	})
+}
+
+// LoadSnapshot
+// load snapshot file (or Redis RDB file) into databases
+// return an error satisfying os.IsNotExist if the file does not exist
+func LoadSnapshot(path string, dbs []*Database) error {
+	return ReadSnapshotFile(path, func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		if err := CheckDbIndex(dbIndex, len(dbs)); err != nil {
+			return err
+		}
+		return dbs[dbIndex].SetKeyObject(key, val, expireTime)
+	})
+}
+
+// CheckDbIndex
+// keys of a db index out of range can not be loaded
+func CheckDbIndex(dbIndex, databases int) error {
+	if dbIndex < 0 || dbIndex >= databases {
+		return fmt.Errorf("Keys of db %d can not be loaded, only %d databases are configured", dbIndex, databases)
+	}
+	return nil
+}
+
+// WriteFileAtomic
+// write data to a temp file in the same directory, fsync it and rename to path with RenameFile
+// the old file stays valid until rename succeeds
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = RenameFile(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// RenameFile
+// rename oldPath to newPath and fsync the directory of newPath, so the rename survives a crash
+// newPath may already be renamed if fsync fails
+func RenameFile(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
@@ -1,10 +1,13 @@
 package service
 
 import (
+	"errors"
 	. "goRedis/data_structure"
 	. "goRedis/db"
+	"goRedis/persistence"
 	"goRedis/util"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -27,9 +30,44 @@ const (
 	BulkArrayHead  string = "*"
 	CRLF           string = "\r\n"
 	WELCOME        string = "+Welcome!\r\n"
+	// keys returned by a KEYSTATS call without COUNT
+	DefaultKeystatsCount int = 10
 )
 
-type handleProcess func(args []*DbObject, db *Database) string
+type handleProcess func(args []*DbObject, db *Database, server Server) string
+
+// Server
+// server level operations needed by commands, implemented by core.Server
+// (service can not import core)
+type Server interface {
+	// Save do snapshot synchronously
+	Save() error
+	// BgSave do snapshot in background
+	BgSave() error
+	// BgRewriteAof rewrite append only file in background
+	BgRewriteAof() error
+	// IncrDirty add the number of changes since the last save, called by every mutating command
+	IncrDirty(delta int64)
+	// LastSave unix time of the last successful save
+	LastSave() int64
+	// Info server information of section ("" for all sections)
+	Info(section string) string
+	// Import load keys from a snapshot or Redis RDB file at path relative to the data directory,
+	// return the number of keys imported
+	Import(path string) (int64, error)
+	// MemoryStats memory usage of server, names and values of fields in order
+	MemoryStats() []string
+	// SelectDb select the database used by the following commands
+	SelectDb(index int) error
+	// Databases all databases, indexed by db index
+	Databases() []*Database
+	// BeforeWriteKey called before a key of database dbIndex is modified by a command which
+	// writes keys of another database (e.g. MOVE)
+	BeforeWriteKey(dbIndex int, key *DbObject)
+	// CommandTime unix nano when the command being handled started, relative expire times of
+	// the command and of its AOF args are based on it
+	CommandTime() int64
+}
 
 type DataBaseCommand struct {
 	name    string
@@ -37,6 +75,22 @@ type DataBaseCommand struct {
 	id      uint32 // id: 高16位-操作的value种类, 0为功能指令， 低16位-操作种类
 	minArgs int32  // args number a valid command needed
 	maxArgs int32
+	isWrite bool // whether the command may modify the database
+	// whether the command may use more memory, rejected when maxmemory is reached
+	denyOom bool
+	// args[firstKey:lastKey+1] are keys, firstKey == 0 if the command has no key
+	// lastKey < 0 counts from the end, e.g. -1 for commands taking any number of keys
+	firstKey int32
+	lastKey  int32
+	// keys found by the command itself for commands whose keys are given by options (e.g. STORE
+	// of SORT), used instead of firstKey and lastKey if not nil
+	getKeys func(args []*DbObject) []*DbObject
+	// args written to AOF instead of the original ones, nil if the command is written as it is
+	// commands using relative time are converted to absolute time, otherwise loading AOF
+	// would apply the time again. both proc and propagate convert relative time based on
+	// server.CommandTime(), so the absolute time written is the one applied
+	// it returns nil if the command changes nothing and is not written
+	propagate func(args []*DbObject, server Server) []*DbObject
 }
 
 var router map[string]*DataBaseCommand
@@ -45,206 +99,551 @@ func init() {
 	router = make(map[string]*DataBaseCommand, 0)
 	// string
 	router["GET"] = &DataBaseCommand{
-		name:    "get",
-		proc:    getCommandProcess,
-		id:      1<<16 | 1,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "get",
+		proc:     getCommandProcess,
+		id:       1<<16 | 1,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	router["SET"] = &DataBaseCommand{
-		name:    "set",
-		proc:    setCommandProcess,
-		id:      1<<16 | 2,
-		minArgs: 3,
-		maxArgs: 3,
+		name:      "set",
+		proc:      setCommandProcess,
+		id:        1<<16 | 2,
+		minArgs:   3,
+		maxArgs:   7,
+		firstKey:  1,
+		lastKey:   1,
+		isWrite:   true,
+		denyOom:   true,
+		propagate: propagateSet,
 	}
 	router["SETEX"] = &DataBaseCommand{
-		name:    "setex",
-		proc:    setexCommandProcess,
-		id:      1<<16 | 3,
-		minArgs: 4,
-		maxArgs: 4,
+		name:      "setex",
+		proc:      setexCommandProcess,
+		id:        1<<16 | 3,
+		minArgs:   4,
+		maxArgs:   4,
+		firstKey:  1,
+		lastKey:   1,
+		isWrite:   true,
+		denyOom:   true,
+		propagate: propagateSetex,
 	}
 	router["SETNX"] = &DataBaseCommand{
-		name:    "setnx",
-		proc:    setnxCommandProcess,
-		id:      1<<16 | 4,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "setnx",
+		proc:     setnxCommandProcess,
+		id:       1<<16 | 4,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["INCRBY"] = &DataBaseCommand{
-		name:    "incrby",
-		proc:    incrbyCommandProcess,
-		id:      1<<16 | 5,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "incrby",
+		proc:     incrbyCommandProcess,
+		id:       1<<16 | 5,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["INCR"] = &DataBaseCommand{
-		name:    "incr",
-		proc:    incrCommandProcess,
-		id:      1<<16 | 6,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "incr",
+		proc:     incrCommandProcess,
+		id:       1<<16 | 6,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["DECR"] = &DataBaseCommand{
-		name:    "decr",
-		proc:    decrCommandProcess,
-		id:      1<<16 | 7,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "decr",
+		proc:     decrCommandProcess,
+		id:       1<<16 | 7,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	// zset
 	router["ZADD"] = &DataBaseCommand{
-		name:    "zadd",
-		proc:    zaddCommandProcess,
-		id:      1<<17 | 1,
-		minArgs: 4,
-		maxArgs: 4,
+		name:     "zadd",
+		proc:     zaddCommandProcess,
+		id:       1<<17 | 1,
+		minArgs:  4,
+		maxArgs:  4,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["ZRANGE"] = &DataBaseCommand{
-		name:    "zrange",
-		proc:    zrangeCommandProcess,
-		id:      1<<17 | 2,
-		minArgs: 4,
-		maxArgs: 4,
+		name:     "zrange",
+		proc:     zrangeCommandProcess,
+		id:       1<<17 | 2,
+		minArgs:  4,
+		maxArgs:  4,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	router["ZINCREBY"] = &DataBaseCommand{
-		name:    "zincreby",
-		proc:    zincrebyCommandProcess,
-		id:      1<<17 | 3,
-		minArgs: 4,
-		maxArgs: 4,
+		name:     "zincreby",
+		proc:     zincrebyCommandProcess,
+		id:       1<<17 | 3,
+		minArgs:  4,
+		maxArgs:  4,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["ZREM"] = &DataBaseCommand{
-		name:    "zrange",
-		proc:    zremCommandProcess,
-		id:      1<<17 | 4,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "zrange",
+		proc:     zremCommandProcess,
+		id:       1<<17 | 4,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
 	}
 	router["ZSCORE"] = &DataBaseCommand{
-		name:    "zscore",
-		proc:    zscoreCommandProcess,
-		id:      1<<17 | 5,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "zscore",
+		proc:     zscoreCommandProcess,
+		id:       1<<17 | 5,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["ZSCAN"] = &DataBaseCommand{
+		name:     "zscan",
+		proc:     zscanCommandProcess,
+		id:       1<<17 | 6,
+		minArgs:  3,
+		maxArgs:  7,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	// hash
 	router["HSET"] = &DataBaseCommand{
-		name:    "hset",
-		proc:    hsetCommandProcess,
-		id:      1<<18 | 1,
-		minArgs: 4,
-		maxArgs: 4,
+		name:     "hset",
+		proc:     hsetCommandProcess,
+		id:       1<<18 | 1,
+		minArgs:  4,
+		maxArgs:  4,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["HGET"] = &DataBaseCommand{
-		name:    "hget",
-		proc:    hgetCommandProcess,
-		id:      1<<18 | 2,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "hget",
+		proc:     hgetCommandProcess,
+		id:       1<<18 | 2,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	router["HDEL"] = &DataBaseCommand{
-		name:    "hdel",
-		proc:    hdelCommandProcess,
-		id:      1<<18 | 3,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "hdel",
+		proc:     hdelCommandProcess,
+		id:       1<<18 | 3,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+	}
+	router["HSCAN"] = &DataBaseCommand{
+		name:     "hscan",
+		proc:     hscanCommandProcess,
+		id:       1<<18 | 4,
+		minArgs:  3,
+		maxArgs:  7,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	// set
 	router["SADD"] = &DataBaseCommand{
-		name:    "sadd",
-		proc:    saddCommandProcess,
-		id:      1<<19 | 1,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "sadd",
+		proc:     saddCommandProcess,
+		id:       1<<19 | 1,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["SMEMBERS"] = &DataBaseCommand{
-		name:    "smembers",
-		proc:    smembersCommandProcess,
-		id:      1<<19 | 2,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "smembers",
+		proc:     smembersCommandProcess,
+		id:       1<<19 | 2,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	router["SCARD"] = &DataBaseCommand{
-		name:    "smembers",
-		proc:    scardCommandProcess,
-		id:      1<<19 | 3,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "smembers",
+		proc:     scardCommandProcess,
+		id:       1<<19 | 3,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	router["SINTER"] = &DataBaseCommand{
-		name:    "sinter",
-		proc:    sinterCommandProcess,
-		id:      1<<19 | 4,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "sinter",
+		proc:     sinterCommandProcess,
+		id:       1<<19 | 4,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  2,
 	}
 	router["SUNION"] = &DataBaseCommand{
-		name:    "sunion",
-		proc:    sunionCommandProcess,
-		id:      1<<19 | 5,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "sunion",
+		proc:     sunionCommandProcess,
+		id:       1<<19 | 5,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  2,
 	}
 	router["SREM"] = &DataBaseCommand{
-		name:    "srem",
-		proc:    sremCommandProcess,
-		id:      1<<19 | 6,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "srem",
+		proc:     sremCommandProcess,
+		id:       1<<19 | 6,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+	}
+	router["SSCAN"] = &DataBaseCommand{
+		name:     "sscan",
+		proc:     sscanCommandProcess,
+		id:       1<<19 | 7,
+		minArgs:  3,
+		maxArgs:  7,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	// list
 	router["LPUSH"] = &DataBaseCommand{
-		name:    "lpush",
-		proc:    lpushCommandProcess,
-		id:      1<<20 | 1,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "lpush",
+		proc:     lpushCommandProcess,
+		id:       1<<20 | 1,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["LPOP"] = &DataBaseCommand{
-		name:    "lpop",
-		proc:    lpopCommandProcess,
-		id:      1<<20 | 2,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "lpop",
+		proc:     lpopCommandProcess,
+		id:       1<<20 | 2,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
 	}
 	router["RPUSH"] = &DataBaseCommand{
-		name:    "rpush",
-		proc:    rpushCommandProcess,
-		id:      1<<20 | 3,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "rpush",
+		proc:     rpushCommandProcess,
+		id:       1<<20 | 3,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+		denyOom:  true,
 	}
 	router["RPOP"] = &DataBaseCommand{
-		name:    "rpop",
-		proc:    rpopCommandProcess,
-		id:      1<<20 | 4,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "rpop",
+		proc:     rpopCommandProcess,
+		id:       1<<20 | 4,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
 	}
 	router["LLEN"] = &DataBaseCommand{
-		name:    "llen",
-		proc:    llenCommandProcess,
-		id:      1<<20 | 5,
-		minArgs: 2,
-		maxArgs: 2,
+		name:     "llen",
+		proc:     llenCommandProcess,
+		id:       1<<20 | 5,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
 	}
 	// keys
 	router["RENAME"] = &DataBaseCommand{
-		name:    "rename",
-		proc:    renameCommandProcess,
-		id:      1<<21 | 1,
-		minArgs: 3,
-		maxArgs: 3,
+		name:     "rename",
+		proc:     renameCommandProcess,
+		id:       1<<21 | 1,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  2,
+		isWrite:  true,
 	}
 	router["DEL"] = &DataBaseCommand{
-		name:    "del",
-		proc:    delCommandProcess,
-		id:      1<<21 | 2,
+		name:     "del",
+		proc:     delCommandProcess,
+		id:       1<<21 | 2,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+	}
+	router["DUMP"] = &DataBaseCommand{
+		name:     "dump",
+		proc:     dumpCommandProcess,
+		id:       1<<21 | 3,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["RESTORE"] = &DataBaseCommand{
+		name:      "restore",
+		proc:      restoreCommandProcess,
+		id:        1<<21 | 4,
+		minArgs:   4,
+		maxArgs:   6,
+		firstKey:  1,
+		lastKey:   1,
+		isWrite:   true,
+		denyOom:   true,
+		propagate: propagateRestore,
+	}
+	router["EXPIRE"] = &DataBaseCommand{
+		name:      "expire",
+		proc:      expireCommandProcess,
+		id:        1<<21 | 5,
+		minArgs:   3,
+		maxArgs:   5,
+		firstKey:  1,
+		lastKey:   1,
+		isWrite:   true,
+		propagate: propagateExpire(time.Second),
+	}
+	router["PEXPIRE"] = &DataBaseCommand{
+		name:      "pexpire",
+		proc:      pexpireCommandProcess,
+		id:        1<<21 | 6,
+		minArgs:   3,
+		maxArgs:   5,
+		firstKey:  1,
+		lastKey:   1,
+		isWrite:   true,
+		propagate: propagateExpire(time.Millisecond),
+	}
+	router["EXPIREAT"] = &DataBaseCommand{
+		name:      "expireat",
+		proc:      expireatCommandProcess,
+		id:        1<<21 | 7,
+		minArgs:   3,
+		maxArgs:   5,
+		firstKey:  1,
+		lastKey:   1,
+		isWrite:   true,
+		propagate: propagateExpireAt(time.Second),
+	}
+	router["PEXPIREAT"] = &DataBaseCommand{
+		name:     "pexpireat",
+		proc:     pexpireatCommandProcess,
+		id:       1<<21 | 8,
+		minArgs:  3,
+		maxArgs:  5,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+	}
+	router["TTL"] = &DataBaseCommand{
+		name:     "ttl",
+		proc:     ttlCommandProcess,
+		id:       1<<21 | 9,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["PTTL"] = &DataBaseCommand{
+		name:     "pttl",
+		proc:     pttlCommandProcess,
+		id:       1<<21 | 10,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["EXPIRETIME"] = &DataBaseCommand{
+		name:     "expiretime",
+		proc:     expiretimeCommandProcess,
+		id:       1<<21 | 11,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["PEXPIRETIME"] = &DataBaseCommand{
+		name:     "pexpiretime",
+		proc:     pexpiretimeCommandProcess,
+		id:       1<<21 | 12,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["PERSIST"] = &DataBaseCommand{
+		name:     "persist",
+		proc:     persistCommandProcess,
+		id:       1<<21 | 13,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+	}
+	router["OBJECT"] = &DataBaseCommand{
+		name:     "object",
+		proc:     objectCommandProcess,
+		id:       1<<21 | 14,
+		minArgs:  2,
+		maxArgs:  3,
+		firstKey: 2,
+		lastKey:  2,
+	}
+	router["TOUCH"] = &DataBaseCommand{
+		name:     "touch",
+		proc:     touchCommandProcess,
+		id:       1<<21 | 15,
+		minArgs:  2,
+		maxArgs:  math.MaxInt32,
+		firstKey: 1,
+		lastKey:  -1,
+	}
+	router["UNLINK"] = &DataBaseCommand{
+		name:     "unlink",
+		proc:     unlinkCommandProcess,
+		id:       1<<21 | 16,
+		minArgs:  2,
+		maxArgs:  math.MaxInt32,
+		firstKey: 1,
+		lastKey:  -1,
+		isWrite:  true,
+	}
+	router["KEYS"] = &DataBaseCommand{
+		name:    "keys",
+		proc:    keysCommandProcess,
+		id:      1<<21 | 18,
 		minArgs: 2,
 		maxArgs: 2,
 	}
+	router["SCAN"] = &DataBaseCommand{
+		name:    "scan",
+		proc:    scanCommandProcess,
+		id:      1<<21 | 17,
+		minArgs: 2,
+		maxArgs: 8,
+	}
+	router["MOVE"] = &DataBaseCommand{
+		name:     "move",
+		proc:     moveCommandProcess,
+		id:       1<<21 | 19,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  1,
+		isWrite:  true,
+	}
+	router["TYPE"] = &DataBaseCommand{
+		name:     "type",
+		proc:     typeCommandProcess,
+		id:       1<<21 | 20,
+		minArgs:  2,
+		maxArgs:  2,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["EXISTS"] = &DataBaseCommand{
+		name:     "exists",
+		proc:     existsCommandProcess,
+		id:       1<<21 | 21,
+		minArgs:  2,
+		maxArgs:  math.MaxInt32,
+		firstKey: 1,
+		lastKey:  -1,
+	}
+	router["RANDOMKEY"] = &DataBaseCommand{
+		name:    "randomkey",
+		proc:    randomkeyCommandProcess,
+		id:      1<<21 | 22,
+		minArgs: 1,
+		maxArgs: 1,
+	}
+	router["RENAMENX"] = &DataBaseCommand{
+		name:     "renamenx",
+		proc:     renamenxCommandProcess,
+		id:       1<<21 | 23,
+		minArgs:  3,
+		maxArgs:  3,
+		firstKey: 1,
+		lastKey:  2,
+		isWrite:  true,
+	}
+	// SORT is a write command only with STORE, it is not written to AOF otherwise
+	router["SORT"] = &DataBaseCommand{
+		name:      "sort",
+		proc:      sortCommandProcess,
+		id:        1<<21 | 25,
+		minArgs:   2,
+		maxArgs:   math.MaxInt32,
+		isWrite:   true,
+		denyOom:   true,
+		getKeys:   sortKeys,
+		propagate: propagateSort,
+	}
+	router["SORT_RO"] = &DataBaseCommand{
+		name:     "sort_ro",
+		proc:     sortroCommandProcess,
+		id:       1<<21 | 26,
+		minArgs:  2,
+		maxArgs:  math.MaxInt32,
+		firstKey: 1,
+		lastKey:  1,
+	}
+	router["COPY"] = &DataBaseCommand{
+		name:     "copy",
+		proc:     copyCommandProcess,
+		id:       1<<21 | 24,
+		minArgs:  3,
+		maxArgs:  6,
+		firstKey: 1,
+		lastKey:  2,
+		isWrite:  true,
+		denyOom:  true,
+	}
 	// system
 	router["QUIT"] = &DataBaseCommand{
 		name:    "quit",
@@ -253,9 +652,105 @@ func init() {
 		minArgs: 1,
 		maxArgs: 1,
 	}
+	router["SAVE"] = &DataBaseCommand{
+		name:    "save",
+		proc:    saveCommandProcess,
+		id:      2,
+		minArgs: 1,
+		maxArgs: 1,
+	}
+	router["BGSAVE"] = &DataBaseCommand{
+		name:    "bgsave",
+		proc:    bgsaveCommandProcess,
+		id:      3,
+		minArgs: 1,
+		maxArgs: 1,
+	}
+	router["BGREWRITEAOF"] = &DataBaseCommand{
+		name:    "bgrewriteaof",
+		proc:    bgrewriteaofCommandProcess,
+		id:      4,
+		minArgs: 1,
+		maxArgs: 1,
+	}
+	router["LASTSAVE"] = &DataBaseCommand{
+		name:    "lastsave",
+		proc:    lastsaveCommandProcess,
+		id:      5,
+		minArgs: 1,
+		maxArgs: 1,
+	}
+	router["INFO"] = &DataBaseCommand{
+		name:    "info",
+		proc:    infoCommandProcess,
+		id:      6,
+		minArgs: 1,
+		maxArgs: 2,
+	}
+	// imported keys are persisted by the server itself, so IMPORT is not a write command
+	router["IMPORT"] = &DataBaseCommand{
+		name:    "import",
+		proc:    importCommandProcess,
+		id:      7,
+		minArgs: 2,
+		maxArgs: 2,
+	}
+	router["FLUSHDB"] = &DataBaseCommand{
+		name:    "flushdb",
+		proc:    flushdbCommandProcess,
+		id:      10,
+		minArgs: 1,
+		maxArgs: 2,
+		isWrite: true,
+	}
+	router["FLUSHALL"] = &DataBaseCommand{
+		name:    "flushall",
+		proc:    flushallCommandProcess,
+		id:      11,
+		minArgs: 1,
+		maxArgs: 2,
+		isWrite: true,
+	}
+	router["MEMORY"] = &DataBaseCommand{
+		name:    "memory",
+		proc:    memoryCommandProcess,
+		id:      9,
+		minArgs: 2,
+		maxArgs: 5,
+	}
+	router["KEYSTATS"] = &DataBaseCommand{
+		name:    "keystats",
+		proc:    keystatsCommandProcess,
+		id:      12,
+		minArgs: 2,
+		maxArgs: 6,
+	}
+	// the selected database is written to AOF by the server before write commands
+	router["SELECT"] = &DataBaseCommand{
+		name:    "select",
+		proc:    selectCommandProcess,
+		id:      13,
+		minArgs: 2,
+		maxArgs: 2,
+	}
+	router["SWAPDB"] = &DataBaseCommand{
+		name:    "swapdb",
+		proc:    swapdbCommandProcess,
+		id:      14,
+		minArgs: 3,
+		maxArgs: 3,
+		isWrite: true,
+	}
+	router["DBSIZE"] = &DataBaseCommand{
+		name:    "dbsize",
+		proc:    dbsizeCommandProcess,
+		id:      15,
+		minArgs: 1,
+		maxArgs: 1,
+	}
 }
 
-func Handle(args []*DbObject, db *Database) string {
+func Handle(args []*DbObject, db *Database, server Server) string {
 	cmdType := strings.ToUpper(args[0].StrVal())
 	cmd := router[cmdType]
 	if cmd == nil {
@@ -264,13 +759,70 @@ func Handle(args []*DbObject, db *Database) string {
 	if len(args) < int(cmd.minArgs) || len(args) > int(cmd.maxArgs) {
 		return packErrorMessage("Invalid parameter number")
 	}
-	return cmd.proc(args, db)
+	return cmd.proc(args, db, server)
+}
+
+// IsWriteCommand
+// judge whether a command may modify the database
+func IsWriteCommand(name string) bool {
+	cmd := router[strings.ToUpper(name)]
+	return cmd != nil && cmd.isWrite
+}
+
+// IsDenyOomCommand
+// judge whether a command is rejected when maxmemory is reached
+func IsDenyOomCommand(name string) bool {
+	cmd := router[strings.ToUpper(name)]
+	return cmd != nil && cmd.denyOom
+}
+
+// CommandKeys
+// keys in the args of a command
+func CommandKeys(args []*DbObject) []*DbObject {
+	cmd := router[strings.ToUpper(args[0].StrVal())]
+	if cmd != nil && cmd.getKeys != nil {
+		return cmd.getKeys(args)
+	}
+	if cmd == nil || cmd.firstKey == 0 {
+		return nil
+	}
+	lastKey := int(cmd.lastKey)
+	if lastKey < 0 {
+		lastKey += len(args)
+	}
+	if lastKey >= len(args) || lastKey < int(cmd.firstKey) {
+		return nil
+	}
+	return args[cmd.firstKey : lastKey+1]
+}
+
+// AppendOnlyArgs
+// args of a successful write command to be written to AOF, empty if it is not written
+// server is the one the command was handled with
+func AppendOnlyArgs(args []*DbObject, server Server) []*DbObject {
+	cmd := router[strings.ToUpper(args[0].StrVal())]
+	if cmd == nil || cmd.propagate == nil {
+		return args
+	}
+	return cmd.propagate(args, server)
+}
+
+// ErrorReply
+// error reply of msg, used by server before commands are handled
+func ErrorReply(msg string) string {
+	return packErrorMessage(msg)
+}
+
+// IsErrorReply
+// judge whether a reply returned by Handle is an error
+func IsErrorReply(reply string) bool {
+	return strings.HasPrefix(reply, ErrorHead)
 }
 
 // string
 
 // 'get' Process Function
-func getCommandProcess(args []*DbObject, db *Database) string {
+func getCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("illegal request parameter")
@@ -283,38 +835,165 @@ func getCommandProcess(args []*DbObject, db *Database) string {
 	return packString(val.StrVal())
 }
 
+// setOptions
+// options of SET, expireTime is unix nano, -1 if the key never expires
+type setOptions struct {
+	nx, xx, get, keepTtl bool
+	expireTime           int64
+}
+
+// parseSetOptions
+// [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func parseSetOptions(args []*DbObject, server Server) (*setOptions, string) {
+	options := &setOptions{expireTime: -1}
+	hasExpire := false
+	for i := 0; i < len(args); i += 1 {
+		option := strings.ToUpper(args[i].StrVal())
+		switch option {
+		case "NX", "XX":
+			if options.nx || options.xx {
+				return nil, "Syntax error"
+			}
+			options.nx = option == "NX"
+			options.xx = option == "XX"
+		case "GET":
+			options.get = true
+		case "KEEPTTL":
+			if hasExpire {
+				return nil, "Syntax error"
+			}
+			hasExpire = true
+			options.keepTtl = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || i+1 >= len(args) {
+				return nil, "Syntax error"
+			}
+			hasExpire = true
+			i += 1
+			when, err := args[i].IntVal()
+			if err != nil {
+				return nil, "value is not an integer or out of range"
+			}
+			unit := time.Second
+			if option[0] == 'P' {
+				unit = time.Millisecond
+			}
+			expireTime, ok := toExpireTime(server, when, unit, strings.HasSuffix(option, "AT"))
+			if when <= 0 || !ok {
+				return nil, "invalid expire time in 'set' command"
+			}
+			options.expireTime = expireTime
+		default:
+			return nil, "Syntax error"
+		}
+	}
+	return options, ""
+}
+
 // 'set' Process Function
-func setCommandProcess(args []*DbObject, db *Database) string {
+// set key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+// reply the old value (nil if not exists) with GET, the key is not set if NX or XX is not met
+func setCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	value := args[2]
 	if !checkString(key) || !checkString(value) {
 		return packErrorMessage("illegal request parameter")
 	}
-	if err := db.SetStr(key, value, DefaultExpireTime+getTime()); err != nil {
+	options, msg := parseSetOptions(args[3:], server)
+	if options == nil {
+		return packErrorMessage(msg)
+	}
+	old, err := db.GetKey(key)
+	if err != nil && !errors.Is(err, ErrorKeyNotExist) {
 		return packErrorMessage(err.Error())
 	}
+	// SET overwrites a key of any type, only the old value returned by GET should be a string
+	if options.get && old != nil && old.Type != STR {
+		return packErrorMessage("Illegal key type")
+	}
+	reply := func(def string) string {
+		if !options.get {
+			return def
+		}
+		if old == nil {
+			return packNil()
+		}
+		return packBulkString(old.StrVal())
+	}
+	if (options.nx && old != nil) || (options.xx && old == nil) {
+		// not set, nil reply without GET
+		return reply(packNil())
+	}
+	expireTime := options.expireTime
+	if options.keepTtl {
+		expireTime = db.GetExpireTime(key)
+	}
+	if old != nil && old.Type != STR {
+		if err := db.RemoveKey(key); err != nil {
+			return packErrorMessage(err.Error())
+		}
+	}
+	if err := db.SetStr(key, value, expireTime); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	server.IncrDirty(1)
 	log.Printf("[SET COMMAND]Success\n")
-	return packString("Query OK")
+	return reply(packString("Query OK"))
+}
+
+// propagateSet
+// EX and PX of SET are written to AOF as PXAT
+func propagateSet(args []*DbObject, server Server) []*DbObject {
+	result := make([]*DbObject, 0, len(args))
+	for i := 0; i < len(args); i += 1 {
+		option := strings.ToUpper(args[i].StrVal())
+		if i >= 3 && (option == "EX" || option == "PX") && i+1 < len(args) {
+			when, _ := args[i+1].IntVal()
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			expireTime, _ := toExpireTime(server, when, unit, false)
+			result = append(result, NewStr("PXAT"), NewObjectByInt(expireTime/int64(time.Millisecond)))
+			i += 1
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return result
 }
 
 // 'setex' Process Function
-func setexCommandProcess(args []*DbObject, db *Database) string {
+// setex key seconds value
+func setexCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	expire, err := args[2].IntVal()
 	value := args[3]
 	if !checkString(key) || !checkString(value) || err != nil {
 		return packErrorMessage("illegal request parameter")
 	}
-	expire = expire * 1000000000 // to nano seconds
-	if err := db.SetStr(key, value, expire); err != nil {
+	expireTime, ok := toExpireTime(server, expire, time.Second, false)
+	if expire <= 0 || !ok {
+		return packErrorMessage("invalid expire time in 'setex' command")
+	}
+	if err := db.SetStr(key, value, expireTime); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[SETEX COMMAND]Success\n")
 	return packString("Query OK")
 }
 
+// propagateSetex
+// SETEX is written to AOF as SET with PXAT
+func propagateSetex(args []*DbObject, server Server) []*DbObject {
+	expire, _ := args[2].IntVal()
+	expireTime, _ := toExpireTime(server, expire, time.Second, false)
+	return []*DbObject{NewStr("SET"), args[1], args[3], NewStr("PXAT"), NewObjectByInt(expireTime / int64(time.Millisecond))}
+}
+
 // 'setnx' Process Function
-func setnxCommandProcess(args []*DbObject, db *Database) string {
+func setnxCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	value := args[2]
 	if !checkString(key) || !checkString(value) {
@@ -323,15 +1002,16 @@ func setnxCommandProcess(args []*DbObject, db *Database) string {
 	if val, _ := db.GetStr(key); val != nil {
 		return packString("Key already exist")
 	}
-	if err := db.SetStr(key, value, DefaultExpireTime+getTime()); err != nil {
+	if err := db.SetStr(key, value, -1); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[SETNX COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'incrby' Process Function
-func incrbyCommandProcess(args []*DbObject, db *Database) string {
+func incrbyCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	increment, err := args[2].IntVal()
 	if !checkString(key) || err != nil {
@@ -340,12 +1020,13 @@ func incrbyCommandProcess(args []*DbObject, db *Database) string {
 	if err := db.Increment(key, increment); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[INCRBY COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'incr' Process Function
-func incrCommandProcess(args []*DbObject, db *Database) string {
+func incrCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("illegal request parameter")
@@ -353,12 +1034,13 @@ func incrCommandProcess(args []*DbObject, db *Database) string {
 	if err := db.Incr(key); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[INCR COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'decr' Process Function
-func decrCommandProcess(args []*DbObject, db *Database) string {
+func decrCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("illegal request parameter")
@@ -366,6 +1048,7 @@ func decrCommandProcess(args []*DbObject, db *Database) string {
 	if err := db.Decr(key); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[DECR COMMAND]Success\n")
 	return packString("Query OK")
 }
@@ -373,7 +1056,7 @@ func decrCommandProcess(args []*DbObject, db *Database) string {
 // zset
 
 // 'zadd' Process Function
-func zaddCommandProcess(args []*DbObject, db *Database) string {
+func zaddCommandProcess(args []*DbObject, db *Database, server Server) string {
 	// judge parameter
 	key := args[1]
 	score, err := args[2].IntVal()
@@ -398,12 +1081,13 @@ func zaddCommandProcess(args []*DbObject, db *Database) string {
 	} else {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[ZADD COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'zrange' Process Function
-func zrangeCommandProcess(args []*DbObject, db *Database) string {
+func zrangeCommandProcess(args []*DbObject, db *Database, server Server) string {
 	// judge parameter
 	key := args[1]
 	left, err1 := args[2].IntVal()
@@ -427,7 +1111,7 @@ func zrangeCommandProcess(args []*DbObject, db *Database) string {
 }
 
 // 'zrem' Process Function
-func zremCommandProcess(args []*DbObject, db *Database) string {
+func zremCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	member := args[2]
 	if !checkString(member) || !checkString(key) {
@@ -441,12 +1125,13 @@ func zremCommandProcess(args []*DbObject, db *Database) string {
 	if err = zset.Remove(args[2]); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[ZREM COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'zincreby' Process Function
-func zincrebyCommandProcess(args []*DbObject, db *Database) string {
+func zincrebyCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	incr, err := args[2].IntVal()
 	member := args[3]
@@ -461,12 +1146,13 @@ func zincrebyCommandProcess(args []*DbObject, db *Database) string {
 	if err = zset.Incr(member, incr); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[ZINCREBY COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'zscore' Process Function
-func zscoreCommandProcess(args []*DbObject, db *Database) string {
+func zscoreCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	member := args[2]
 	if !checkString(key) || !checkString(member) {
@@ -488,7 +1174,7 @@ func zscoreCommandProcess(args []*DbObject, db *Database) string {
 // hash
 
 // 'hset' Process Function
-func hsetCommandProcess(args []*DbObject, db *Database) string {
+func hsetCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	field := args[2]
 	value := args[3]
@@ -503,12 +1189,13 @@ func hsetCommandProcess(args []*DbObject, db *Database) string {
 	if err = hash.Set(field, value); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[HSET COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'hget' Process Function
-func hgetCommandProcess(args []*DbObject, db *Database) string {
+func hgetCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	field := args[2]
 	if !checkString(key) || !checkString(field) {
@@ -528,7 +1215,7 @@ func hgetCommandProcess(args []*DbObject, db *Database) string {
 }
 
 // 'hdel' Process Function
-func hdelCommandProcess(args []*DbObject, db *Database) string {
+func hdelCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	field := args[2]
 	if !checkString(key) || !checkString(field) {
@@ -542,6 +1229,7 @@ func hdelCommandProcess(args []*DbObject, db *Database) string {
 	if err = hash.Delete(field); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[HDEL COMMAND]Success\n")
 	return packString("Query OK")
 }
@@ -549,7 +1237,7 @@ func hdelCommandProcess(args []*DbObject, db *Database) string {
 // set
 
 // 'sadd' Process Function
-func saddCommandProcess(args []*DbObject, db *Database) string {
+func saddCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	member := args[2]
 	if !checkString(key) || !checkString(member) {
@@ -563,12 +1251,13 @@ func saddCommandProcess(args []*DbObject, db *Database) string {
 	if err = set.Add(member); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[SADD COMMAND]Success\n")
 	return packString("Query OK")
 }
 
 // 'smembers' Process Function
-func smembersCommandProcess(args []*DbObject, db *Database) string {
+func smembersCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("Illegal request parameter")
@@ -588,7 +1277,7 @@ func smembersCommandProcess(args []*DbObject, db *Database) string {
 }
 
 // 'scard' Process Function
-func scardCommandProcess(args []*DbObject, db *Database) string {
+func scardCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("Illegal request parameter")
@@ -604,7 +1293,7 @@ func scardCommandProcess(args []*DbObject, db *Database) string {
 }
 
 // 'srem' Process Function
-func sremCommandProcess(args []*DbObject, db *Database) string {
+func sremCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	member := args[2]
 	if !checkString(key) || !checkString(member) {
@@ -618,12 +1307,13 @@ func sremCommandProcess(args []*DbObject, db *Database) string {
 	if err = set.Remove(member); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[SREM COMMAND]Success\n")
 	return packBulkString("Query OK")
 }
 
 // 'sinter' Process Function
-func sinterCommandProcess(args []*DbObject, db *Database) string {
+func sinterCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key1 := args[1]
 	key2 := args[2]
 	if !checkString(key1) || !checkString(key2) {
@@ -649,7 +1339,7 @@ func sinterCommandProcess(args []*DbObject, db *Database) string {
 }
 
 // 'sunion' Process Function
-func sunionCommandProcess(args []*DbObject, db *Database) string {
+func sunionCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key1 := args[1]
 	key2 := args[2]
 	if !checkString(key1) || !checkString(key2) {
@@ -676,7 +1366,7 @@ func sunionCommandProcess(args []*DbObject, db *Database) string {
 
 // list
 
-func lpushCommandProcess(args []*DbObject, db *Database) string {
+func lpushCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	value := args[2]
 	if !checkString(key) || !checkString(value) {
@@ -688,11 +1378,12 @@ func lpushCommandProcess(args []*DbObject, db *Database) string {
 	}
 	list := obj.Val.(*LinkedList)
 	list.Lpush(value)
+	server.IncrDirty(1)
 	log.Printf("[LPUSH COMMAND]Success\n")
 	return packString("Query OK")
 }
 
-func lpopCommandProcess(args []*DbObject, db *Database) string {
+func lpopCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("Illegal request parameter")
@@ -705,11 +1396,12 @@ func lpopCommandProcess(args []*DbObject, db *Database) string {
 	if len := list.Len(); len == 0 {
 		return packErrorMessage("List is empty")
 	}
+	server.IncrDirty(1)
 	log.Printf("[LPOP COMMAND]Success\n")
 	return packString(list.Lpop().StrVal())
 }
 
-func rpushCommandProcess(args []*DbObject, db *Database) string {
+func rpushCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	value := args[2]
 	if !checkString(key) || !checkString(value) {
@@ -721,11 +1413,12 @@ func rpushCommandProcess(args []*DbObject, db *Database) string {
 	}
 	list := obj.Val.(*LinkedList)
 	list.Rpush(value)
+	server.IncrDirty(1)
 	log.Printf("[RPUSH COMMAND]Success\n")
 	return packString("Query OK")
 }
 
-func rpopCommandProcess(args []*DbObject, db *Database) string {
+func rpopCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("Illegal request parameter")
@@ -738,11 +1431,12 @@ func rpopCommandProcess(args []*DbObject, db *Database) string {
 	if len := list.Len(); len == 0 {
 		return packErrorMessage("List is empty")
 	}
+	server.IncrDirty(1)
 	log.Printf("[RPOP COMMAND]Success\n")
 	return packString(list.Rpop().StrVal())
 }
 
-func llenCommandProcess(args []*DbObject, db *Database) string {
+func llenCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("Illegal request parameter")
@@ -757,7 +1451,7 @@ func llenCommandProcess(args []*DbObject, db *Database) string {
 
 // system
 
-func delCommandProcess(args []*DbObject, db *Database) string {
+func delCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	if !checkString(key) {
 		return packErrorMessage("Illegal request parameter")
@@ -765,11 +1459,109 @@ func delCommandProcess(args []*DbObject, db *Database) string {
 	if err := db.RemoveKey(key); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[DEL COMMAND]Success\n")
 	return packString("Query OK")
 }
 
-func renameCommandProcess(args []*DbObject, db *Database) string {
+// dump key
+// serialised value of key, nil bulk string if the key does not exist
+func dumpCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	val, err := db.GetKey(key)
+	if err != nil {
+		// a key not exists is a nil bulk string, not an error
+		return packNil()
+	}
+	payload, err := persistence.DumpValue(val)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	log.Printf("[DUMP COMMAND]Success\n")
+	return packBulkString(string(payload))
+}
+
+// restore key ttl payload [REPLACE] [ABSTTL]
+// ttl (ms) 0 means the key never expires, with ABSTTL ttl is a unix time (ms)
+func restoreCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	ttl, err := args[2].IntVal()
+	if err != nil || ttl < 0 {
+		return packErrorMessage("Invalid TTL value, must be >= 0")
+	}
+	replace, absTtl := false, false
+	for _, arg := range args[4:] {
+		switch strings.ToUpper(arg.StrVal()) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTtl = true
+		default:
+			return packErrorMessage("Syntax error")
+		}
+	}
+	var expireTime int64 = -1
+	if ttl > 0 {
+		var ok bool
+		if expireTime, ok = toExpireTime(server, ttl, time.Millisecond, absTtl); !ok {
+			return packErrorMessage("invalid expire time in 'restore' command")
+		}
+	}
+	exist, _ := db.Exist(key)
+	if exist && !replace {
+		return packErrorMessage("Target key name is busy")
+	}
+	val, err := persistence.RestoreValue([]byte(args[3].StrVal()))
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	if exist {
+		// the old value is freed like DEL
+		db.RemoveKey(key)
+	}
+	if expireTime >= 0 && expireTime <= getTime() {
+		// already expired, the key is only removed
+		if exist {
+			server.IncrDirty(1)
+		}
+		log.Printf("[RESTORE COMMAND]Success\n")
+		return packString("Query OK")
+	}
+	if err = db.SetKeyObject(key, val, expireTime); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	server.IncrDirty(1)
+	log.Printf("[RESTORE COMMAND]Success\n")
+	return packString("Query OK")
+}
+
+// propagateRestore
+// a relative ttl is written to AOF as an absolute unix time (ms) with ABSTTL
+func propagateRestore(args []*DbObject, server Server) []*DbObject {
+	ttl, _ := args[2].IntVal()
+	if ttl <= 0 {
+		return args
+	}
+	for _, arg := range args[4:] {
+		if strings.ToUpper(arg.StrVal()) == "ABSTTL" {
+			return args
+		}
+	}
+	expireTime, _ := toExpireTime(server, ttl, time.Millisecond, false)
+	result := make([]*DbObject, 0, len(args)+1)
+	result = append(result, args[:2]...)
+	result = append(result, NewObjectByInt(expireTime/int64(time.Millisecond)))
+	result = append(result, args[3:]...)
+	return append(result, NewStr("ABSTTL"))
+}
+
+func renameCommandProcess(args []*DbObject, db *Database, server Server) string {
 	key := args[1]
 	newKey := args[2]
 	if !checkString(key) || !checkString(newKey) {
@@ -778,16 +1570,965 @@ func renameCommandProcess(args []*DbObject, db *Database) string {
 	if err := db.RenameKey(key, newKey); err != nil {
 		return packErrorMessage(err.Error())
 	}
+	server.IncrDirty(1)
 	log.Printf("[RENAME COMMAND]Success\n")
 	return packString("Query OK")
 }
 
-func quitCommandProcess(args []*DbObject, db *Database) string {
+// expire key seconds [NX | XX | GT | LT]
+func expireCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return expireGeneric(args, db, server, time.Second, false)
+}
+
+// pexpire key milliseconds [NX | XX | GT | LT]
+func pexpireCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return expireGeneric(args, db, server, time.Millisecond, false)
+}
+
+// expireat key unix-time-seconds [NX | XX | GT | LT]
+func expireatCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return expireGeneric(args, db, server, time.Second, true)
+}
+
+// pexpireat key unix-time-milliseconds [NX | XX | GT | LT]
+func pexpireatCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return expireGeneric(args, db, server, time.Millisecond, true)
+}
+
+// expireGeneric
+// set the expire time of a key, args[2] is a duration or a unix time (absolute) in unit
+// reply 1 if the expire time is set (or the key is deleted because the time is in the past),
+// 0 if the key does not exist or the condition is not met
+func expireGeneric(args []*DbObject, db *Database, server Server, unit time.Duration, absolute bool) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	when, err := args[2].IntVal()
+	if err != nil {
+		return packErrorMessage("value is not an integer or out of range")
+	}
+	nx, xx, gt, lt := false, false, false, false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg.StrVal()) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return packErrorMessage("Unsupported option " + arg.StrVal())
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return packErrorMessage("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return packErrorMessage("GT and LT options at the same time are not compatible")
+	}
+	expireTime, ok := toExpireTime(server, when, unit, absolute)
+	if !ok {
+		return packErrorMessage("invalid expire time in '" + strings.ToLower(args[0].StrVal()) + "' command")
+	}
+	// access info is not updated, like OBJECT
+	if db.PeekAlive(key) == nil {
+		return packInt(0)
+	}
+	// -1 if the key never expires, which is treated as an infinite ttl by GT and LT
+	current := db.GetExpireTime(key)
+	if (nx && current >= 0) || (xx && current < 0) ||
+		(gt && (current < 0 || expireTime <= current)) ||
+		(lt && current >= 0 && expireTime >= current) {
+		return packInt(0)
+	}
+	if expireTime <= getTime() {
+		// already expired
+		if err = db.RemoveKey(key); err != nil {
+			return packErrorMessage(err.Error())
+		}
+	} else if err = db.SetExpire(key, expireTime); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	server.IncrDirty(1)
+	log.Printf("[%s COMMAND]Success\n", strings.ToUpper(args[0].StrVal()))
+	return packInt(1)
+}
+
+// toExpireTime
+// convert a duration (relative to server.CommandTime()) or unix time in unit to unix nano,
+// false if overflows
+func toExpireTime(server Server, when int64, unit time.Duration, absolute bool) (int64, bool) {
+	if when > math.MaxInt64/int64(unit) || when < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	expireTime := when * int64(unit)
+	if !absolute {
+		current := server.CommandTime()
+		if expireTime > math.MaxInt64-current {
+			return 0, false
+		}
+		expireTime += current
+	}
+	return expireTime, true
+}
+
+// propagateExpire
+// EXPIRE / PEXPIRE are written to AOF as PEXPIREAT
+func propagateExpire(unit time.Duration) func(args []*DbObject, server Server) []*DbObject {
+	return func(args []*DbObject, server Server) []*DbObject {
+		when, _ := args[2].IntVal()
+		expireTime, _ := toExpireTime(server, when, unit, false)
+		return toPexpireat(args, expireTime)
+	}
+}
+
+// propagateExpireAt
+// EXPIREAT is written to AOF as PEXPIREAT
+func propagateExpireAt(unit time.Duration) func(args []*DbObject, server Server) []*DbObject {
+	return func(args []*DbObject, server Server) []*DbObject {
+		when, _ := args[2].IntVal()
+		return toPexpireat(args, when*int64(unit))
+	}
+}
+
+// toPexpireat
+// PEXPIREAT key unix-time-milliseconds with options of args
+func toPexpireat(args []*DbObject, expireTime int64) []*DbObject {
+	result := []*DbObject{NewStr("PEXPIREAT"), args[1], NewObjectByInt(expireTime / int64(time.Millisecond))}
+	return append(result, args[3:]...)
+}
+
+// ttl key
+func ttlCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return ttlGeneric(args, db, time.Second, false)
+}
+
+// pttl key
+func pttlCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return ttlGeneric(args, db, time.Millisecond, false)
+}
+
+// expiretime key
+func expiretimeCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return ttlGeneric(args, db, time.Second, true)
+}
+
+// pexpiretime key
+func pexpiretimeCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return ttlGeneric(args, db, time.Millisecond, true)
+}
+
+// ttlGeneric
+// reply the remaining time (or unix time if absolute) of a key in unit,
+// -2 if the key does not exist, -1 if the key never expires
+func ttlGeneric(args []*DbObject, db *Database, unit time.Duration, absolute bool) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	// polling ttl does not keep the key hot
+	if db.PeekAlive(key) == nil {
+		return packInt(-2)
+	}
+	expireTime := db.GetExpireTime(key)
+	if expireTime < 0 {
+		return packInt(-1)
+	}
+	if absolute {
+		return packInt(int(expireTime / int64(unit)))
+	}
+	ttl := expireTime - getTime()
+	if ttl < 0 {
+		ttl = 0
+	}
+	// ms precision, rounded to unit
+	ttl /= int64(time.Millisecond)
+	perUnit := int64(unit / time.Millisecond)
+	return packInt(int((ttl + perUnit/2) / perUnit))
+}
+
+// persist key
+func persistCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	if !db.Persist(key) {
+		return packInt(0)
+	}
+	server.IncrDirty(1)
+	log.Printf("[PERSIST COMMAND]Success\n")
+	return packInt(1)
+}
+
+var objectHelp []string = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recorded access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// OBJECT ENCODING|FREQ|IDLETIME|REFCOUNT key | OBJECT HELP
+// access info of the key is not updated
+func objectCommandProcess(args []*DbObject, db *Database, server Server) string {
+	subcommand := strings.ToUpper(args[1].StrVal())
+	if subcommand == "HELP" {
+		if len(args) != 2 {
+			return packErrorMessage("Invalid parameter number")
+		}
+		return packBulkArray(objectHelp)
+	}
+	if len(args) != 3 {
+		return packErrorMessage("Invalid parameter number")
+	}
+	key := args[2]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	val := db.PeekAlive(key)
+	switch subcommand {
+	case "ENCODING":
+		if val == nil {
+			return packNil()
+		}
+		return packBulkString(Encoding(val))
+	case "IDLETIME":
+		if val == nil {
+			return packNil()
+		}
+		if db.UsesLfu() {
+			return packErrorMessage("An LFU maxmemory policy is selected, idle time not tracked")
+		}
+		return packInt(int(IdleTime(val) / 1000))
+	case "FREQ":
+		if val == nil {
+			return packNil()
+		}
+		if !db.UsesLfu() {
+			return packErrorMessage("An LFU maxmemory policy is not selected, access frequency not tracked")
+		}
+		return packInt(int(Frequency(val)))
+	case "REFCOUNT":
+		if val == nil {
+			return packNil()
+		}
+		// values are never shared between keys
+		return packInt(1)
+	}
+	return packErrorMessage("Unknown subcommand " + args[1].StrVal())
+}
+
+// TOUCH key [key ...]
+// update access info of keys, return the number of keys exist
+func touchCommandProcess(args []*DbObject, db *Database, server Server) string {
+	touched := 0
+	for _, key := range args[1:] {
+		if !checkString(key) {
+			return packErrorMessage("Illegal request parameter")
+		}
+		if _, err := db.GetKey(key); err == nil {
+			touched += 1
+		}
+	}
+	log.Printf("[TOUCH COMMAND]Success\n")
+	return packInt(touched)
+}
+
+// UNLINK key [key ...]
+// keys are removed in O(1), large values are freed in background
+func unlinkCommandProcess(args []*DbObject, db *Database, server Server) string {
+	removed := 0
+	for _, key := range args[1:] {
+		if !checkString(key) {
+			return packErrorMessage("Illegal request parameter")
+		}
+		if err := db.UnlinkKey(key); err == nil {
+			removed += 1
+		}
+	}
+	server.IncrDirty(int64(removed))
+	log.Printf("[UNLINK COMMAND]Success\n")
+	return packInt(removed)
+}
+
+// MOVE key db
+// move key to database db, return 0 if key does not exist or db already has it
+func moveCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	index, msg := parseDbIndex(args[2], server)
+	if msg != "" {
+		return msg
+	}
+	target := server.Databases()[index]
+	if target == db {
+		return packErrorMessage("Source and destination objects are the same")
+	}
+	// the key in source database is notified by the server as the key of MOVE
+	server.BeforeWriteKey(index, key)
+	moved, err := db.MoveKey(key, target)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	if !moved {
+		return packInt(0)
+	}
+	server.IncrDirty(1)
+	log.Printf("[MOVE COMMAND]Success\n")
+	return packInt(1)
+}
+
+// TYPE key
+// the type of value stored at key, none if key does not exist
+func typeCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	val, err := db.GetKey(key)
+	if err != nil {
+		return packString("none")
+	}
+	return packString(persistence.TypeName(val.Type))
+}
+
+// EXISTS key [key ...]
+// return the number of keys exist, a key given more than once is counted more than once
+func existsCommandProcess(args []*DbObject, db *Database, server Server) string {
+	count := 0
+	for _, key := range args[1:] {
+		if !checkString(key) {
+			return packErrorMessage("Illegal request parameter")
+		}
+		if ext, _ := db.Exist(key); ext {
+			count += 1
+		}
+	}
+	log.Printf("[EXISTS COMMAND]Success\n")
+	return packInt(count)
+}
+
+// RANDOMKEY
+func randomkeyCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key := db.RandomKey()
+	if key == nil {
+		return packNil()
+	}
+	return packBulkString(key.StrVal())
+}
+
+// RENAMENX key newkey
+// rename key only if newkey does not exist, return 0 if newkey exists
+func renamenxCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key, newKey := args[1], args[2]
+	if !checkString(key) || !checkString(newKey) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	renamed, err := db.RenameKeyIfNotExist(key, newKey)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	if !renamed {
+		return packInt(0)
+	}
+	server.IncrDirty(1)
+	log.Printf("[RENAMENX COMMAND]Success\n")
+	return packInt(1)
+}
+
+// COPY source destination [DB db] [REPLACE]
+// copy the value and expire time of source, return 0 if source does not exist or destination
+// exists without REPLACE
+func copyCommandProcess(args []*DbObject, db *Database, server Server) string {
+	key, newKey := args[1], args[2]
+	if !checkString(key) || !checkString(newKey) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	target, index, replace := db, -1, false
+	for i := 3; i < len(args); i += 1 {
+		option := strings.ToUpper(args[i].StrVal())
+		switch {
+		case option == "REPLACE":
+			replace = true
+		case option == "DB" && i+1 < len(args):
+			var msg string
+			if index, msg = parseDbIndex(args[i+1], server); msg != "" {
+				return msg
+			}
+			target = server.Databases()[index]
+			i += 1
+		default:
+			return packErrorMessage("Syntax error")
+		}
+	}
+	if target == db && key.StrVal() == newKey.StrVal() {
+		return packErrorMessage("Source and destination objects are the same")
+	}
+	if index >= 0 && target != db {
+		// keys of the selected database are notified by the server as the keys of COPY
+		server.BeforeWriteKey(index, newKey)
+	}
+	copied, err := db.CopyKey(key, target, newKey, replace)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	if !copied {
+		return packInt(0)
+	}
+	server.IncrDirty(1)
+	log.Printf("[COPY COMMAND]Success\n")
+	return packInt(1)
+}
+
+// SORT key [BY pattern] [LIMIT offset count] [GET pattern [GET pattern ...]] [ASC|DESC] [ALPHA]
+// [STORE destination]
+// return the sorted elements (or values of GET patterns), or the length of the list stored
+func sortCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return sortGeneric(args, db, server, false)
+}
+
+// SORT_RO key [BY pattern] [LIMIT offset count] [GET pattern [GET pattern ...]] [ASC|DESC] [ALPHA]
+// read-only variant of SORT, STORE is not allowed
+func sortroCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return sortGeneric(args, db, server, true)
+}
+
+func sortGeneric(args []*DbObject, db *Database, server Server, readOnly bool) string {
+	key := args[1]
+	if !checkString(key) {
+		return packErrorMessage("Illegal request parameter")
+	}
+	options, store, msg := parseSortOptions(args)
+	if msg != "" {
+		return msg
+	}
+	if store != nil && readOnly {
+		return packErrorMessage("Syntax error")
+	}
+	result, err := db.Sort(key, options)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	if store == nil {
+		log.Printf("[SORT COMMAND]Success\n")
+		return packNullableArray(result)
+	}
+	// the destination is replaced, or deleted if the result is empty
+	db.RemoveKey(store)
+	if len(result) > 0 {
+		list := NewLinkedList()
+		for _, value := range result {
+			if value == nil {
+				value = NewStr("")
+			}
+			list.Rpush(NewStr(value.StrVal()))
+		}
+		if err = db.SetKeyObject(store, NewObject(LINKDLIST, list), -1); err != nil {
+			return packErrorMessage(err.Error())
+		}
+	}
+	server.IncrDirty(1)
+	log.Printf("[SORT COMMAND]Success\n")
+	return packInt(len(result))
+}
+
+// parseSortOptions
+// options of SORT and SORT_RO, return the destination of STORE (nil if not given)
+func parseSortOptions(args []*DbObject) (*SortOptions, *DbObject, string) {
+	options := &SortOptions{Count: -1}
+	var store *DbObject
+	for i := 2; i < len(args); i += 1 {
+		option := strings.ToUpper(args[i].StrVal())
+		switch {
+		case option == "ASC":
+			options.Desc = false
+		case option == "DESC":
+			options.Desc = true
+		case option == "ALPHA":
+			options.Alpha = true
+		case option == "BY" && i+1 < len(args):
+			options.By = args[i+1].StrVal()
+			i += 1
+		case option == "GET" && i+1 < len(args):
+			options.Gets = append(options.Gets, args[i+1].StrVal())
+			i += 1
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err := strconv.Atoi(args[i+1].StrVal())
+			if err != nil {
+				return nil, nil, packErrorMessage("Illegal request parameter")
+			}
+			count, err := strconv.Atoi(args[i+2].StrVal())
+			if err != nil {
+				return nil, nil, packErrorMessage("Illegal request parameter")
+			}
+			options.Offset, options.Count = offset, count
+			i += 2
+		case option == "STORE" && i+1 < len(args):
+			store = args[i+1]
+			if !checkString(store) {
+				return nil, nil, packErrorMessage("Illegal request parameter")
+			}
+			i += 1
+		default:
+			return nil, nil, packErrorMessage("Syntax error")
+		}
+	}
+	return options, store, ""
+}
+
+// sortStore
+// the destination of STORE, nil if not given
+func sortStore(args []*DbObject) *DbObject {
+	_, store, msg := parseSortOptions(args)
+	if msg != "" {
+		return nil
+	}
+	return store
+}
+
+// sortKeys
+// the sorted key and the destination of STORE
+func sortKeys(args []*DbObject) []*DbObject {
+	if store := sortStore(args); store != nil {
+		return []*DbObject{args[1], store}
+	}
+	return args[1:2]
+}
+
+// propagateSort
+// SORT without STORE changes nothing
+func propagateSort(args []*DbObject, server Server) []*DbObject {
+	if sortStore(args) == nil {
+		return nil
+	}
+	return args
+}
+
+// parseDbIndex
+// parse a db index, return an error reply if it is not a valid index
+func parseDbIndex(arg *DbObject, server Server) (int, string) {
+	index, err := strconv.Atoi(arg.StrVal())
+	if err != nil {
+		return 0, packErrorMessage("Invalid DB index")
+	}
+	if index < 0 || index >= len(server.Databases()) {
+		return 0, packErrorMessage("DB index is out of range")
+	}
+	return index, ""
+}
+
+func quitCommandProcess(args []*DbObject, db *Database, server Server) string {
 	return util.ERROR_QUIT
 }
 
+func saveCommandProcess(args []*DbObject, db *Database, server Server) string {
+	if err := server.Save(); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	log.Printf("[SAVE COMMAND]Success\n")
+	return packString("Query OK")
+}
+
+func bgsaveCommandProcess(args []*DbObject, db *Database, server Server) string {
+	if err := server.BgSave(); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	log.Printf("[BGSAVE COMMAND]Success\n")
+	return packString("Background saving started")
+}
+
+func bgrewriteaofCommandProcess(args []*DbObject, db *Database, server Server) string {
+	if err := server.BgRewriteAof(); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	log.Printf("[BGREWRITEAOF COMMAND]Success\n")
+	return packString("Background append only file rewriting started")
+}
+
+func lastsaveCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return packInt(int(server.LastSave()))
+}
+
+func infoCommandProcess(args []*DbObject, db *Database, server Server) string {
+	section := ""
+	if len(args) > 1 {
+		section = strings.ToLower(args[1].StrVal())
+	}
+	return packBulkString(server.Info(section))
+}
+
+func importCommandProcess(args []*DbObject, db *Database, server Server) string {
+	keys, err := server.Import(args[1].StrVal())
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	log.Printf("[IMPORT COMMAND]Success\n")
+	return packInt(int(keys))
+}
+
+// parseFlushOption
+// ASYNC or SYNC of FLUSHDB and FLUSHALL, LazyUserFlush decides if not given
+func parseFlushOption(args []*DbObject, db *Database) (bool, error) {
+	if len(args) == 1 {
+		return db.Lazyfree().Options().LazyUserFlush, nil
+	}
+	switch strings.ToUpper(args[1].StrVal()) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	}
+	return false, errors.New("Syntax error")
+}
+
+// FLUSHDB [ASYNC|SYNC]
+func flushdbCommandProcess(args []*DbObject, db *Database, server Server) string {
+	async, err := parseFlushOption(args, db)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	server.IncrDirty(db.Flush(async))
+	log.Printf("[FLUSHDB COMMAND]Success\n")
+	return packString("Query OK")
+}
+
+// FLUSHALL [ASYNC|SYNC]
+func flushallCommandProcess(args []*DbObject, db *Database, server Server) string {
+	async, err := parseFlushOption(args, db)
+	if err != nil {
+		return packErrorMessage(err.Error())
+	}
+	for _, d := range server.Databases() {
+		server.IncrDirty(d.Flush(async))
+	}
+	log.Printf("[FLUSHALL COMMAND]Success\n")
+	return packString("Query OK")
+}
+
+// SELECT index
+func selectCommandProcess(args []*DbObject, db *Database, server Server) string {
+	index, err := strconv.Atoi(args[1].StrVal())
+	if err != nil {
+		return packErrorMessage("Invalid DB index")
+	}
+	if err = server.SelectDb(index); err != nil {
+		return packErrorMessage(err.Error())
+	}
+	log.Printf("[SELECT COMMAND]Success\n")
+	return packString("Query OK")
+}
+
+// SWAPDB index1 index2
+// clients selecting one of the databases see the keys of the other one immediately
+func swapdbCommandProcess(args []*DbObject, db *Database, server Server) string {
+	first, msg := parseDbIndex(args[1], server)
+	if msg != "" {
+		return msg
+	}
+	second, msg := parseDbIndex(args[2], server)
+	if msg != "" {
+		return msg
+	}
+	if first != second {
+		dbs := server.Databases()
+		dbs[first].Swap(dbs[second])
+	}
+	server.IncrDirty(1)
+	log.Printf("[SWAPDB COMMAND]Success\n")
+	return packString("Query OK")
+}
+
+// DBSIZE
+// the number of keys in the selected database
+func dbsizeCommandProcess(args []*DbObject, db *Database, server Server) string {
+	return packInt(int(db.Size()))
+}
+
+// MEMORY USAGE key [SAMPLES count] | MEMORY STATS
+func memoryCommandProcess(args []*DbObject, db *Database, server Server) string {
+	switch strings.ToUpper(args[1].StrVal()) {
+	case "USAGE":
+		if len(args) != 3 && len(args) != 5 {
+			return packErrorMessage("Invalid parameter number")
+		}
+		samples := DefaultSizeSamples
+		if len(args) == 5 {
+			if strings.ToUpper(args[3].StrVal()) != "SAMPLES" {
+				return packErrorMessage("Syntax error")
+			}
+			n, err := strconv.Atoi(args[4].StrVal())
+			if err != nil || n < 0 {
+				return packErrorMessage("value is not an integer or out of range")
+			}
+			// SAMPLES 0 measures all elements
+			samples = n
+		}
+		val := db.PeekAlive(args[2])
+		if val == nil {
+			return packNil()
+		}
+		return packInt(int(EstimateSize(args[2], val, samples)))
+	case "STATS":
+		if len(args) != 2 {
+			return packErrorMessage("Invalid parameter number")
+		}
+		return packBulkArray(server.MemoryStats())
+	}
+	return packErrorMessage("Unknown subcommand " + args[1].StrVal())
+}
+
+// KEYS pattern
+// return all keys matching pattern, the whole keyspace is traversed, use SCAN for a large one
+func keysCommandProcess(args []*DbObject, db *Database, server Server) string {
+	pattern := util.CompileGlob(args[1].StrVal())
+	keys := make([]string, 0)
+	db.ForEach(func(key, val *DbObject, expireTime int64) bool {
+		if pattern.Match(key.StrVal()) {
+			keys = append(keys, key.StrVal())
+		}
+		return true
+	})
+	log.Printf("[KEYS COMMAND]Success\n")
+	return packBulkArray(keys)
+}
+
+// scanOptions
+// options of SCAN, HSCAN, SSCAN and ZSCAN
+type scanOptions struct {
+	cursor uint64
+	// nil if MATCH is not given
+	pattern *util.GlobPattern
+	count   int
+	// SCAN only, "" for all types
+	typeName string
+}
+
+// parseScanOptions
+// parse cursor [MATCH pattern] [COUNT count] [TYPE type] from args, TYPE is allowed if withType
+func parseScanOptions(args []*DbObject, withType bool) (*scanOptions, string) {
+	cursor, err := strconv.ParseUint(args[0].StrVal(), 10, 64)
+	if err != nil {
+		return nil, packErrorMessage("Invalid cursor")
+	}
+	options := &scanOptions{cursor: cursor, count: DefaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, packErrorMessage("Syntax error")
+		}
+		value := args[i+1].StrVal()
+		switch option := strings.ToUpper(args[i].StrVal()); {
+		case option == "MATCH":
+			options.pattern = util.CompileGlob(value)
+		case option == "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, packErrorMessage("value is not an integer or out of range")
+			}
+			if n < 1 {
+				return nil, packErrorMessage("Syntax error")
+			}
+			options.count = n
+		case option == "TYPE" && withType:
+			options.typeName = strings.ToLower(value)
+		default:
+			return nil, packErrorMessage("Syntax error")
+		}
+	}
+	return options, ""
+}
+
+// match
+// judge whether s matches the MATCH pattern
+func (options *scanOptions) match(s string) bool {
+	return options.pattern == nil || options.pattern.Match(s)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// return the next cursor (0 if scanning is finished) and keys, see Dict.Scan
+// keys present from the start to the end of scanning are all returned, maybe more than once
+func scanCommandProcess(args []*DbObject, db *Database, server Server) string {
+	options, errReply := parseScanOptions(args[1:], true)
+	if options == nil {
+		return errReply
+	}
+	current := getTime()
+	keys := make([]string, 0)
+	next := db.Scan(options.cursor, options.count, func(key, val *DbObject, expireTime int64) {
+		if expireTime >= 0 && current >= expireTime {
+			return
+		}
+		if options.typeName != "" && persistence.TypeName(val.Type) != options.typeName {
+			return
+		}
+		if options.match(key.StrVal()) {
+			keys = append(keys, key.StrVal())
+		}
+	})
+	log.Printf("[SCAN COMMAND]Success\n")
+	return packScanReply(next, keys)
+}
+
+// getScanValue
+// value of key for HSCAN, SSCAN and ZSCAN, nil and an empty reply if key does not exist
+func getScanValue(key *DbObject, expectedType DbObjectType, db *Database) (*DbObject, string) {
+	if !checkString(key) {
+		return nil, packErrorMessage("Illegal request parameter")
+	}
+	val, err := db.GetKey(key)
+	if err != nil {
+		return nil, packScanReply(0, []string{})
+	}
+	if val.Type != expectedType {
+		return nil, packErrorMessage("Illegal key type")
+	}
+	return val, ""
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+// return the next cursor and fields followed by their values
+func hscanCommandProcess(args []*DbObject, db *Database, server Server) string {
+	options, errReply := parseScanOptions(args[2:], false)
+	if options == nil {
+		return errReply
+	}
+	val, reply := getScanValue(args[1], HASH, db)
+	if val == nil {
+		return reply
+	}
+	elements := make([]string, 0)
+	next := val.Val.(*Hash).Scan(options.cursor, options.count, func(field, value *DbObject) {
+		if options.match(field.StrVal()) {
+			elements = append(elements, field.StrVal(), value.StrVal())
+		}
+	})
+	log.Printf("[HSCAN COMMAND]Success\n")
+	return packScanReply(next, elements)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+// return the next cursor and members
+func sscanCommandProcess(args []*DbObject, db *Database, server Server) string {
+	options, errReply := parseScanOptions(args[2:], false)
+	if options == nil {
+		return errReply
+	}
+	val, reply := getScanValue(args[1], SET, db)
+	if val == nil {
+		return reply
+	}
+	elements := make([]string, 0)
+	next := val.Val.(*Set).Scan(options.cursor, options.count, func(member *DbObject) {
+		if options.match(member.StrVal()) {
+			elements = append(elements, member.StrVal())
+		}
+	})
+	log.Printf("[SSCAN COMMAND]Success\n")
+	return packScanReply(next, elements)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+// return the next cursor and members followed by their scores
+func zscanCommandProcess(args []*DbObject, db *Database, server Server) string {
+	options, errReply := parseScanOptions(args[2:], false)
+	if options == nil {
+		return errReply
+	}
+	val, reply := getScanValue(args[1], ZSET, db)
+	if val == nil {
+		return reply
+	}
+	elements := make([]string, 0)
+	next := val.Val.(*Zset).Scan(options.cursor, options.count, func(member, score *DbObject) {
+		if options.match(member.StrVal()) {
+			elements = append(elements, member.StrVal(), score.StrVal())
+		}
+	})
+	log.Printf("[ZSCAN COMMAND]Success\n")
+	return packScanReply(next, elements)
+}
+
+// KEYSTATS cursor [COUNT count] [SAMPLES samples]
+// scan about count keys from cursor like SCAN, used by client --bigkeys/--memkeys/--hotkeys
+// return the next cursor (0 if all keys are walked) followed by key, type, length, memory and
+// access frequency (-1 if an LFU policy is not selected) of every key walked
+// a large keyspace is walked by many calls, so the event loop is not blocked
+func keystatsCommandProcess(args []*DbObject, db *Database, server Server) string {
+	if len(args)%2 != 0 {
+		return packErrorMessage("Invalid parameter number")
+	}
+	cursor, err := strconv.ParseUint(args[1].StrVal(), 10, 64)
+	if err != nil {
+		return packErrorMessage("Invalid cursor")
+	}
+	count, samples := DefaultKeystatsCount, DefaultSizeSamples
+	for i := 2; i < len(args); i += 2 {
+		n, err := strconv.Atoi(args[i+1].StrVal())
+		if err != nil || n < 0 {
+			return packErrorMessage("value is not an integer or out of range")
+		}
+		switch strings.ToUpper(args[i].StrVal()) {
+		case "COUNT":
+			if n == 0 {
+				return packErrorMessage("Syntax error")
+			}
+			count = n
+		case "SAMPLES":
+			samples = n
+		default:
+			return packErrorMessage("Syntax error")
+		}
+	}
+	current := getTime()
+	stats := []string{""}
+	next := db.Scan(cursor, count, func(key, val *DbObject, expireTime int64) {
+		if expireTime >= 0 && current >= expireTime {
+			return
+		}
+		freq := -1
+		if db.UsesLfu() {
+			freq = int(Frequency(val))
+		}
+		stats = append(stats, key.StrVal(), persistence.TypeName(val.Type), strconv.FormatInt(valueLength(val), 10),
+			strconv.FormatInt(EstimateSize(key, val, samples), 10), strconv.Itoa(freq))
+	})
+	stats[0] = strconv.FormatUint(next, 10)
+	return packBulkArray(stats)
+}
+
 // util
 
+// valueLength
+// bytes of a string, or elements of other types
+func valueLength(val *DbObject) int64 {
+	switch v := val.Val.(type) {
+	case *LinkedList:
+		return int64(v.Len())
+	case *Set:
+		return int64(v.Length())
+	case *Hash:
+		return v.Len()
+	case *Zset:
+		return v.Len()
+	}
+	return int64(len(val.StrVal()))
+}
+
 // pack
 
 func packErrorMessage(msg string) string {
@@ -805,6 +2546,12 @@ func packInt(num int) string {
 	return strings.Join(str, "")
 }
 
+// packNil
+// nil bulk string, e.g. the old value of a key not exists
+func packNil() string {
+	return BulkStringHead + "-1" + CRLF
+}
+
 func packBulkString(msg string) string {
 	len := strconv.Itoa(len(msg))
 	var builder strings.Builder
@@ -816,6 +2563,29 @@ func packBulkString(msg string) string {
 	return builder.String()
 }
 
+// packScanReply
+// an array of the cursor and an array of elements
+func packScanReply(cursor uint64, elements []string) string {
+	return BulkArrayHead + "2" + CRLF + packBulkString(strconv.FormatUint(cursor, 10)) + packBulkArray(elements)
+}
+
+// packNullableArray
+// an array of bulk strings, nil elements are packed as nil
+func packNullableArray(objs []*DbObject) string {
+	var builder strings.Builder
+	builder.WriteString(BulkArrayHead)
+	builder.WriteString(strconv.Itoa(len(objs)))
+	builder.WriteString(CRLF)
+	for _, obj := range objs {
+		if obj == nil {
+			builder.WriteString(packNil())
+			continue
+		}
+		builder.WriteString(packBulkString(obj.StrVal()))
+	}
+	return builder.String()
+}
+
 func packBulkArray(msgs []string) string {
 	n := len(msgs)
 	var builder strings.Builder
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"goRedis/core"
+	. "goRedis/data_structure"
+	"goRedis/persistence"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppendOnlyFile(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	buffer.Write(persistence.EncodeCommand([]*DbObject{NewStr("SET"), NewStr("key"), NewStr("a b\r\nc")}))
+	buffer.Write(persistence.EncodeCommand([]*DbObject{NewStr("INCR"), NewStr("counter")}))
+	complete := int64(buffer.Len())
+	// an incomplete command at the end
+	buffer.WriteString("*2\r\n$4\r\nINCR\r\n$3\r\nco")
+	commands := make([][]*DbObject, 0)
+	offset, err := persistence.ReadAppendOnlyFile(bytes.NewReader(buffer.Bytes()), func(args []*DbObject) error {
+		commands = append(commands, args)
+		return nil
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	if offset != complete {
+		t.Fatalf("expected offset %d, got %d", complete, offset)
+	}
+	if len(commands) != 2 || commands[0][2].StrVal() != "a b\r\nc" || commands[1][1].StrVal() != "counter" {
+		t.Fatalf("commands mismatch")
+	}
+}
+
+func TestCorruptedAppendOnlyFile(t *testing.T) {
+	valid := persistence.EncodeCommand([]*DbObject{NewStr("SET"), NewStr("key"), NewStr("value")})
+	for _, corrupted := range []string{
+		"*99999999999999999999\r\n",
+		"*99999999999999\r\n",
+		"*2\r\n$99999999999999\r\n",
+		"*2\r\n$-1\r\n",
+		"*1\r\n$3\r\nSETX\r\n",
+	} {
+		offset, err := persistence.ReadAppendOnlyFile(bytes.NewReader(append(valid, corrupted...)), func(args []*DbObject) error {
+			return nil
+		})
+		if err != persistence.ErrorAofCorrupted {
+			t.Fatalf("%q: expected corrupted AOF, got %v", corrupted, err)
+		}
+		if offset != int64(len(valid)) {
+			t.Fatalf("%q: expected offset %d, got %d", corrupted, len(valid), offset)
+		}
+	}
+	// a large length in a truncated file is an incomplete command
+	_, err := persistence.ReadAppendOnlyFile(bytes.NewReader([]byte("*100000\r\n$536870912\r\nSET\r\n")), func(args []*DbObject) error {
+		return nil
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+}
+
+func TestLoadTruncatedAppendOnlyFile(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	complete := persistence.EncodeCommand([]*DbObject{NewStr("SET"), NewStr("key"), NewStr("value")})
+	for i, loadTruncated := range []bool{false, true} {
+		config := newTestConfig(16530+i, t.TempDir())
+		config.AppendOnly = true
+		config.AofLoadTruncated = loadTruncated
+		path := filepath.Join(config.Dir, config.AppendFileName)
+		if err := os.WriteFile(path, append(complete, "*2\r\n$4\r\nINCR"...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		server, err := core.NewServer(config)
+		if !loadTruncated {
+			// the file is kept for goredis-check-aof
+			if err == nil {
+				t.Fatalf("truncated AOF should not be loaded")
+			}
+			if data, _ := os.ReadFile(path); len(data) == len(complete) {
+				t.Fatalf("truncated AOF should not be modified")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("load truncated AOF error: %s", err)
+		}
+		t.Cleanup(server.Shutdown)
+		if str, _ := server.Dbs[0].GetStr(NewStr("key")); str == nil || str.StrVal() != "value" {
+			t.Fatalf("complete commands should be replayed")
+		}
+	}
+}
+
+// appendOnlyCommands
+// commands written to the incr files of the server, SELECT excluded
+func appendOnlyCommands(t *testing.T, config *core.Config) []string {
+	dir := filepath.Join(config.Dir, config.AppendDirName)
+	manifest, err := persistence.LoadAofManifest(dir, config.AppendFileName)
+	if err != nil {
+		t.Fatalf("load manifest error: %s", err)
+	}
+	commands := make([]string, 0)
+	for _, info := range manifest.Incrs {
+		err = persistence.LoadAppendOnlyFile(filepath.Join(dir, info.Name), func(args []*DbObject) error {
+			if args[0].StrVal() != "SELECT" {
+				commands = append(commands, args[0].StrVal()+" "+args[1].StrVal())
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("load incr file error: %s", err)
+		}
+	}
+	return commands
+}
+
+func TestNotAppliedWrites(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16532, t.TempDir())
+	config.AppendOnly = true
+	s := startTestServer(t, config)
+	s.do(t,
+		[]string{"SET", "a", "1"},
+		// not applied
+		[]string{"EXPIRE", "none", "100"},
+		[]string{"EXPIRE", "a", "100", "XX"},
+		[]string{"PERSIST", "a"},
+		[]string{"DEL", "none"},
+		// applied
+		[]string{"EXPIRE", "a", "100"},
+		// not applied
+		[]string{"EXPIRE", "a", "200", "NX"},
+		[]string{"PEXPIREAT", "a", "1", "GT"},
+		[]string{"SET", "a", "2", "NX"},
+		[]string{"SET", "none", "1", "XX", "GET"},
+		// applied
+		[]string{"SET", "b", "1", "NX", "EX", "100"},
+	)
+	expected := []string{"SET a", "PEXPIREAT a", "SET b"}
+	if commands := appendOnlyCommands(t, config); strings.Join(commands, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected commands %v in AOF, got %v", expected, commands)
+	}
+}
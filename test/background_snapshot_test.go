@@ -0,0 +1,304 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"goRedis/service"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBackgroundSnapshot(t *testing.T) {
+	db := NewDatabase()
+	expireTime := time.Now().UnixNano() + int64(time.Hour)
+	for i := 0; i < 10000; i += 1 {
+		db.SetStr(NewStr("key"+strconv.Itoa(i)), NewStr(strconv.Itoa(i)), expireTime)
+	}
+	file, err := os.CreateTemp(t.TempDir(), "snapshot-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := persistence.NewSnapshotSession([]*Database{db}, file, persistence.NewSnapshotFileEncoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000; i += 1 {
+		if i%100 == 0 {
+			session.Step(time.Microsecond)
+		}
+		key := NewStr("key" + strconv.Itoa(i))
+		session.BeforeWrite(0, key)
+		if i%2 == 0 {
+			db.SetStr(key, NewStr("modified"), expireTime)
+		} else {
+			db.RemoveKey(key)
+		}
+		newKey := NewStr("new" + strconv.Itoa(i))
+		session.BeforeWrite(0, newKey)
+		db.SetStr(newKey, NewStr("new"), expireTime)
+	}
+	session.Finish()
+	if err = <-session.Done(); err != nil {
+		t.Fatalf("background snapshot error: %s", err)
+	}
+
+	count := 0
+	reader, _ := os.Open(file.Name())
+	defer reader.Close()
+	err = persistence.ReadSnapshot(reader, func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		count += 1
+		if key.StrVal()[:3] == "new" {
+			t.Fatalf("key %s created after snapshot started", key.StrVal())
+		}
+		if val.StrVal() != key.StrVal()[3:] {
+			t.Fatalf("key %s has value %s modified after snapshot started", key.StrVal(), val.StrVal())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read snapshot error: %s", err)
+	}
+	if count != 10000 {
+		t.Fatalf("expected 10000 keys, got %d", count)
+	}
+}
+
+// elements
+// elements of a value returned by ElementWalker, in order for a list
+func elements(val *DbObject) []string {
+	if val.Type == STR {
+		return []string{val.StrVal()}
+	}
+	result := make([]string, 0)
+	NewElementWalker(val).Walk(math.MaxInt, func(element, extra *DbObject) {
+		if extra != nil {
+			result = append(result, element.StrVal()+"="+extra.StrVal())
+		} else {
+			result = append(result, element.StrVal())
+		}
+	})
+	if val.Type != LINKDLIST {
+		sort.Strings(result)
+	}
+	return result
+}
+
+// keysOf
+// elements of every key in db
+func keysOf(db *Database) map[string][]string {
+	keys := make(map[string][]string)
+	db.WalkKeys(0, math.MaxInt, func(key, val *DbObject, expireTime int64) {
+		keys[key.StrVal()] = elements(val)
+	})
+	return keys
+}
+
+// readSnapshotKeys
+// elements of every key in a snapshot file, by db index
+func readSnapshotKeys(t *testing.T, path string, databases int) []map[string][]string {
+	keys := make([]map[string][]string, databases)
+	for index := range keys {
+		keys[index] = make(map[string][]string)
+	}
+	err := persistence.ReadSnapshotFile(path, func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		keys[dbIndex][key.StrVal()] = elements(val)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read snapshot error: %s", err)
+	}
+	return keys
+}
+
+func TestBackgroundSnapshotLargeValues(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, rewrite := range []bool{false, true} {
+		db := NewDatabase()
+		types := []DbObjectType{LINKDLIST, SET, HASH, ZSET}
+		for i := 0; i < 20; i += 1 {
+			val, _ := db.GetKeyObject(NewStr(fmt.Sprint("large", i)), types[i%len(types)])
+			for j := 0; j < 3000; j += 1 {
+				member := NewStr(fmt.Sprint("member", j))
+				switch v := val.Val.(type) {
+				case *LinkedList:
+					v.Rpush(member)
+				case *Set:
+					v.Add(member)
+				case *Hash:
+					v.Set(member, NewStr(fmt.Sprint(j)))
+				case *Zset:
+					v.AddMember(member, int64(j))
+				}
+			}
+			db.SetStr(NewStr(fmt.Sprint("str", i)), NewStr("value"), -1)
+		}
+		expected := keysOf(db)
+		path := filepath.Join(t.TempDir(), "snapshot")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newEncoder := persistence.NewSnapshotFileEncoder
+		if rewrite {
+			newEncoder = func(w io.Writer) (persistence.EntryEncoder, error) {
+				return persistence.NewAofRewriteEncoder(w), nil
+			}
+		}
+		session, err := persistence.NewSnapshotSession([]*Database{db}, file, newEncoder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// keys are modified in the middle of values encoded in parts
+		for i := 0; i < 20; i += 1 {
+			session.Step(time.Microsecond)
+			for _, name := range []string{fmt.Sprint("large", i), fmt.Sprint("str", (i+7)%20)} {
+				key := NewStr(name)
+				session.BeforeWrite(0, key)
+				if i%3 == 0 {
+					db.RemoveKey(key)
+					continue
+				}
+				val, _ := db.GetKey(key)
+				switch v := val.Val.(type) {
+				case *LinkedList:
+					v.Lpop()
+				case *Set:
+					v.Add(NewStr("added"))
+				case *Hash:
+					v.Set(NewStr("member0"), NewStr("modified"))
+				case *Zset:
+					v.Remove(NewStr("member1"))
+				default:
+					db.SetStr(key, NewStr("modified"), -1)
+				}
+			}
+		}
+		session.Finish()
+		if err = <-session.Done(); err != nil {
+			t.Fatalf("background snapshot error: %s", err)
+		}
+		var keys map[string][]string
+		if rewrite {
+			replayed := NewDatabase()
+			err = persistence.LoadAppendOnlyFile(path, func(args []*DbObject) error {
+				if reply := service.Handle(args, replayed, newFakeServer(replayed)); service.IsErrorReply(reply) {
+					return fmt.Errorf("replay %v error %q", args, reply)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = keysOf(replayed)
+		} else {
+			keys = readSnapshotKeys(t, path, 1)[0]
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("rewrite %v: keys saved are not the keys at the start time", rewrite)
+		}
+	}
+}
+
+func TestBackgroundSnapshotFlushAndSwap(t *testing.T) {
+	dbs := []*Database{NewDatabase(), NewDatabase()}
+	lazyfree := NewLazyfree(LazyfreeOptions{})
+	for _, db := range dbs {
+		db.SetLazyfree(lazyfree)
+	}
+	for i := 0; i < 2000; i += 1 {
+		dbs[0].SetStr(NewStr(fmt.Sprint("key", i)), NewStr("value"), -1)
+	}
+	list, _ := dbs[0].GetKeyObject(NewStr("list"), LINKDLIST)
+	for i := 0; i < 5000; i += 1 {
+		list.Val.(*LinkedList).Rpush(NewStr(fmt.Sprint(i)))
+	}
+	dbs[1].SetStr(NewStr("a"), NewStr("1"), -1)
+	expected := []map[string][]string{keysOf(dbs[0]), keysOf(dbs[1])}
+	path := filepath.Join(t.TempDir(), "snapshot")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := persistence.NewSnapshotSession(dbs, file, persistence.NewSnapshotFileEncoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Step(time.Microsecond)
+	// the flushed dicts are still walked
+	dbs[0].Flush(true)
+	waitFreed(t, lazyfree)
+	if lazyfree.FreedObjects() != 0 || list.Val.(*LinkedList).Len() != 5000 {
+		t.Fatalf("dicts walked by the snapshot are freed")
+	}
+	session.BeforeWrite(0, NewStr("list"))
+	dbs[0].SetStr(NewStr("list"), NewStr("new"), -1)
+	// db 0 has the keys of db 1 at the start time
+	dbs[0].Swap(dbs[1])
+	session.BeforeWrite(0, NewStr("a"))
+	dbs[0].SetStr(NewStr("a"), NewStr("2"), -1)
+	session.Step(time.Microsecond)
+	session.BeforeWrite(1, NewStr("list"))
+	dbs[1].RemoveKey(NewStr("list"))
+	session.Finish()
+	if err = <-session.Done(); err != nil {
+		t.Fatalf("background snapshot error: %s", err)
+	}
+	if keys := readSnapshotKeys(t, path, 2); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("keys saved are not the keys at the start time")
+	}
+	// the flushed dicts are freed after the snapshot
+	waitFreed(t, lazyfree)
+	if lazyfree.FreedObjects() != 3 {
+		t.Fatalf("expected the flushed dicts and the list deleted are freed, got %d", lazyfree.FreedObjects())
+	}
+}
+
+func TestFlushDuringBackgroundSave(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16540, t.TempDir())
+	config.AppendOnly = true
+	config.AutoAofRewritePercentage = 0
+	expected, expected1 := writeRewriteDataset(t, config)
+	s := startTestServer(t, config)
+
+	// commands sent at once are processed before the time events of the first steps, so FLUSHALL
+	// runs while the background save and rewrite are in progress
+	replies := s.do(t, []string{"BGSAVE"}, []string{"BGREWRITEAOF"}, []string{"FLUSHALL"}, []string{"SET", "new", "value"},
+		[]string{"INFO", "persistence"})
+	for _, reply := range replies {
+		if reply[0] == '-' {
+			t.Fatalf("unexpected reply %q", reply)
+		}
+	}
+	// FLUSHALL does not finish the background save and rewrite
+	if infoField(t, replies[4], "rdb_bgsave_in_progress") != "1" || infoField(t, replies[4], "aof_rewrite_in_progress") != "1" {
+		t.Fatalf("background save of %d keys finished by FLUSHALL", rewriteKeys)
+	}
+	s.waitInfo(t, "persistence", "rdb_bgsave_in_progress", "0", 30*time.Second)
+	s.waitInfo(t, "persistence", "aof_rewrite_in_progress", "0", 30*time.Second)
+	keys := readSnapshotKeys(t, filepath.Join(config.Dir, config.DbFileName), 2)
+	for index, dataset := range []map[string]string{expected, expected1} {
+		if len(keys[index]) != len(dataset) {
+			t.Fatalf("db %d: expected %d keys saved, got %d", index, len(dataset), len(keys[index]))
+		}
+		for key, value := range dataset {
+			if elements := keys[index][key]; len(elements) != 1 || elements[0] != value {
+				t.Fatalf("db %d: %s should be %s, got %v", index, key, value, elements)
+			}
+		}
+	}
+	// the rewritten AOF and the writes after it
+	checkReloaded(t, config, 16541, map[string]string{"new": "value"}, map[string]string{})
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"bytes"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckSnapshot(t *testing.T) {
+	db := NewDatabase()
+	db.SetStr(NewStr("a"), NewStr("1"), time.Now().UnixNano()+int64(time.Hour))
+	db.SetStr(NewStr("b"), NewStr("2"), time.Now().UnixNano()+int64(time.Hour))
+	buffer := &bytes.Buffer{}
+	if err := persistence.WriteSnapshot(buffer, []*Database{db}); err != nil {
+		t.Fatal(err)
+	}
+	stats := persistence.NewKeyStats()
+	handler := func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		stats.Add(dbIndex, key, val, expireTime)
+		return nil
+	}
+	offset, err := persistence.CheckSnapshot(bytes.NewReader(buffer.Bytes()), handler)
+	if err != nil || offset != int64(buffer.Len()) {
+		t.Fatalf("check snapshot error %v at offset %d", err, offset)
+	}
+	if stats.Types[STR] == nil || stats.Types[STR].Keys != 2 || stats.Dbs[0] != 2 {
+		t.Fatalf("key stats mismatch")
+	}
+	// truncated in the middle of the last key
+	_, err = persistence.CheckSnapshot(bytes.NewReader(buffer.Bytes()[:buffer.Len()-12]), handler)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+}
+
+func TestTruncateAppendOnlyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := persistence.EncodeCommand([]*DbObject{NewStr("SET"), NewStr("key"), NewStr("value")})
+	if err := os.WriteFile(path, append(complete, []byte("*2\r\n$4\r\nINCR")...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := persistence.ReadAppendOnlyFile(file, func(args []*DbObject) error { return nil })
+	file.Close()
+	if err != io.ErrUnexpectedEOF || offset != int64(len(complete)) {
+		t.Fatalf("expected unexpected EOF at offset %d, got %v at offset %d", len(complete), err, offset)
+	}
+	if err = persistence.TruncateAppendOnlyFile(path, offset); err != nil {
+		t.Fatal(err)
+	}
+	if err = persistence.LoadAppendOnlyFile(path, func(args []*DbObject) error { return nil }); err != nil {
+		t.Fatalf("load truncated AOF error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMoveKey(t *testing.T) {
+	source, target := NewDatabase(), NewDatabase()
+	expireTime := time.Now().UnixNano() + int64(time.Hour)
+	source.SetStr(NewStr("a"), NewStr("1"), expireTime)
+	source.SetStr(NewStr("b"), NewStr("2"), -1)
+	target.SetStr(NewStr("b"), NewStr("other"), -1)
+
+	if moved, err := source.MoveKey(NewStr("a"), target); err != nil || !moved {
+		t.Fatalf("move a: moved = %v, err = %v", moved, err)
+	}
+	if source.Size() != 1 || target.Size() != 2 {
+		t.Fatalf("expected 1 and 2 keys, got %d and %d", source.Size(), target.Size())
+	}
+	if got := target.GetExpireTime(NewStr("a")); got != expireTime {
+		t.Fatalf("expire time is not moved, got %d", got)
+	}
+	// target already has the key
+	if moved, _ := source.MoveKey(NewStr("b"), target); moved {
+		t.Fatalf("b should not be moved")
+	}
+	if str, _ := target.GetStr(NewStr("b")); str == nil || str.StrVal() != "other" {
+		t.Fatalf("b of target is overwritten")
+	}
+	if moved, _ := source.MoveKey(NewStr("none"), target); moved {
+		t.Fatalf("a key not exists should not be moved")
+	}
+}
+
+func TestSwapDatabases(t *testing.T) {
+	first, second := NewDatabase(), NewDatabase()
+	first.SetStr(NewStr("a"), NewStr("1"), time.Now().UnixNano()+int64(time.Hour))
+	first.Swap(second)
+	if first.Size() != 0 || second.Size() != 1 || second.Expires() != 1 {
+		t.Fatalf("keys are not swapped")
+	}
+}
+
+func TestMultiDatabaseSnapshot(t *testing.T) {
+	dbs := []*Database{NewDatabase(), NewDatabase(), NewDatabase()}
+	dbs[0].SetStr(NewStr("k"), NewStr("db0"), -1)
+	dbs[2].SetStr(NewStr("k"), NewStr("db2"), -1)
+	dbs[2].SetStr(NewStr("x"), NewStr("y"), -1)
+
+	path := filepath.Join(t.TempDir(), "multi.gdb")
+	if err := persistence.SaveSnapshot(path, dbs); err != nil {
+		t.Fatalf("save snapshot error: %s", err)
+	}
+	loaded := []*Database{NewDatabase(), NewDatabase(), NewDatabase()}
+	if err := persistence.LoadSnapshot(path, loaded); err != nil {
+		t.Fatalf("load snapshot error: %s", err)
+	}
+	for index, db := range dbs {
+		if loaded[index].Size() != db.Size() {
+			t.Fatalf("db %d: expected %d keys, got %d", index, db.Size(), loaded[index].Size())
+		}
+	}
+	if str, _ := loaded[2].GetStr(NewStr("k")); str == nil || str.StrVal() != "db2" {
+		t.Fatalf("k of db 2 mismatch")
+	}
+	// fewer databases configured
+	if err := persistence.LoadSnapshot(path, []*Database{NewDatabase()}); err == nil {
+		t.Fatalf("keys of db 2 should not be loaded into 1 database")
+	}
+
+	// background snapshot walks databases in order and selects each of them
+	file, err := os.Create(filepath.Join(t.TempDir(), "bg.gdb"))
+	if err != nil {
+		t.Fatalf("create file error: %s", err)
+	}
+	session, err := persistence.NewSnapshotSession(dbs, file, persistence.NewSnapshotFileEncoder)
+	if err != nil {
+		t.Fatalf("new session error: %s", err)
+	}
+	session.BeforeWrite(2, NewStr("x"))
+	dbs[2].SetStr(NewStr("x"), NewStr("changed"), -1)
+	session.Finish()
+	if err = <-session.Done(); err != nil {
+		t.Fatalf("background snapshot error: %s", err)
+	}
+	loaded = []*Database{NewDatabase(), NewDatabase(), NewDatabase()}
+	if err = persistence.LoadSnapshot(file.Name(), loaded); err != nil {
+		t.Fatalf("load background snapshot error: %s", err)
+	}
+	if str, _ := loaded[2].GetStr(NewStr("x")); str == nil || str.StrVal() != "y" {
+		t.Fatalf("x of db 2 should be the value before writing")
+	}
+	if loaded[0].Size() != 1 || loaded[1].Size() != 0 || loaded[2].Size() != 2 {
+		t.Fatalf("keys are loaded into wrong databases")
+	}
+
+	// aof rewrite session selects databases with SELECT commands
+	if file, err = os.Create(filepath.Join(t.TempDir(), "rewrite.aof")); err != nil {
+		t.Fatalf("create file error: %s", err)
+	}
+	session, err = persistence.NewSnapshotSession(dbs, file, func(w io.Writer) (persistence.EntryEncoder, error) {
+		return persistence.NewAofRewriteEncoder(w), nil
+	})
+	if err != nil {
+		t.Fatalf("new session error: %s", err)
+	}
+	session.Finish()
+	if err = <-session.Done(); err != nil {
+		t.Fatalf("aof rewrite error: %s", err)
+	}
+	replayed := []*Database{NewDatabase(), NewDatabase(), NewDatabase()}
+	selected, selects := 0, 0
+	err = persistence.LoadAppendOnlyFile(file.Name(), func(args []*DbObject) error {
+		if args[0].StrVal() == "SELECT" {
+			selected, _ = strconv.Atoi(args[1].StrVal())
+			selects += 1
+			return nil
+		}
+		return replayed[selected].SetStr(args[1], args[2], -1)
+	})
+	if err != nil || selects != 2 {
+		t.Fatalf("expected 2 SELECT commands, got %d, err = %v", selects, err)
+	}
+	for index, db := range dbs {
+		if replayed[index].Size() != db.Size() {
+			t.Fatalf("db %d: expected %d keys replayed, got %d", index, db.Size(), replayed[index].Size())
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	. "goRedis/data_structure"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestDict(t *testing.T) {
@@ -36,3 +37,56 @@ func TestDict(t *testing.T) {
 	//fmt.Printf("HASH CONFLICT %d\n", dict.HashConflict())
 	//fmt.Printf("%d\n", dict.Used())
 }
+
+func TestDictShrink(t *testing.T) {
+	dict := NewDict(StrHash, StrEqual)
+	for i := 0; i < 10000; i += 1 {
+		dict.Set(NewStr(strconv.Itoa(i)), NewStr("v"))
+	}
+	dict.RehashFor(time.Second)
+	full := dict.MemoryUsage()
+	for i := 0; i < 9990; i += 1 {
+		dict.Delete(NewStr(strconv.Itoa(i)))
+	}
+	for dict.RehashFor(time.Millisecond) {
+	}
+	if dict.Len() != 10 || dict.MemoryUsage() >= full/100 {
+		t.Fatalf("dict not shrunk, len %d, memory %d -> %d", dict.Len(), full, dict.MemoryUsage())
+	}
+	for i := 9990; i < 10000; i += 1 {
+		if _, err := dict.Get(NewStr(strconv.Itoa(i))); err != nil {
+			t.Fatalf("key %d lost after shrinking", i)
+		}
+	}
+}
+
+func TestDictSafeIterator(t *testing.T) {
+	dict := NewDict(StrHash, StrEqual)
+	for i := 0; i < 1000; i += 1 {
+		dict.Set(NewStr(strconv.Itoa(i)), NewStr("v"))
+	}
+	seen := 0
+	it := dict.NewSafeIterator()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		// rehash paused, deleting does not move or shrink tables
+		if dict.RehashFor(time.Millisecond) {
+			t.Fatalf("rehash should be paused by safe iterator")
+		}
+		dict.Delete(entry.Key())
+		seen += 1
+	}
+	if seen != 1000 || dict.Len() != 0 {
+		t.Fatalf("%d entries iterated, %d left", seen, dict.Len())
+	}
+	for dict.RehashFor(time.Millisecond) {
+	}
+	if dict.MemoryUsage() >= NewDict(StrHash, StrEqual).MemoryUsage()*2 {
+		t.Fatalf("empty dict not shrunk after iterating")
+	}
+	dict.Set(NewStr("k"), NewStr("v"))
+	for dict.RehashFor(time.Millisecond) {
+	}
+	if val, _ := dict.Get(NewStr("k")); val == nil || val.StrVal() != "v" {
+		t.Fatalf("dict broken after iterating")
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"goRedis/core"
+	. "goRedis/db"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirtyCounter(t *testing.T) {
+	db := NewDatabase()
+	server := newFakeServer(db)
+	cases := []struct {
+		args []string
+		// changes made by the command
+		dirty int64
+	}{
+		{[]string{"SET", "a", "1"}, 1},
+		{[]string{"SET", "b", "2"}, 1},
+		{[]string{"GET", "a"}, 0},
+		{[]string{"EXISTS", "a", "b"}, 0},
+		{[]string{"TTL", "a"}, 0},
+		{[]string{"RPUSH", "l", "x"}, 1},
+		{[]string{"LLEN", "l"}, 0},
+		{[]string{"EXPIRE", "b", "100"}, 1},
+		{[]string{"PERSIST", "b"}, 1},
+		{[]string{"PERSIST", "b"}, 0},
+		// not set
+		{[]string{"SET", "a", "2", "NX"}, 0},
+		{[]string{"DEL", "a"}, 1},
+		{[]string{"DEL", "a"}, 0},
+		{[]string{"SCAN", "0"}, 0},
+		// every key removed
+		{[]string{"FLUSHDB"}, 2},
+	}
+	for _, c := range cases {
+		dirty := server.dirty
+		server.handle(c.args...)
+		if server.dirty-dirty != c.dirty {
+			t.Fatalf("%v: expected %d changes, got %d", c.args, c.dirty, server.dirty-dirty)
+		}
+	}
+}
+
+func TestSavePoint(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16510, t.TempDir())
+	// 2 changes in 0 seconds, checked by the cron after a second passes
+	config.Save = []core.SavePoint{{Seconds: 0, Changes: 2}}
+	s := startTestServer(t, config)
+	lastSave := s.do(t, []string{"LASTSAVE"})[0]
+	s.do(t, []string{"SET", "a", "1"})
+	time.Sleep(1100 * time.Millisecond)
+	// 1 change is not enough
+	if changes := s.info(t, "persistence", "rdb_changes_since_last_save"); changes != "1" {
+		t.Fatalf("expected 1 change since the last save, got %s", changes)
+	}
+	if _, err := os.Stat(filepath.Join(config.Dir, config.DbFileName)); !os.IsNotExist(err) {
+		t.Fatalf("snapshot should not be saved with 1 change")
+	}
+	s.do(t, []string{"SET", "b", "2"})
+	s.waitInfo(t, "persistence", "rdb_changes_since_last_save", "0", 5*time.Second)
+	s.waitInfo(t, "persistence", "rdb_bgsave_in_progress", "0", 5*time.Second)
+	if status := s.info(t, "persistence", "rdb_last_bgsave_status"); status != "ok" {
+		t.Fatalf("background save status %s", status)
+	}
+	saved := strings.TrimSpace(s.do(t, []string{"LASTSAVE"})[0][1:])
+	if saved == strings.TrimSpace(lastSave[1:]) {
+		t.Fatalf("LASTSAVE is not updated")
+	}
+	if unix, _ := strconv.ParseInt(saved, 10, 64); time.Now().Unix()-unix > 1 {
+		t.Fatalf("unexpected LASTSAVE %s", saved)
+	}
+	if info := s.info(t, "persistence", "rdb_last_save_time"); info != saved {
+		t.Fatalf("rdb_last_save_time %s is not LASTSAVE %s", info, saved)
+	}
+	if _, err := os.Stat(filepath.Join(config.Dir, config.DbFileName)); err != nil {
+		t.Fatalf("snapshot is not saved, err = %s", err)
+	}
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"goRedis/service"
+	"hash/crc64"
+	"testing"
+)
+
+func TestDumpRestore(t *testing.T) {
+	list := NewLinkedList()
+	list.Rpush(NewStr("a"))
+	list.Rpush(NewStr("b"))
+	hash := NewHash()
+	hash.Set(NewStr("field"), NewStr("value"))
+	zset := NewZset()
+	zset.AddMember(NewStr("member"), -7)
+	set := NewSet()
+	set.Add(NewStr("x"))
+	values := []*DbObject{NewStr("string"), NewObject(LINKDLIST, list), NewObject(HASH, hash), NewObject(ZSET, zset), NewObject(SET, set)}
+	for _, val := range values {
+		payload, err := persistence.DumpValue(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := persistence.RestoreValue(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Type != val.Type {
+			t.Fatalf("type mismatch")
+		}
+	}
+	payload, _ := persistence.DumpValue(NewObject(ZSET, zset))
+	restored, _ := persistence.RestoreValue(payload)
+	if score, _ := restored.Val.(*Zset).GetScore(NewStr("member")); score != -7 {
+		t.Fatalf("zset score mismatch")
+	}
+
+	// corrupted payload
+	payload, _ = persistence.DumpValue(NewStr("string"))
+	payload[1] ^= 0xFF
+	if _, err := persistence.RestoreValue(payload); err != persistence.ErrorDumpPayloadCorrupted {
+		t.Fatalf("expected corrupted payload, got %v", err)
+	}
+	// newer version with valid checksum
+	payload, _ = persistence.DumpValue(NewStr("string"))
+	body := payload[:len(payload)-8]
+	binary.LittleEndian.PutUint16(body[len(body)-2:], persistence.SnapshotVersion+1)
+	binary.LittleEndian.PutUint64(payload[len(body):], crc64.Checksum(body, crc64.MakeTable(crc64.ECMA)))
+	if _, err := persistence.RestoreValue(payload); !errors.Is(err, persistence.ErrorUnsupportedVersion) {
+		t.Fatalf("expected unsupported version, got %v", err)
+	}
+}
+
+func TestDumpCommand(t *testing.T) {
+	db := NewDatabase()
+	server := newFakeServer(db)
+	db.SetStr(NewStr("k"), NewStr("v"), -1)
+	payload, _ := persistence.DumpValue(NewStr("v"))
+	if reply := server.handle("DUMP", "k"); reply != fmt.Sprintf("$%d\r\n%s\r\n", len(payload), payload) {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	// a key not exists is a nil bulk string
+	if reply := server.handle("DUMP", "none"); reply != "$-1\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+}
+
+func TestRestoreCommand(t *testing.T) {
+	payload, _ := persistence.DumpValue(NewStr("restored"))
+	db := NewDatabase()
+	server := newFakeServer(db)
+	db.SetStr(NewStr("k"), NewStr("old"), -1)
+	if reply := server.handle("RESTORE", "k", "0", string(payload)); !service.IsErrorReply(reply) {
+		t.Fatalf("an existing key should not be replaced without REPLACE")
+	}
+	args := []*DbObject{NewStr("RESTORE"), NewStr("k"), NewStr("100000"), NewStr(string(payload)), NewStr("REPLACE")}
+	if reply := service.Handle(args, db, server); service.IsErrorReply(reply) {
+		t.Fatalf("RESTORE REPLACE error %q", reply)
+	}
+	if str, _ := db.GetStr(NewStr("k")); str == nil || str.StrVal() != "restored" {
+		t.Fatalf("k is not replaced")
+	}
+	// relative ttl is written as absolute unix time (ms)
+	propagated := service.AppendOnlyArgs(args, server)
+	if len(propagated) != 6 || propagated[4].StrVal() != "REPLACE" || propagated[5].StrVal() != "ABSTTL" {
+		t.Fatalf("unexpected propagated args %v", propagated)
+	}
+	replayed := NewDatabase()
+	service.Handle(propagated, replayed, newFakeServer(replayed))
+	expireTime := db.GetExpireTime(NewStr("k"))
+	if got := replayed.GetExpireTime(NewStr("k")); got != expireTime {
+		t.Fatalf("expire time of replayed key %d, expected %d", got, expireTime)
+	}
+	// ttl overflows
+	for _, args := range [][]string{{"9223372036854775807"}, {"9223372036854"}, {"9223372036855", "ABSTTL"}} {
+		if reply := server.handle(append([]string{"RESTORE", "big", args[0], string(payload)}, args[1:]...)...); !service.IsErrorReply(reply) {
+			t.Fatalf("ttl %v should be rejected", args)
+		}
+	}
+	if exist, _ := db.Exist(NewStr("big")); exist {
+		t.Fatalf("big should not be restored")
+	}
+}
@@ -0,0 +1,225 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"goRedis/core"
+	"goRedis/util"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpRecord
+// a record exported by goredis-dump in json
+type dumpRecord struct {
+	Db    int             `json:"db"`
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Ttl   int64           `json:"ttl"`
+	Value json.RawMessage `json:"value"`
+}
+
+// buildDumpTool
+// build goredis-dump, return the path of the binary
+func buildDumpTool(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "goredis-dump")
+	if output, err := exec.Command("go", "build", "-o", path, "goRedis/cmd/goredis-dump").CombinedOutput(); err != nil {
+		t.Fatalf("build goredis-dump error: %s\n%s", err, output)
+	}
+	return path
+}
+
+// runDumpTool
+// run goredis-dump with args, return its output and whether it succeeds
+func runDumpTool(t *testing.T, tool string, args ...string) (string, bool) {
+	output, err := exec.Command(tool, args...).CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatalf("run goredis-dump error: %s", err)
+	}
+	return string(output), err == nil
+}
+
+// readDumpRecords
+// records of a json dataset file by db and key
+func readDumpRecords(t *testing.T, path string) map[string]dumpRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := make(map[string]dumpRecord)
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var r dumpRecord
+		if err = decoder.Decode(&r); err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("decode %s error: %s", path, err)
+		}
+		records[fmt.Sprint(r.Db, ":", r.Key)] = r
+	}
+}
+
+// sameRecords
+// compare records of two exports, ttl decreases at most elapsed between them
+func sameRecords(t *testing.T, expected, got map[string]dumpRecord, elapsed time.Duration) {
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(got))
+	}
+	for name, e := range expected {
+		g, ext := got[name]
+		if !ext {
+			t.Fatalf("key %s not found", name)
+		}
+		if g.Type != e.Type || (e.Ttl < 0) != (g.Ttl < 0) || e.Ttl-g.Ttl > elapsed.Milliseconds() || g.Ttl > e.Ttl {
+			t.Fatalf("key %s: expected %s with ttl %d, got %s with ttl %d", name, e.Type, e.Ttl, g.Type, g.Ttl)
+		}
+		var expectedValue, value interface{}
+		json.Unmarshal(e.Value, &expectedValue)
+		json.Unmarshal(g.Value, &value)
+		if e.Type == "set" {
+			// members of a set are in no particular order
+			for _, members := range []interface{}{expectedValue, value} {
+				sort.Slice(members, func(i, j int) bool {
+					return members.([]interface{})[i].(string) < members.([]interface{})[j].(string)
+				})
+			}
+		}
+		if !reflect.DeepEqual(expectedValue, value) {
+			t.Fatalf("key %s: expected %s, got %s", name, e.Value, g.Value)
+		}
+	}
+}
+
+func TestDumpToolRoundTrip(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	tool := buildDumpTool(t)
+	config := newTestConfig(16550, t.TempDir())
+	s := startTestServer(t, config)
+	// every element of large values is a command when loading, they are pipelined in batches
+	commands := make([][]string, 0)
+	for i := 0; i < 1500; i += 1 {
+		key := fmt.Sprint("key:", i)
+		if i%3 == 0 {
+			commands = append(commands, []string{"SET", key, fmt.Sprint(i), "PX", "1000000"})
+		} else {
+			commands = append(commands, []string{"SET", key, fmt.Sprint(i)})
+		}
+		commands = append(commands, []string{"RPUSH", "list", fmt.Sprint("value,\"", i)})
+		if i < 500 {
+			commands = append(commands, []string{"SADD", "set", fmt.Sprint("member", i)},
+				[]string{"HSET", "hash", fmt.Sprint("field", i), fmt.Sprint(i)},
+				[]string{"ZADD", "zset", fmt.Sprint(i), fmt.Sprint("member", i)})
+		}
+	}
+	commands = append(commands, []string{"EXPIRE", "hash", "1000"}, []string{"SELECT", "3"},
+		[]string{"SET", "db3", "value", "EX", "1000"}, []string{"RPUSH", "db3:list", "a"}, []string{"RPUSH", "db3:list", "b"}, []string{"SAVE"})
+	for start := 0; start < len(commands); start += 1000 {
+		for _, reply := range s.do(t, commands[start:util.MinInt(start+1000, len(commands))]...) {
+			if reply[0] == '-' {
+				t.Fatalf("populate error %q", reply)
+			}
+		}
+	}
+	dir := t.TempDir()
+	snapshot := filepath.Join(config.Dir, config.DbFileName)
+	exported := filepath.Join(dir, "exported.json")
+	if output, ok := runDumpTool(t, tool, "-server", "127.0.0.1:16550", "-snapshot", snapshot, "-out", exported); !ok || !strings.Contains(output, "1506 keys exported") {
+		t.Fatalf("export server error: %s", output)
+	}
+	expected := readDumpRecords(t, exported)
+	exportTime := time.Now()
+	// a file not written by the server is not exported
+	stale := filepath.Join(dir, "stale.gdb")
+	if err := os.WriteFile(stale, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(stale, exportTime.Add(-time.Hour), exportTime.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if output, ok := runDumpTool(t, tool, "-server", "127.0.0.1:16550", "-snapshot", stale); ok || !strings.Contains(output, "is not written by the BGSAVE") {
+		t.Fatalf("a stale snapshot file should not be exported: %s", output)
+	}
+
+	// export a server in json and a snapshot in csv, load into an empty server
+	cases := []struct {
+		source []string
+		format string
+	}{
+		{[]string{"-server", "127.0.0.1:16550", "-snapshot", snapshot}, "json"},
+		{[]string{"-snapshot", snapshot}, "csv"},
+	}
+	for i, c := range cases {
+		port := 16551 + i
+		path := filepath.Join(dir, fmt.Sprint("dataset.", c.format))
+		args := append(c.source, "-format", c.format, "-out", path)
+		if output, ok := runDumpTool(t, tool, args...); !ok || !strings.Contains(output, "1506 keys exported") {
+			t.Fatalf("export %v error: %s", args, output)
+		}
+		loadConfig := newTestConfig(port, t.TempDir())
+		// the loader keeps commands in flight under the default query buffer of the server
+		loadConfig.MaxQueryLength = core.DefaultMaxQueryLength
+		startTestServer(t, loadConfig)
+		address := fmt.Sprint("127.0.0.1:", port)
+		output, ok := runDumpTool(t, tool, "-server", address, "-load", path, "-format", c.format)
+		if !ok || !strings.Contains(output, "1506 keys loaded, 0 commands failed") {
+			t.Fatalf("load %v error: %s", args, output)
+		}
+		reexported := filepath.Join(dir, fmt.Sprint("reexported", port, ".json"))
+		if output, ok = runDumpTool(t, tool, "-server", address, "-snapshot", filepath.Join(loadConfig.Dir, loadConfig.DbFileName), "-out", reexported); !ok {
+			t.Fatalf("export loaded server error: %s", output)
+		}
+		sameRecords(t, expected, readDumpRecords(t, reexported), time.Since(exportTime)+time.Second)
+	}
+}
+
+func TestDumpToolLoadErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	tool := buildDumpTool(t)
+	dir := t.TempDir()
+	lines := make([]string, 0)
+	for i := 0; i < 20; i += 1 {
+		lines = append(lines, fmt.Sprintf(`{"db":0,"key":"key%d","type":"string","ttl":-1,"value":"value"}`, i))
+	}
+	path := filepath.Join(dir, "dataset.json")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// every SET is rejected, DEL of keys not exist is not counted
+	config := newTestConfig(16555, t.TempDir())
+	config.Maxmemory = 1
+	startTestServer(t, config)
+	output, ok := runDumpTool(t, tool, "-server", "127.0.0.1:16555", "-load", path)
+	if ok || !strings.Contains(output, "20 keys loaded, 20 commands failed") {
+		t.Fatalf("failed commands are not counted: %s", output)
+	}
+	// only the first errors are printed
+	if printed := strings.Count(output, "OOM"); printed != 10 {
+		t.Fatalf("expected 10 errors printed, got %d: %s", printed, output)
+	}
+
+	// loading stops when the db of a key can not be selected
+	lines = append(lines, `{"db":20,"key":"db20","type":"string","ttl":-1,"value":"value"}`, lines[0])
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := startTestServer(t, newTestConfig(16556, t.TempDir()))
+	if output, ok = runDumpTool(t, tool, "-server", "127.0.0.1:16556", "-load", path); ok || !strings.Contains(output, "key db20 of db 20 can not be loaded") {
+		t.Fatalf("SELECT of db 20 should fail: %s", output)
+	}
+	if size := s.do(t, []string{"DBSIZE"})[0]; size != ":20\r\n" {
+		t.Fatalf("keys before the failed SELECT should be loaded, got %q", size)
+	}
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"strings"
+	"testing"
+)
+
+func TestIntset(t *testing.T) {
+	set := NewIntset()
+	values := []int64{5, -3, 1 << 20, 7, -(1 << 40), 5}
+	for _, v := range values {
+		set.Add(v)
+	}
+	result := make([]int64, 0)
+	set.ForEach(func(v int64) bool {
+		result = append(result, v)
+		return true
+	})
+	if fmt.Sprint(result) != fmt.Sprint([]int64{-(1 << 40), -3, 5, 7, 1 << 20}) {
+		t.Fatalf("unexpected intset %v", result)
+	}
+	if !set.Remove(7) || set.Remove(7) || set.Find(7) || !set.Find(-(1 << 40)) || set.Len() != 4 {
+		t.Fatalf("intset remove failed")
+	}
+}
+
+func TestListpack(t *testing.T) {
+	lp := NewListpack()
+	long := strings.Repeat("x", 300)
+	lp.Append("b")
+	lp.Append(long)
+	lp.Insert(lp.First(), "a")
+	lp.Append("c")
+	result := make([]string, 0)
+	for p := lp.Last(); p >= 0; p = lp.Prev(p) {
+		result = append(result, lp.Get(p))
+	}
+	if fmt.Sprint(result) != fmt.Sprint([]string{"c", long, "b", "a"}) {
+		t.Fatalf("unexpected reverse traversal %v", result)
+	}
+	p := lp.Find(lp.First(), long, 0)
+	lp.Replace(p, "d")
+	lp.Delete(lp.First())
+	result = result[:0]
+	lp.ForEach(func(s string) bool {
+		result = append(result, s)
+		return true
+	})
+	if fmt.Sprint(result) != fmt.Sprint([]string{"b", "d", "c"}) || lp.Len() != 3 {
+		t.Fatalf("unexpected listpack %v", result)
+	}
+}
+
+func TestIntEncodedString(t *testing.T) {
+	db := NewDatabase()
+	for _, s := range []string{"12345", "-7", "0123", "+1", "abc", "99999999999999999999"} {
+		db.SetStr(NewStr(s), NewStr(s), -1)
+		val, _ := db.GetStr(NewStr(s))
+		if val.StrVal() != s {
+			t.Fatalf("value of %s changed to %s", s, val.StrVal())
+		}
+		expected := EncodingRaw
+		if s == "12345" || s == "-7" {
+			expected = EncodingInt
+		}
+		if Encoding(val) != expected {
+			t.Fatalf("encoding of %s is %s", s, Encoding(val))
+		}
+	}
+}
+
+func TestEncodingConversion(t *testing.T) {
+	set := NewSet()
+	for i := 0; i < 10; i += 1 {
+		set.Add(NewObjectByInt(int64(i)))
+	}
+	if set.Encoding() != EncodingIntset || !set.Contains(NewStr("3")) || set.Contains(NewStr("03")) {
+		t.Fatalf("small set of integers should be intset encoded")
+	}
+	set.Add(NewStr("member"))
+	if set.Encoding() != EncodingHashtable || set.Length() != 11 || !set.Contains(NewStr("3")) {
+		t.Fatalf("set not converted")
+	}
+
+	hash := NewHash()
+	for i := 0; i < HashMaxListpackEntries; i += 1 {
+		hash.Set(NewStr(fmt.Sprintf("field%d", i)), NewStr("value"))
+	}
+	hash.Set(NewStr("field0"), NewStr("new"))
+	hash.Delete(NewStr("field1"))
+	if hash.Encoding() != EncodingListpack || hash.Len() != int64(HashMaxListpackEntries-1) {
+		t.Fatalf("hash should be listpack encoded")
+	}
+	hash.Set(NewStr("field1"), NewStr(strings.Repeat("x", HashMaxListpackValue+1)))
+	if value, _ := hash.Get(NewStr("field0")); hash.Encoding() != EncodingHashtable || value.StrVal() != "new" {
+		t.Fatalf("hash not converted")
+	}
+
+	zset := NewZset()
+	zset.AddMember(NewStr("c"), 3)
+	zset.AddMember(NewStr("a"), 1)
+	zset.AddMember(NewStr("b"), 2)
+	zset.Incr(NewStr("a"), 5)
+	scores, members := zset.Members()
+	if zset.Encoding() != EncodingListpack || members[0].StrVal() != "b" || members[2].StrVal() != "a" || scores[2].StrVal() != "6" {
+		t.Fatalf("unexpected listpack encoded zset")
+	}
+	for i := 0; i < ZsetMaxListpackEntries; i += 1 {
+		zset.AddMember(NewStr(fmt.Sprintf("m%d", i)), int64(i))
+	}
+	if score, _ := zset.GetScore(NewStr("a")); zset.Encoding() != EncodingSkipList || score != 6 {
+		t.Fatalf("zset not converted")
+	}
+
+	list := NewLinkedList()
+	list.Rpush(NewStr("b"))
+	list.Lpush(NewStr("a"))
+	if list.Encoding() != EncodingListpack || list.Rpop().StrVal() != "b" {
+		t.Fatalf("list should be listpack encoded")
+	}
+	list.Rpush(NewStr(strings.Repeat("x", ListMaxListpackValue+1)))
+	if list.Encoding() != EncodingLinkedList || list.Lpop().StrVal() != "a" || list.Len() != 1 {
+		t.Fatalf("list not converted")
+	}
+}
@@ -0,0 +1,223 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"goRedis/service"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvictLru(t *testing.T) {
+	db := NewDatabase()
+	db.SetEvictionPolicy(AllKeysLru)
+	for i := 0; i < 100; i += 1 {
+		db.SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), -1)
+		val, _ := db.GetKey(NewStr(fmt.Sprintf("key%d", i)))
+		val.Lru = LruClock() - 1000
+	}
+	// only key0 is accessed recently
+	db.GetStr(NewStr("key0"))
+	evictor := NewEvictor(10)
+	deleted := make([]string, 0)
+	for i := 0; i < 50; i += 1 {
+		_, key, _ := evictor.Evict([]*Database{db}, AllKeysLru, func(db *Database, key, val *DbObject) {
+			deleted = append(deleted, key.StrVal())
+		})
+		if key == nil || key.StrVal() == "key0" {
+			t.Fatalf("the recently used key should not be evicted")
+		}
+	}
+	if len(deleted) != 50 || db.Size() != 50 {
+		t.Fatalf("unexpected number of keys evicted")
+	}
+}
+
+func TestEvictVolatile(t *testing.T) {
+	db := NewDatabase()
+	now := time.Now().UnixNano()
+	db.SetStr(NewStr("persistent"), NewStr("value"), -1)
+	evictor := NewEvictor(0)
+	if _, key, _ := evictor.Evict([]*Database{db}, VolatileLru, nil); key != nil {
+		t.Fatalf("persistent key evicted by volatile policy")
+	}
+	if _, key, _ := evictor.Evict([]*Database{db}, NoEviction, nil); key != nil {
+		t.Fatalf("key evicted by noeviction")
+	}
+	for i := 1; i <= 10; i += 1 {
+		db.SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), now+int64(i)*int64(time.Hour))
+	}
+	evictor = NewEvictor(10)
+	for i := 0; i < 5; i += 1 {
+		if _, key, _ := evictor.Evict([]*Database{db}, VolatileTtl, nil); key == nil || key.StrVal() == "key10" {
+			t.Fatalf("the key expiring last should not be evicted")
+		}
+	}
+	for i := 0; i < 5; i += 1 {
+		if _, key, _ := evictor.Evict([]*Database{db}, VolatileRandom, nil); key == nil {
+			t.Fatalf("volatile key not evicted")
+		}
+	}
+	if _, key, _ := evictor.Evict([]*Database{db}, VolatileRandom, nil); key != nil || db.Size() != 1 {
+		t.Fatalf("persistent key evicted by volatile policy")
+	}
+}
+
+func TestEvictRandomDatabases(t *testing.T) {
+	dbs := []*Database{NewDatabase(), NewDatabase(), NewDatabase()}
+	for i := 0; i < 10; i += 1 {
+		// db 1 is empty
+		dbs[0].SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), -1)
+		dbs[2].SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), -1)
+	}
+	evictor := NewEvictor(0)
+	for i := 0; i < 10; i += 1 {
+		if db, _, _ := evictor.Evict(dbs, AllKeysRandom, nil); db == nil {
+			t.Fatalf("no key evicted")
+		}
+	}
+	// evictions are spread over databases which are not empty
+	if dbs[0].Size() != 5 || dbs[2].Size() != 5 {
+		t.Fatalf("expected 5 keys evicted from db 0 and db 2, got %d and %d", 10-dbs[0].Size(), 10-dbs[2].Size())
+	}
+}
+
+func TestLfuCounter(t *testing.T) {
+	db := NewDatabase()
+	db.SetEvictionPolicy(AllKeysLfu)
+	db.SetStr(NewStr("hot"), NewStr("value"), -1)
+	for i := 0; i < 50; i += 1 {
+		db.SetStr(NewStr(fmt.Sprintf("cold%d", i)), NewStr("value"), -1)
+	}
+	for i := 0; i < 1000; i += 1 {
+		db.GetStr(NewStr("hot"))
+	}
+	hot, _ := db.GetKey(NewStr("hot"))
+	cold, _ := db.GetKey(NewStr("cold0"))
+	if Frequency(hot) <= Frequency(cold) {
+		t.Fatalf("frequency of hot key should be greater")
+	}
+	evictor := NewEvictor(10)
+	for i := 0; i < 25; i += 1 {
+		if _, key, _ := evictor.Evict([]*Database{db}, AllKeysLfu, nil); key == nil || key.StrVal() == "hot" {
+			t.Fatalf("the frequently used key should not be evicted")
+		}
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	key := NewStr("key")
+	small := EstimateSize(key, NewStr("v"), 0)
+	large := EstimateSize(key, NewStr(string(make([]byte, 1000))), 0)
+	if large-small != 999 {
+		t.Fatalf("size of string value mismatch")
+	}
+	list := NewLinkedList()
+	for i := 0; i < 100; i += 1 {
+		list.Rpush(NewStr("element"))
+	}
+	// all elements have the same size
+	if EstimateSize(key, NewObject(LINKDLIST, list), 5) != EstimateSize(key, NewObject(LINKDLIST, list), 0) {
+		t.Fatalf("sampled size mismatch")
+	}
+}
+
+func TestAccessInfo(t *testing.T) {
+	db := NewDatabase()
+	db.SetStr(NewStr("key"), NewStr("value"), -1)
+	val := db.PeekAlive(NewStr("key"))
+	val.Lru = LruClock() - 100
+	// inspecting a key is not an access
+	if db.PeekAlive(NewStr("key")); IdleTime(val) < 100*1000 || db.UsesLfu() {
+		t.Fatalf("access info updated by peek")
+	}
+	// neither is polling ttl
+	for _, command := range []string{"TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME"} {
+		if reply := service.Handle([]*DbObject{NewStr(command), NewStr("key")}, db, nil); reply != ":-1\r\n" {
+			t.Fatalf("%s: unexpected reply %q", command, reply)
+		}
+	}
+	if IdleTime(val) < 100*1000 {
+		t.Fatalf("access info updated by TTL")
+	}
+	db.GetKey(NewStr("key"))
+	if IdleTime(val) >= 1000 {
+		t.Fatalf("access info not updated")
+	}
+	db.SetStr(NewStr("expired"), NewStr("value"), time.Now().UnixNano()-1)
+	if db.PeekAlive(NewStr("expired")) != nil {
+		t.Fatalf("expired key returned by peek")
+	}
+}
+
+func TestImportMaxmemory(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	// collect garbage often, so that used memory is close to the keys in databases
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	// 32MB of values
+	const keys = 16384
+	path := filepath.Join(t.TempDir(), "import.gdb")
+	source := NewDatabase()
+	for i := 0; i < keys; i += 1 {
+		source.SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr(strings.Repeat("v", 2048)), -1)
+	}
+	if err := persistence.SaveSnapshot(path, []*Database{source}); err != nil {
+		t.Fatal(err)
+	}
+	source = nil
+	runtime.GC()
+
+	// servers share the heap of the test process, keys of the first server are flushed before the second one
+	for i, policy := range []string{AllKeysRandom, NoEviction} {
+		config := newTestConfig(16520+i, t.TempDir())
+		// the heap is also used by servers of other tests
+		config.Maxmemory = stableHeapAlloc() + 24<<20
+		config.MaxmemoryPolicy = policy
+		// IMPORT reads files in the data directory only
+		if err := os.Link(path, filepath.Join(config.Dir, "import.gdb")); err != nil {
+			t.Fatal(err)
+		}
+		s := startTestServer(t, config)
+		reply := s.do(t, []string{"IMPORT", "import.gdb"}, []string{"DBSIZE"}, []string{"FLUSHALL"})
+		size, _ := strconv.Atoi(strings.TrimSpace(reply[1][1:]))
+		if size == 0 || size >= keys {
+			t.Fatalf("%s: %d keys imported with maxmemory", policy, size)
+		}
+		if policy == NoEviction && !strings.HasPrefix(reply[0], "-") {
+			t.Fatalf("IMPORT should be rejected over maxmemory without eviction, got %q", reply[0])
+		}
+		if policy == AllKeysRandom && strings.HasPrefix(reply[0], "-") {
+			t.Fatalf("keys should be evicted when importing, got %q", reply[0])
+		}
+		runtime.GC()
+	}
+}
+
+// stableHeapAlloc
+// heap size after the memory freed in background by servers of other tests is reclaimed
+func stableHeapAlloc() int64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	for i := 0; i < 50; i += 1 {
+		last := int64(stats.HeapAlloc)
+		time.Sleep(100 * time.Millisecond)
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		if last-int64(stats.HeapAlloc) < 1<<20 {
+			break
+		}
+	}
+	return int64(stats.HeapAlloc)
+}
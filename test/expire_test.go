@@ -0,0 +1,68 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	db := NewDatabase()
+	now := time.Now().UnixNano()
+	for i := 0; i < 1000; i += 1 {
+		db.SetKeyObject(NewStr(fmt.Sprintf("expired%d", i)), NewStr("value"), now-1)
+	}
+	for i := 0; i < 100; i += 1 {
+		db.SetKeyObject(NewStr(fmt.Sprintf("alive%d", i)), NewStr("value"), now+int64(time.Hour))
+		db.SetKeyObject(NewStr(fmt.Sprintf("persistent%d", i)), NewStr("value"), -1)
+	}
+	var deleted int64 = 0
+	for i := 0; i < 1000 && db.Size() > 200; i += 1 {
+		deleted += db.ActiveExpireCycle(time.Now().Add(time.Second), func(key *DbObject) {
+			if db.GetExpireTime(key) >= now {
+				t.Fatalf("key %s is not expired", key.StrVal())
+			}
+		})
+	}
+	if db.Size() != 200 || deleted != 1000 || db.ExpiredKeys() != 1000 {
+		t.Fatalf("expired keys remain, size = %d, deleted = %d", db.Size(), deleted)
+	}
+	// nothing to delete
+	if db.ActiveExpireCycle(time.Now().Add(time.Second), nil) != 0 {
+		t.Fatalf("alive key deleted")
+	}
+}
+
+func TestLazyExpirePropagation(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16533, t.TempDir())
+	config.AppendOnly = true
+	// keys are only deleted when they are accessed
+	config.ActiveExpireCpuPercent = 0
+	s := startTestServer(t, config)
+	s.do(t, []string{"SET", "a", "1", "PX", "20"}, []string{"SET", "b", "1", "PX", "20"}, []string{"SET", "c", "1", "PX", "20"})
+	time.Sleep(50 * time.Millisecond)
+	changes := s.info(t, "persistence", "rdb_changes_since_last_save")
+	// a write command applied to nothing, only the DEL of the key expired is written
+	if reply := s.do(t, []string{"SET", "c", "2", "XX"})[0]; reply != "$-1\r\n" {
+		t.Fatalf("SET XX of an expired key: unexpected reply %q", reply)
+	}
+	// read by GET, sampled by RANDOMKEY
+	if replies := s.do(t, []string{"GET", "a"}, []string{"RANDOMKEY"}); replies[0] == "1" || replies[1] != "$-1\r\n" {
+		t.Fatalf("expired keys returned %v", replies)
+	}
+	if after := s.info(t, "persistence", "rdb_changes_since_last_save"); after == changes {
+		t.Fatalf("deleting expired keys should change dirty")
+	}
+	expected := []string{"SET a", "SET b", "SET c", "DEL c", "DEL a", "DEL b"}
+	if commands := appendOnlyCommands(t, config); strings.Join(commands, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected commands %v in AOF, got %v", expected, commands)
+	}
+}
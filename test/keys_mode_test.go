@@ -0,0 +1,158 @@
+package test
+
+import (
+	"fmt"
+	"goRedis/client/cli_core"
+	. "goRedis/db"
+	"goRedis/util"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// findKeys
+// run a keyspace discovery mode of the client against the server, return what it prints
+func findKeys(t *testing.T, port int, mode string) (string, error) {
+	conn, err := net.Dial("tcp", fmt.Sprint("127.0.0.1:", port))
+	if err != nil {
+		t.Fatalf("connect server error: %s", err)
+	}
+	defer conn.Close()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(reader)
+		output <- string(content)
+	}()
+	err = cli_core.FindKeys(conn, mode, time.Millisecond)
+	os.Stdout = stdout
+	writer.Close()
+	return <-output, err
+}
+
+// expectLines
+// every line is printed in output
+func expectLines(t *testing.T, mode, output string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Fatalf("%s: %q not printed in\n%s", mode, line, output)
+		}
+	}
+}
+
+func TestFindBigKeys(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	s := startTestServer(t, newTestConfig(16560, t.TempDir()))
+	// more keys than a KEYSTATS call returns
+	commands := make([][]string, 0)
+	for i := 0; i < 250; i += 1 {
+		commands = append(commands, []string{"SET", fmt.Sprint("key:", i), fmt.Sprint(i)})
+	}
+	commands = append(commands, []string{"SET", "big", strings.Repeat("v", 1000)},
+		[]string{"RPUSH", "short", "a"}, []string{"RPUSH", "short", "b"})
+	for i := 0; i < 300; i += 1 {
+		commands = append(commands, []string{"RPUSH", "list", fmt.Sprint(i)})
+	}
+	for i := 0; i < 40; i += 1 {
+		commands = append(commands, []string{"SADD", "set", fmt.Sprint(i)},
+			[]string{"HSET", "hash", fmt.Sprint("field", i), "v"})
+	}
+	// fewer fields but more memory than hash
+	for i := 0; i < 5; i += 1 {
+		commands = append(commands, []string{"HSET", "fat", fmt.Sprint("field", i), strings.Repeat("v", 2000)})
+	}
+	commands = append(commands, []string{"ZADD", "zset", "1", "a"})
+	for _, reply := range s.do(t, commands...) {
+		if reply[0] == '-' {
+			t.Fatalf("populate error %q", reply)
+		}
+	}
+
+	output, err := findKeys(t, 16560, cli_core.BigKeys)
+	if err != nil {
+		t.Fatalf("bigkeys error: %s", err)
+	}
+	expectLines(t, "bigkeys", output,
+		"# Scanning the entire keyspace to find biggest keys",
+		"Sampled 257 keys in the keyspace!",
+		"Biggest string found 'big' has 1000 bytes",
+		"Biggest list   found 'list' has 300 items",
+		"Biggest set    found 'set' has 40 members",
+		"Biggest hash   found 'hash' has 40 fields",
+		"Biggest zset   found 'zset' has 1 members",
+		"251 strings with 1640 bytes (97.67% of keys, avg size 6.53)",
+		"2 lists with 302 items (0.78% of keys, avg size 151.00)",
+		"2 hashs with 45 fields (0.78% of keys, avg size 22.50)")
+
+	output, err = findKeys(t, 16560, cli_core.MemKeys)
+	if err != nil {
+		t.Fatalf("memkeys error: %s", err)
+	}
+	expectLines(t, "memkeys", output,
+		"# Scanning the entire keyspace to find biggest keys (in bytes of memory)",
+		"Sampled 257 keys in the keyspace!")
+	for _, prefix := range []string{"Biggest string found 'big' has ", "Biggest list   found 'list' has ", "Biggest hash   found 'fat' has "} {
+		if !strings.Contains(output, prefix) {
+			t.Fatalf("memkeys: %q not printed in\n%s", prefix, output)
+		}
+	}
+	if strings.Contains(output, "fields") || !strings.Contains(output, "2 hashs with ") {
+		t.Fatalf("memkeys should report sizes in bytes:\n%s", output)
+	}
+
+	// access frequency is not tracked without an LFU policy
+	if _, err = findKeys(t, 16560, cli_core.HotKeys); err == nil || !strings.Contains(err.Error(), "LFU maxmemory policy is not selected") {
+		t.Fatalf("hotkeys without an LFU policy should fail, got %v", err)
+	}
+}
+
+func TestFindHotKeys(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16561, t.TempDir())
+	config.MaxmemoryPolicy = AllKeysLfu
+	s := startTestServer(t, config)
+	commands := make([][]string, 0)
+	for i := 0; i < 120; i += 1 {
+		commands = append(commands, []string{"SET", fmt.Sprint("key:", i), "value"})
+	}
+	// the counter grows logarithmically with accesses
+	for i := 0; i < 3000; i += 1 {
+		commands = append(commands, []string{"GET", "key:7"})
+		if i < 300 {
+			commands = append(commands, []string{"GET", "key:42"})
+		}
+	}
+	for start := 0; start < len(commands); start += 1000 {
+		s.do(t, commands[start:util.MinInt(start+1000, len(commands))]...)
+	}
+
+	output, err := findKeys(t, 16561, cli_core.HotKeys)
+	if err != nil {
+		t.Fatalf("hotkeys error: %s", err)
+	}
+	expectLines(t, "hotkeys", output, "# Scanning the entire keyspace to find hot keys", "Sampled 120 keys in the keyspace!")
+	hot := make([]string, 0)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "hot key found with counter: ") {
+			hot = append(hot, line)
+		}
+	}
+	// only the hottest keys are reported, in descending order of frequency
+	if len(hot) != 16 {
+		t.Fatalf("expected 16 hot keys, got %d:\n%s", len(hot), output)
+	}
+	if !strings.HasSuffix(hot[0], "\tkeyname: key:7") || !strings.HasSuffix(hot[1], "\tkeyname: key:42") {
+		t.Fatalf("key:7 and key:42 should be the hottest:\n%s", output)
+	}
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/service"
+	"testing"
+	"time"
+)
+
+func TestCopyKey(t *testing.T) {
+	for _, size := range []int{2, 1000} {
+		db := NewDatabase()
+		expireTime := time.Now().UnixNano() + int64(time.Hour)
+		list, _ := db.GetKeyObject(NewStr("list"), LINKDLIST)
+		set, _ := db.GetKeyObject(NewStr("set"), SET)
+		hash, _ := db.GetKeyObject(NewStr("hash"), HASH)
+		zset, _ := db.GetKeyObject(NewStr("zset"), ZSET)
+		for i := 0; i < size; i += 1 {
+			list.Val.(*LinkedList).Rpush(NewStr(fmt.Sprint(i)))
+			set.Val.(*Set).Add(NewStr(fmt.Sprint(i)))
+			hash.Val.(*Hash).Set(NewStr(fmt.Sprint(i)), NewStr("v"))
+			zset.Val.(*Zset).AddMember(NewStr(fmt.Sprint(i)), int64(i%3))
+		}
+		db.SetExpire(NewStr("zset"), expireTime)
+		target := NewDatabase()
+		for _, name := range []string{"list", "set", "hash", "zset"} {
+			if copied, err := db.CopyKey(NewStr(name), target, NewStr(name), false); !copied || err != nil {
+				t.Fatalf("copy %s: copied = %v, err = %v", name, copied, err)
+			}
+		}
+		if got := target.GetExpireTime(NewStr("zset")); got != expireTime {
+			t.Fatalf("expire time is not copied, got %d", got)
+		}
+		_, zsetMembers := zset.Val.(*Zset).Members()
+		// modify the sources, copies stay the same
+		list.Val.(*LinkedList).Lpop()
+		set.Val.(*Set).Remove(NewStr("0"))
+		hash.Val.(*Hash).Set(NewStr("0"), NewStr("changed"))
+		zset.Val.(*Zset).Remove(NewStr("0"))
+		copiedList, _ := target.GetKeyIfExist(NewStr("list"), LINKDLIST)
+		if copiedList.Val.(*LinkedList).Len() != size || Encoding(copiedList) != Encoding(list) {
+			t.Fatalf("list copy changed")
+		}
+		copiedSet, _ := target.GetKeyIfExist(NewStr("set"), SET)
+		if !copiedSet.Val.(*Set).Contains(NewStr("0")) {
+			t.Fatalf("set copy changed")
+		}
+		copiedHash, _ := target.GetKeyIfExist(NewStr("hash"), HASH)
+		if v, _ := copiedHash.Val.(*Hash).Get(NewStr("0")); v == nil || v.StrVal() != "v" {
+			t.Fatalf("hash copy changed")
+		}
+		copiedZset, _ := target.GetKeyIfExist(NewStr("zset"), ZSET)
+		_, members := copiedZset.Val.(*Zset).Members()
+		if len(members) != size {
+			t.Fatalf("zset copy changed")
+		}
+		for i := range members {
+			if members[i].StrVal() != zsetMembers[i].StrVal() {
+				t.Fatalf("zset copy is not in the same order, %s != %s", members[i].StrVal(), zsetMembers[i].StrVal())
+			}
+		}
+		// destination exists
+		if copied, _ := db.CopyKey(NewStr("list"), target, NewStr("set"), false); copied {
+			t.Fatalf("destination should not be replaced without REPLACE")
+		}
+		if copied, _ := db.CopyKey(NewStr("list"), target, NewStr("set"), true); !copied {
+			t.Fatalf("destination should be replaced with REPLACE")
+		}
+		if val, _ := target.GetKey(NewStr("set")); val.Type != LINKDLIST {
+			t.Fatalf("destination is not replaced")
+		}
+	}
+}
+
+func TestGenericKeyCommands(t *testing.T) {
+	db := NewDatabase()
+	db.SetStr(NewStr("a"), NewStr("1"), -1)
+	db.SetStr(NewStr("expired"), NewStr("1"), time.Now().UnixNano()-1)
+	db.GetKeyObject(NewStr("h"), HASH)
+	handle := func(args ...string) string {
+		objs := make([]*DbObject, 0, len(args))
+		for _, arg := range args {
+			objs = append(objs, NewStr(arg))
+		}
+		return service.Handle(objs, db, nil)
+	}
+	for key, expected := range map[string]string{"a": "+string\r\n", "h": "+hash\r\n", "expired": "+none\r\n", "none": "+none\r\n"} {
+		if reply := handle("TYPE", key); reply != expected {
+			t.Fatalf("TYPE %s: expected %q, got %q", key, expected, reply)
+		}
+	}
+	if reply := handle("EXISTS", "a", "h", "a", "expired", "none"); reply != ":3\r\n" {
+		t.Fatalf("EXISTS: unexpected reply %q", reply)
+	}
+	for i := 0; i < 10; i += 1 {
+		if reply := handle("RANDOMKEY"); reply != "$1\r\na\r\n" && reply != "$1\r\nh\r\n" {
+			t.Fatalf("RANDOMKEY: unexpected reply %q", reply)
+		}
+	}
+
+	if renamed, _ := db.RenameKeyIfNotExist(NewStr("a"), NewStr("h")); renamed {
+		t.Fatalf("a should not be renamed to an existing key")
+	}
+	if renamed, err := db.RenameKeyIfNotExist(NewStr("a"), NewStr("b")); !renamed || err != nil {
+		t.Fatalf("rename a to b: renamed = %v, err = %v", renamed, err)
+	}
+	if _, err := db.RenameKeyIfNotExist(NewStr("a"), NewStr("c")); err == nil {
+		t.Fatalf("a key not exists should not be renamed")
+	}
+	if reply := handle("RANDOMKEY"); reply != "$1\r\nb\r\n" && reply != "$1\r\nh\r\n" {
+		t.Fatalf("RANDOMKEY: unexpected reply %q", reply)
+	}
+}
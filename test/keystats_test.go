@@ -0,0 +1,56 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/service"
+	"strings"
+	"testing"
+)
+
+// parseBulkArray
+// elements of a bulk array reply
+func parseBulkArray(t *testing.T, reply string) []string {
+	lines := strings.Split(strings.TrimSuffix(reply, "\r\n"), "\r\n")
+	if !strings.HasPrefix(lines[0], "*") {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	result := make([]string, 0)
+	for i := 2; i < len(lines); i += 2 {
+		result = append(result, lines[i])
+	}
+	return result
+}
+
+func TestKeystats(t *testing.T) {
+	db := NewDatabase()
+	for i := 0; i < 1000; i += 1 {
+		db.SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), -1)
+	}
+	hash, _ := db.GetKeyObject(NewStr("hash"), HASH)
+	hash.Val.(*Hash).Set(NewStr("field"), NewStr("value"))
+	seen := make(map[string]int, 0)
+	cursor := "0"
+	for {
+		reply := parseBulkArray(t, service.Handle([]*DbObject{NewStr("KEYSTATS"), NewStr(cursor), NewStr("COUNT"), NewStr("7")}, db, nil))
+		cursor = reply[0]
+		for i := 1; i < len(reply); i += 5 {
+			seen[reply[i]] += 1
+			if reply[i] == "hash" && (reply[i+1] != "hash" || reply[i+2] != "1") {
+				t.Fatalf("unexpected stats of hash %v", reply[i:i+5])
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 1001 {
+		t.Fatalf("%d keys walked", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s walked %d times", key, n)
+		}
+	}
+}
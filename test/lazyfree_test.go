@@ -0,0 +1,108 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"testing"
+	"time"
+)
+
+// waitFreed wait until all values are freed in background
+func waitFreed(t *testing.T, lazyfree *Lazyfree) {
+	for i := 0; lazyfree.PendingObjects() > 0; i += 1 {
+		if i > 1000 {
+			t.Fatalf("values not freed in background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	db := NewDatabase()
+	lazyfree := NewLazyfree(LazyfreeOptions{})
+	db.SetLazyfree(lazyfree)
+	set, _ := db.GetKeyObject(NewStr("set"), SET)
+	for i := 0; i < 1000; i += 1 {
+		set.Val.(*Set).Add(NewStr(fmt.Sprintf("member%d", i)))
+	}
+	db.SetStr(NewStr("str"), NewStr("value"), -1)
+	if db.UnlinkKey(NewStr("set")) != nil || db.UnlinkKey(NewStr("set")) == nil {
+		t.Fatalf("unlink failed")
+	}
+	// small values are freed inline
+	db.RemoveKey(NewStr("str"))
+	waitFreed(t, lazyfree)
+	if lazyfree.FreedObjects() != 2 || lazyfree.TakeFreedBytes() < 1000*(ObjectSize+DictEntrySize) || lazyfree.TakeFreedBytes() != 0 {
+		t.Fatalf("freed values mismatch")
+	}
+}
+
+func TestFlushAsync(t *testing.T) {
+	db := NewDatabase()
+	lazyfree := NewLazyfree(LazyfreeOptions{LazyExpire: true})
+	db.SetLazyfree(lazyfree)
+	for i := 0; i < 1000; i += 1 {
+		db.SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), time.Now().Add(time.Hour).UnixNano())
+	}
+	if db.Flush(true) != 1000 || db.Size() != 0 || db.GetExpireTime(NewStr("key0")) != -1 {
+		t.Fatalf("flush failed")
+	}
+	waitFreed(t, lazyfree)
+	// data dict and expire dict
+	if lazyfree.FreedObjects() != 2 {
+		t.Fatalf("flushed dicts not freed")
+	}
+	// expired keys are freed by lazyfree too
+	db.SetStr(NewStr("expired"), NewStr("value"), time.Now().UnixNano()-1)
+	if _, err := db.GetStr(NewStr("expired")); err == nil || lazyfree.FreedObjects() != 3 {
+		t.Fatalf("expired key not freed")
+	}
+}
+
+func TestLazyfreeDismantle(t *testing.T) {
+	db := NewDatabase()
+	lazyfree := NewLazyfree(LazyfreeOptions{})
+	db.SetLazyfree(lazyfree)
+	values := make([]*DbObject, 0)
+	for _, typ := range []DbObjectType{LINKDLIST, SET, HASH, ZSET} {
+		val, _ := db.GetKeyObject(NewStr(fmt.Sprint("key", typ)), typ)
+		for i := 0; i < 5000; i += 1 {
+			member := NewStr(fmt.Sprintf("member%d", i))
+			switch v := val.Val.(type) {
+			case *LinkedList:
+				v.Rpush(member)
+			case *Set:
+				v.Add(member)
+			case *Hash:
+				v.Set(member, NewStr("value"))
+			case *Zset:
+				v.AddMember(member, int64(i))
+			}
+		}
+		values = append(values, val)
+	}
+	// the sampled estimate is reported whether a value is freed inline or in background
+	var expected int64 = 0
+	for _, val := range values {
+		expected += ValueSize(val, DefaultSizeSamples)
+	}
+	for _, typ := range []DbObjectType{LINKDLIST, SET, HASH, ZSET} {
+		if db.UnlinkKey(NewStr(fmt.Sprint("key", typ))) != nil {
+			t.Fatalf("unlink failed")
+		}
+	}
+	waitFreed(t, lazyfree)
+	if freed := lazyfree.TakeFreedBytes(); freed != expected {
+		t.Fatalf("freed %d bytes, expected %d", freed, expected)
+	}
+	// elements are unlinked by the goroutine
+	for _, val := range values {
+		if FreeEffort(val) != 0 {
+			t.Fatalf("%v value is not dismantled", val.Type)
+		}
+	}
+	if members, _ := values[3].Val.(*Zset).Members(); len(members) != 0 {
+		t.Fatalf("skip list is not dismantled")
+	}
+}
@@ -0,0 +1,46 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"goRedis/persistence"
+	"strings"
+	"testing"
+)
+
+func TestAofManifest(t *testing.T) {
+	manifest := persistence.NewAofManifest("appendonly.aof")
+	if manifest.NextBase().Name != "appendonly.aof.1.base.gdb" || manifest.NextIncr().Name != "appendonly.aof.1.incr.aof" {
+		t.Fatalf("unexpected file names")
+	}
+	manifest.Base = manifest.NextBase()
+	manifest.Incrs = append(manifest.Incrs, manifest.NextIncr())
+	manifest.Incrs = append(manifest.Incrs, manifest.NextIncr())
+	buf := &bytes.Buffer{}
+	if err := manifest.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := persistence.ReadAofManifest("appendonly.aof", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Base.Seq != 1 || len(decoded.Incrs) != 2 || decoded.LastIncr().Name != "appendonly.aof.2.incr.aof" {
+		t.Fatalf("manifest mismatch")
+	}
+	if len(decoded.Files()) != 3 {
+		t.Fatalf("files mismatch")
+	}
+
+	corrupted := []string{
+		"file appendonly.aof.1.base.gdb seq 1 type b\nfile appendonly.aof.2.base.gdb seq 2 type b\n",
+		"file appendonly.aof.2.incr.aof seq 2 type i\nfile appendonly.aof.1.incr.aof seq 1 type i\n",
+		"file ../appendonly.aof.1.incr.aof seq 1 type i\n",
+		"file appendonly.aof.1.incr.aof seq x type i\n",
+		"file appendonly.aof.1.incr.aof seq 1 type z\n",
+	}
+	for _, content := range corrupted {
+		if _, err := persistence.ReadAofManifest("appendonly.aof", strings.NewReader(content)); !errors.Is(err, persistence.ErrorManifestCorrupted) {
+			t.Fatalf("corrupted manifest accepted: %q", content)
+		}
+	}
+}
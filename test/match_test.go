@@ -0,0 +1,74 @@
+package test
+
+import (
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/service"
+	"goRedis/util"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		matched    bool
+	}{
+		{"*", "", true},
+		{"", "", true},
+		{"", "a", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hllo", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[\\]]llo", "h]llo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"user:*:name", "user:1:name", true},
+		{"a*b", "acbd", false},
+		{"*b*", "abc", true},
+		{"a\\", "a\\", true},
+		{"a[bc", "ac", true},
+		// binary safe
+		{"a?c", "a\x00c", true},
+		{"\x00*\xff", "\x00abc\xff", true},
+		{"[\x00-\x01]", "\x01", true},
+	}
+	for _, c := range cases {
+		if util.StringMatch(c.pattern, c.s) != c.matched {
+			t.Fatalf("match %q with %q should be %v", c.pattern, c.s, c.matched)
+		}
+	}
+}
+
+func TestStringMatchBacktracking(t *testing.T) {
+	pattern := util.CompileGlob(strings.Repeat("a*", 50) + "b")
+	s := strings.Repeat("a", 10000)
+	start := time.Now()
+	if pattern.Match(s) {
+		t.Fatalf("pattern should not match")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("pathological pattern takes %s", time.Since(start))
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	db := NewDatabase()
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		db.SetStr(NewStr(key), NewStr("v"), -1)
+	}
+	db.SetStr(NewStr("user:expired"), NewStr("v"), time.Now().UnixNano()-1)
+	keys := parseBulkArray(t, service.Handle([]*DbObject{NewStr("KEYS"), NewStr("user:*")}, db, nil))
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "user:1,user:2" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
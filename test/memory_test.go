@@ -0,0 +1,35 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"testing"
+	"time"
+)
+
+func TestDatabaseOverhead(t *testing.T) {
+	db := NewDatabase()
+	main, expires := db.Overhead()
+	for i := 0; i < 100; i += 1 {
+		db.SetStr(NewStr(fmt.Sprintf("key%d", i)), NewStr("value"), -1)
+	}
+	db.SetExpire(NewStr("key0"), time.Now().Add(time.Hour).UnixNano())
+	newMain, newExpires := db.Overhead()
+	if newMain-main < 100*DictEntrySize || newExpires-expires < DictEntrySize {
+		t.Fatalf("entries are not counted in overhead")
+	}
+}
+
+func TestSkipListMemoryUsage(t *testing.T) {
+	defer func(entries int) { ZsetMaxListpackEntries = entries }(ZsetMaxListpackEntries)
+	ZsetMaxListpackEntries = 0
+	zset := NewZset()
+	for i := 0; i < 10; i += 1 {
+		zset.AddMember(NewStr(fmt.Sprintf("member%d", i)), int64(i))
+	}
+	// every node holds a fixed array of 20 pointers
+	if zset.MemoryUsage() < 11*SkipListNodeSize || SkipListNodeSize < 20*8 {
+		t.Fatalf("size of skip list nodes mismatch")
+	}
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"goRedis/core"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a hand-made RDB 11 file, strings are shorter than 64 bytes
+
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func listpack(entries ...[]byte) []byte {
+	buf := []byte{0, 0, 0, 0, byte(len(entries)), 0}
+	for _, entry := range entries {
+		buf = append(buf, entry...)
+	}
+	buf = append(buf, 0xFF)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	return buf
+}
+
+func listpackString(s string) []byte {
+	entry := append([]byte{0x80 | byte(len(s))}, s...)
+	return append(entry, byte(len(entry)))
+}
+
+func newRdb() *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("REDIS0011")
+	buffer.WriteByte(0xFA)
+	buffer.Write(rdbString("redis-ver"))
+	buffer.Write(rdbString("7.2.4"))
+	buffer.Write([]byte{0xFE, 0x00, 0xFB, 0x09, 0x01})
+	return buffer
+}
+
+func TestReadRdb(t *testing.T) {
+	buffer := newRdb()
+	// plain, int16 and LZF encoded strings
+	buffer.WriteByte(0x00)
+	buffer.Write(rdbString("str"))
+	buffer.Write(rdbString("hello"))
+	buffer.WriteByte(0x00)
+	buffer.Write(rdbString("int"))
+	buffer.Write([]byte{0xC1, 0xD2, 0x04})
+	buffer.WriteByte(0x00)
+	buffer.Write(rdbString("lzf"))
+	buffer.Write([]byte{0xC3, 0x07, 0x0F, 0x02, 'a', 'b', 'c', 0xE0, 0x03, 0x02})
+	// expire time in ms
+	buffer.WriteByte(0xFC)
+	binary.Write(buffer, binary.LittleEndian, uint64(4102444800000))
+	buffer.WriteByte(0x00)
+	buffer.Write(rdbString("expire"))
+	buffer.Write(rdbString("v"))
+	// quicklist 2 with a listpack node: "a", 5, -3
+	lp := listpack(listpackString("a"), []byte{0x05, 0x01}, []byte{0xDF, 0xFD, 0x02})
+	buffer.WriteByte(18)
+	buffer.Write(rdbString("list"))
+	buffer.Write([]byte{0x01, 0x02})
+	buffer.Write(rdbString(string(lp)))
+	// intset of int16: 1, -2
+	buffer.WriteByte(11)
+	buffer.Write(rdbString("intset"))
+	buffer.Write(rdbString(string([]byte{2, 0, 0, 0, 2, 0, 0, 0, 0xFE, 0xFF, 0x01, 0x00})))
+	// listpack hash and zset
+	buffer.WriteByte(16)
+	buffer.Write(rdbString("hash"))
+	buffer.Write(rdbString(string(listpack(listpackString("field"), listpackString("value")))))
+	buffer.WriteByte(17)
+	buffer.Write(rdbString("zset"))
+	buffer.Write(rdbString(string(listpack(listpackString("m1"), []byte{0x0A, 0x01}))))
+	// zset 2 with binary double score
+	buffer.WriteByte(5)
+	buffer.Write(rdbString("zset2"))
+	buffer.Write([]byte{0x01})
+	buffer.Write(rdbString("m2"))
+	binary.Write(buffer, binary.LittleEndian, math.Float64bits(3))
+	// checksum disabled
+	buffer.Write([]byte{0xFF, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	values := make(map[string]*DbObject)
+	expires := make(map[string]int64)
+	err := persistence.ReadRdb(bytes.NewReader(buffer.Bytes()), func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		values[key.StrVal()] = val
+		expires[key.StrVal()] = expireTime
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["str"].StrVal() != "hello" || values["int"].StrVal() != "1234" || values["lzf"].StrVal() != "abcabcabcabcabc" {
+		t.Fatalf("string mismatch")
+	}
+	if expires["expire"] != 4102444800000*1000000 || expires["str"] != -1 {
+		t.Fatalf("expire time mismatch")
+	}
+	members := values["list"].Val.(*LinkedList).Members()
+	if len(members) != 3 || members[0].StrVal() != "a" || members[1].StrVal() != "5" || members[2].StrVal() != "-3" {
+		t.Fatalf("list mismatch")
+	}
+	if values["intset"].Val.(*Set).Length() != 2 {
+		t.Fatalf("intset mismatch")
+	}
+	if value, _ := values["hash"].Val.(*Hash).Get(NewStr("field")); value == nil || value.StrVal() != "value" {
+		t.Fatalf("hash mismatch")
+	}
+	if score, _ := values["zset"].Val.(*Zset).GetScore(NewStr("m1")); score != 10 {
+		t.Fatalf("zset mismatch")
+	}
+	if score, _ := values["zset2"].Val.(*Zset).GetScore(NewStr("m2")); score != 3 {
+		t.Fatalf("zset 2 mismatch")
+	}
+}
+
+func TestReadRdbErrors(t *testing.T) {
+	handler := func(dbIndex int, key, val *DbObject, expireTime int64) error { return nil }
+	// stream is reported
+	buffer := newRdb()
+	buffer.WriteByte(15)
+	buffer.Write(rdbString("stream"))
+	if err := persistence.ReadRdb(bytes.NewReader(buffer.Bytes()), handler); !errors.Is(err, persistence.ErrorUnsupportedDataType) {
+		t.Fatalf("expected unsupported data type, got %v", err)
+	}
+	// wrong checksum
+	buffer = newRdb()
+	buffer.Write([]byte{0xFF, 1, 2, 3, 4, 5, 6, 7, 8})
+	if err := persistence.ReadRdb(bytes.NewReader(buffer.Bytes()), handler); err != persistence.ErrorChecksumMismatch {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	// newer version
+	if err := persistence.ReadRdb(bytes.NewReader([]byte("REDIS0099")), handler); !errors.Is(err, persistence.ErrorUnsupportedVersion) {
+		t.Fatalf("expected unsupported version, got %v", err)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16570, t.TempDir())
+	buffer := newRdb()
+	buffer.WriteByte(0x00)
+	buffer.Write(rdbString("imported"))
+	buffer.Write(rdbString("value"))
+	// checksum disabled
+	buffer.Write([]byte{0xFF, 0, 0, 0, 0, 0, 0, 0, 0})
+	path := filepath.Join(config.Dir, "dump.rdb")
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the size is checked before the file is read, a sparse file is enough
+	large, err := os.Create(filepath.Join(config.Dir, "large.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = large.Truncate(core.ImportMaxFileSize + 1); err != nil {
+		t.Fatal(err)
+	}
+	large.Close()
+	s := startTestServer(t, config)
+	// only files in the data directory can be imported
+	for _, name := range []string{path, "../" + filepath.Base(config.Dir) + "/dump.rdb", "sub/../dump.rdb", ""} {
+		if reply := s.do(t, []string{"IMPORT", name})[0]; !strings.Contains(reply, core.ErrorImportPath.Error()) {
+			t.Fatalf("IMPORT %q should be rejected, got %q", name, reply)
+		}
+	}
+	if reply := s.do(t, []string{"IMPORT", "large.rdb"})[0]; !strings.Contains(reply, core.ErrorImportTooLarge.Error()) {
+		t.Fatalf("IMPORT of a large file should be rejected, got %q", reply)
+	}
+	if reply := s.do(t, []string{"IMPORT", "dump.rdb"})[0]; reply != ":1\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"goRedis/core"
+	. "goRedis/data_structure"
+	"goRedis/persistence"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// keys loaded before rewriting, the rewrite takes many steps of the event loop
+const rewriteKeys int = 100000
+
+// writeRewriteDataset
+// write keys to the single append only file of config, loaded and upgraded by the server,
+// return the dataset of db 0 and db 1 expected
+func writeRewriteDataset(t *testing.T, config *core.Config) (map[string]string, map[string]string) {
+	expected := make(map[string]string)
+	buffer := &bytes.Buffer{}
+	for i := 0; i < rewriteKeys; i += 1 {
+		key, value := fmt.Sprintf("key:%d", i), fmt.Sprintf("value:%d", i)
+		expected[key] = value
+		buffer.Write(persistence.EncodeCommand([]*DbObject{NewStr("SET"), NewStr(key), NewStr(value)}))
+	}
+	buffer.Write(persistence.EncodeCommand([]*DbObject{NewStr("SELECT"), NewStr("1")}))
+	buffer.Write(persistence.EncodeCommand([]*DbObject{NewStr("SET"), NewStr("db1"), NewStr("before")}))
+	if err := os.WriteFile(filepath.Join(config.Dir, config.AppendFileName), buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return expected, map[string]string{"db1": "before"}
+}
+
+// writeDuringRewrite
+// modify keys on both sides of the rewrite cursor, in db 0 and db 1
+func writeDuringRewrite(t *testing.T, s *testServer, expected, expected1 map[string]string, round int) {
+	commands := [][]string{{"SELECT", "1"}, {"SET", "db1", fmt.Sprint("round", round)}, {"SELECT", "0"}}
+	expected1["db1"] = fmt.Sprint("round", round)
+	for i := round; i < rewriteKeys; i += rewriteKeys / 10 {
+		key := fmt.Sprintf("key:%d", i)
+		if i%2 == 0 {
+			commands = append(commands, []string{"DEL", key})
+			delete(expected, key)
+		} else {
+			commands = append(commands, []string{"SET", key, "changed"})
+			expected[key] = "changed"
+		}
+		added := fmt.Sprintf("added:%d:%d", round, i)
+		commands = append(commands, []string{"SET", added, "new"})
+		expected[added] = "new"
+	}
+	for _, reply := range s.do(t, commands...) {
+		if reply[0] == '-' {
+			t.Fatalf("write during rewrite error %q", reply)
+		}
+	}
+}
+
+// checkReloaded
+// copy the append only files and start a new server from them, the dataset should be expected
+func checkReloaded(t *testing.T, config *core.Config, port int, expected, expected1 map[string]string) {
+	dir := t.TempDir()
+	appendDir := filepath.Join(dir, config.AppendDirName)
+	if err := os.Mkdir(appendDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(config.Dir, config.AppendDirName, "*"))
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(appendDir, filepath.Base(path)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reloadConfig := *config
+	reloadConfig.Port = port
+	reloadConfig.Dir = dir
+	// the event loop of the reloaded server is not started, its databases are read directly
+	server, err := core.NewServer(&reloadConfig)
+	if err != nil {
+		t.Fatalf("reload error: %s", err)
+	}
+	t.Cleanup(server.Shutdown)
+	for index, keys := range []map[string]string{expected, expected1} {
+		db := server.Dbs[index]
+		if db.Size() != int64(len(keys)) {
+			t.Fatalf("db %d: expected %d keys, got %d", index, len(keys), db.Size())
+		}
+		for key, value := range keys {
+			if str, _ := db.GetStr(NewStr(key)); str == nil || str.StrVal() != value {
+				t.Fatalf("db %d: %s should be %s, got %v", index, key, value, str)
+			}
+		}
+	}
+}
+
+func TestBgRewriteAof(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16500, t.TempDir())
+	config.AppendOnly = true
+	config.AutoAofRewritePercentage = 0
+	expected, expected1 := writeRewriteDataset(t, config)
+	s := startTestServer(t, config)
+
+	// commands sent with BGREWRITEAOF are read at once and processed before the time event of its
+	// first step, so the writes are applied while rewriting is in progress
+	replies := s.do(t, []string{"BGREWRITEAOF"}, []string{"SET", "key:1", "first"}, []string{"DEL", "key:4"},
+		[]string{"BGREWRITEAOF"}, []string{"INFO", "persistence"})
+	if replies[0][0] == '-' {
+		t.Fatalf("BGREWRITEAOF error %q", replies[0])
+	}
+	expected["key:1"] = "first"
+	delete(expected, "key:4")
+	if replies[3][0] != '-' {
+		t.Fatalf("BGREWRITEAOF should be rejected when a rewrite is in progress")
+	}
+	if infoField(t, replies[4], "aof_rewrite_in_progress") != "1" {
+		t.Fatalf("rewrite of %d keys is finished before its first step", rewriteKeys)
+	}
+	// the other writes may run between steps or after rewriting
+	for round := 0; round < 3; round += 1 {
+		time.Sleep(5 * time.Millisecond)
+		writeDuringRewrite(t, s, expected, expected1, round)
+	}
+	s.waitInfo(t, "persistence", "aof_rewrite_in_progress", "0", 30*time.Second)
+	// the base and incr files before rewriting are replaced by the new base
+	if incrs := s.info(t, "persistence", "aof_incr_files"); incrs != "1" {
+		t.Fatalf("expected 1 incr file after rewriting, got %s", incrs)
+	}
+	if files, _ := filepath.Glob(filepath.Join(config.Dir, config.AppendDirName, "*")); len(files) != 3 {
+		t.Fatalf("expected manifest, base and incr files, got %v", files)
+	}
+	writeDuringRewrite(t, s, expected, expected1, 3)
+	checkReloaded(t, config, 16501, expected, expected1)
+}
+
+func TestAbortedBgRewriteAof(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16502, t.TempDir())
+	config.AppendOnly = true
+	config.AutoAofRewritePercentage = 0
+	expected, expected1 := writeRewriteDataset(t, config)
+	s := startTestServer(t, config)
+
+	s.do(t, []string{"BGREWRITEAOF"})
+	// the new base can not be renamed when rewriting finishes
+	temps, _ := filepath.Glob(filepath.Join(config.Dir, config.AppendDirName, "temp-rewriteaof-*"))
+	if len(temps) != 1 {
+		t.Fatalf("expected a temp file of rewriting, got %v", temps)
+	}
+	if err := os.Remove(temps[0]); err != nil {
+		t.Fatal(err)
+	}
+	writeDuringRewrite(t, s, expected, expected1, 0)
+	s.waitInfo(t, "persistence", "aof_rewrite_in_progress", "0", 30*time.Second)
+	// the old files are kept, writes during rewriting are in the new incr file
+	if incrs := s.info(t, "persistence", "aof_incr_files"); incrs != "2" {
+		t.Fatalf("expected 2 incr files after an aborted rewrite, got %s", incrs)
+	}
+	writeDuringRewrite(t, s, expected, expected1, 1)
+	checkReloaded(t, config, 16503, expected, expected1)
+}
+
+func TestAutoAofRewrite(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	config := newTestConfig(16504, t.TempDir())
+	config.AppendOnly = true
+	config.AutoAofRewritePercentage = 100
+	config.AutoAofRewriteMinSize = 4 << 10
+	s := startTestServer(t, config)
+	// an empty dataset has no base file
+	if size := s.info(t, "persistence", "aof_base_file_size"); size != "0" {
+		t.Fatalf("unexpected base file size %s", size)
+	}
+	commands := make([][]string, 0)
+	for i := 0; i < 100; i += 1 {
+		commands = append(commands, []string{"SET", fmt.Sprintf("key:%d", i), fmt.Sprintf("%064d", i)})
+	}
+	s.do(t, commands...)
+	// the cron rewrites the AOF grown over the min size
+	deadline := time.Now().Add(5 * time.Second)
+	for s.info(t, "persistence", "aof_base_file_size") == "0" || s.info(t, "persistence", "aof_rewrite_in_progress") != "0" {
+		if time.Now().After(deadline) {
+			t.Fatalf("AOF is not rewritten automatically")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if incrs := s.info(t, "persistence", "aof_incr_files"); incrs != "1" {
+		t.Fatalf("expected 1 incr file after rewriting, got %s", incrs)
+	}
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// scanAll
+// scan dict until finished, call between before every Scan call
+func scanAll(dict *Dict, between func(step int)) map[string]int {
+	seen := make(map[string]int, 0)
+	var cursor uint64 = 0
+	for step := 0; ; step += 1 {
+		between(step)
+		cursor = dict.Scan(cursor, func(key, val *DbObject) {
+			seen[key.StrVal()] += 1
+		})
+		if cursor == 0 {
+			return seen
+		}
+	}
+}
+
+func TestDictScanWhileResizing(t *testing.T) {
+	dict := NewDict(StrHash, StrEqual)
+	for i := 0; i < 1000; i += 1 {
+		dict.Set(NewStr(strconv.Itoa(i)), NewStr("v"))
+	}
+	// grow while scanning
+	seen := scanAll(dict, func(step int) {
+		for i := 0; i < 20 && step < 100; i += 1 {
+			dict.Set(NewStr(fmt.Sprintf("new%d-%d", step, i)), NewStr("v"))
+		}
+	})
+	for i := 0; i < 1000; i += 1 {
+		if seen[strconv.Itoa(i)] == 0 {
+			t.Fatalf("key %d missed while growing", i)
+		}
+	}
+	// shrink while scanning, keys 0-99 are kept
+	deleted := make([]*DbObject, 0)
+	for key := range seen {
+		if n, err := strconv.Atoi(key); err != nil || n >= 100 {
+			deleted = append(deleted, NewStr(key))
+		}
+	}
+	seen = scanAll(dict, func(step int) {
+		if step == 10 {
+			for _, key := range deleted {
+				dict.Delete(key)
+			}
+		}
+		dict.RehashFor(time.Microsecond)
+	})
+	for i := 0; i < 100; i += 1 {
+		if seen[strconv.Itoa(i)] == 0 {
+			t.Fatalf("key %d missed while shrinking", i)
+		}
+	}
+}
+
+func TestScanEncodings(t *testing.T) {
+	hash := NewHash()
+	set := NewSet()
+	zset := NewZset()
+	for i := 0; i < 5; i += 1 {
+		hash.Set(NewStr(fmt.Sprintf("f%d", i)), NewStr("v"))
+		set.Add(NewObjectByInt(int64(i)))
+		zset.AddMember(NewStr(fmt.Sprintf("m%d", i)), int64(i))
+	}
+	n := 0
+	cursor := hash.Scan(0, 1, func(field, value *DbObject) { n += 1 })
+	cursor |= set.Scan(0, 1, func(member *DbObject) { n += 1 })
+	cursor |= zset.Scan(0, 1, func(member, score *DbObject) { n += 1 })
+	if cursor != 0 || n != 15 {
+		t.Fatalf("compact encoded values should be scanned in one call, %d returned", n)
+	}
+	for i := 0; i < SetMaxIntsetEntries; i += 1 {
+		set.Add(NewStr(fmt.Sprintf("member%d", i)))
+	}
+	seen := make(map[string]bool, 0)
+	cursor = 0
+	for {
+		cursor = set.Scan(cursor, 10, func(member *DbObject) { seen[member.StrVal()] = true })
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != set.Length() {
+		t.Fatalf("%d of %d members scanned", len(seen), set.Length())
+	}
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"goRedis/core"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"goRedis/service"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer
+// service.Server of a single database, counts changes like core.Server
+// every command handled by it starts at the time it is created
+type fakeServer struct {
+	db    *Database
+	dirty int64
+	time  int64
+}
+
+func newFakeServer(db *Database) *fakeServer {
+	return &fakeServer{db: db, time: time.Now().UnixNano() / int64(time.Millisecond) * int64(time.Millisecond)}
+}
+
+func (*fakeServer) Save() error                               { return nil }
+func (*fakeServer) BgSave() error                             { return nil }
+func (*fakeServer) BgRewriteAof() error                       { return nil }
+func (server *fakeServer) IncrDirty(delta int64)              { server.dirty += delta }
+func (*fakeServer) LastSave() int64                           { return 0 }
+func (*fakeServer) Info(section string) string                { return "" }
+func (*fakeServer) Import(path string) (int64, error)         { return 0, errors.New("not supported") }
+func (*fakeServer) MemoryStats() []string                     { return nil }
+func (*fakeServer) BeforeWriteKey(dbIndex int, key *DbObject) {}
+func (server *fakeServer) Databases() []*Database             { return []*Database{server.db} }
+func (server *fakeServer) CommandTime() int64                 { return server.time }
+func (*fakeServer) SelectDb(index int) error {
+	if index != 0 {
+		return errors.New("DB index is out of range")
+	}
+	return nil
+}
+
+// handle
+// run a command on the database of server
+func (server *fakeServer) handle(args ...string) string {
+	objs := make([]*DbObject, 0, len(args))
+	for _, arg := range args {
+		objs = append(objs, NewStr(arg))
+	}
+	return service.Handle(objs, server.db, server)
+}
+
+// testServer
+// a core.Server running its event loop in background, commands are sent through a connection,
+// the server is shut down when the test finishes
+type testServer struct {
+	server *core.Server
+	config *core.Config
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestConfig
+// default config of a server on port with data in dir
+func newTestConfig(port int, dir string) *core.Config {
+	config := core.LoadConfig("")
+	config.Port = port
+	config.Dir = dir
+	config.Save = nil
+	// commands are pipelined in batches
+	config.MaxQueryLength = 1 << 20
+	return config
+}
+
+func startTestServer(t *testing.T, config *core.Config) *testServer {
+	server, err := core.NewServer(config)
+	if err != nil {
+		t.Fatalf("new server error: %s", err)
+	}
+	server.Loop.AddFileEvent(server.Fd, core.READABLE, core.AcceptHandler, nil)
+	server.Loop.AddTimeEvent(core.NORMAL, core.CronInterval, core.ServerCron, nil)
+	go server.Loop.AeMain()
+	// the port is reused by later tests, the listening socket must be closed before temp dirs are removed
+	t.Cleanup(server.Shutdown)
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", config.Port))
+	if err != nil {
+		t.Fatalf("connect server error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &testServer{server: server, config: config, conn: conn, reader: bufio.NewReader(conn)}
+	// greeting
+	s.receive(t)
+	return s
+}
+
+// do
+// send commands in a pipeline, return their replies, the content of a bulk string
+// or the line of other replies
+func (s *testServer) do(t *testing.T, commands ...[]string) []string {
+	buffer := &bytes.Buffer{}
+	for _, command := range commands {
+		args := make([]*DbObject, 0, len(command))
+		for _, arg := range command {
+			args = append(args, NewStr(arg))
+		}
+		buffer.Write(persistence.EncodeCommand(args))
+	}
+	if _, err := s.conn.Write(buffer.Bytes()); err != nil {
+		t.Fatalf("send commands error: %s", err)
+	}
+	replies := make([]string, 0, len(commands))
+	for range commands {
+		replies = append(replies, s.receive(t))
+	}
+	return replies
+}
+
+func (s *testServer) receive(t *testing.T) string {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("receive reply error: %s", err)
+	}
+	if line[0] != '$' {
+		return line
+	}
+	length, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if length < 0 {
+		return line
+	}
+	buf := make([]byte, length+2)
+	if _, err = io.ReadFull(s.reader, buf); err != nil {
+		t.Fatalf("receive reply error: %s", err)
+	}
+	return string(buf[:length])
+}
+
+// info
+// value of field in INFO section
+func (s *testServer) info(t *testing.T, section, field string) string {
+	return infoField(t, s.do(t, []string{"INFO", section})[0], field)
+}
+
+// infoField
+// value of field in the reply of INFO
+func infoField(t *testing.T, reply, field string) string {
+	for _, line := range strings.Split(reply, "\r\n") {
+		if strings.HasPrefix(line, field+":") {
+			return line[len(field)+1:]
+		}
+	}
+	t.Fatalf("field %s not found in INFO reply", field)
+	return ""
+}
+
+// waitInfo
+// wait until field of INFO section is value
+func (s *testServer) waitInfo(t *testing.T, section, field, value string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for s.info(t, section, field) != value {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not %s after %s", field, value, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
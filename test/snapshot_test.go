@@ -0,0 +1,56 @@
+package test
+
+import (
+	"bytes"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	db := NewDatabase()
+	db.SetStr(NewStr("str"), NewStr("value"), time.Now().UnixNano()+int64(time.Hour))
+	list, _ := db.GetKeyObject(NewStr("list"), LINKDLIST)
+	list.Val.(*LinkedList).Rpush(NewStr("a"))
+	list.Val.(*LinkedList).Rpush(NewStr("b"))
+	set, _ := db.GetKeyObject(NewStr("set"), SET)
+	set.Val.(*Set).Add(NewStr("m"))
+	hash, _ := db.GetKeyObject(NewStr("hash"), HASH)
+	hash.Val.(*Hash).Set(NewStr("f"), NewStr("v"))
+	zset, _ := db.GetKeyObject(NewStr("zset"), ZSET)
+	zset.Val.(*Zset).AddMember(NewStr("z"), -5)
+
+	buffer := &bytes.Buffer{}
+	if err := persistence.WriteSnapshot(buffer, []*Database{db}); err != nil {
+		t.Fatalf("write snapshot error: %s", err)
+	}
+	data := buffer.Bytes()
+	loaded := NewDatabase()
+	err := persistence.ReadSnapshot(bytes.NewReader(data), func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		return loaded.SetKeyObject(key, val, expireTime)
+	})
+	if err != nil {
+		t.Fatalf("read snapshot error: %s", err)
+	}
+	if loaded.Size() != db.Size() {
+		t.Fatalf("expected %d keys, got %d", db.Size(), loaded.Size())
+	}
+	if str, _ := loaded.GetStr(NewStr("str")); str == nil || str.StrVal() != "value" {
+		t.Fatalf("string value mismatch")
+	}
+	obj, _ := loaded.GetKeyIfExist(NewStr("zset"), ZSET)
+	if score, _ := obj.Val.(*Zset).GetScore(NewStr("z")); score != -5 {
+		t.Fatalf("zset score mismatch, got %d", score)
+	}
+
+	// corrupted
+	data[len(data)-10] ^= 0xFF
+	err = persistence.ReadSnapshot(bytes.NewReader(data), func(dbIndex int, key, val *DbObject, expireTime int64) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("corrupted snapshot should not be loaded")
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/service"
+	"reflect"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	db := NewDatabase()
+	list, _ := db.GetKeyObject(NewStr("ids"), LINKDLIST)
+	for _, id := range []string{"3", "1", "10", "2"} {
+		list.Val.(*LinkedList).Rpush(NewStr(id))
+	}
+	for id, weight := range map[string]string{"1": "30", "2": "20", "3": "10"} {
+		db.SetStr(NewStr("weight_"+id), NewStr(weight), -1)
+		obj, _ := db.GetKeyObject(NewStr("obj_"+id), HASH)
+		obj.Val.(*Hash).Set(NewStr("name"), NewStr("name"+id))
+	}
+	sortBy := func(args ...string) []string {
+		objs := []*DbObject{NewStr("SORT_RO"), NewStr("ids")}
+		for _, arg := range args {
+			objs = append(objs, NewStr(arg))
+		}
+		return parseBulkArray(t, service.Handle(objs, db, nil))
+	}
+	cases := []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, []string{"1", "2", "3", "10"}},
+		{[]string{"DESC"}, []string{"10", "3", "2", "1"}},
+		{[]string{"ALPHA"}, []string{"1", "10", "2", "3"}},
+		{[]string{"LIMIT", "1", "2"}, []string{"2", "3"}},
+		{[]string{"LIMIT", "3", "-1"}, []string{"10"}},
+		// weight of 10 is missing, treated as 0
+		{[]string{"BY", "weight_*"}, []string{"10", "3", "2", "1"}},
+		// equal weights in ALPHA mode, elements are compared by themselves
+		{[]string{"BY", "missing_*", "ALPHA"}, []string{"1", "10", "2", "3"}},
+		// no '*', not sorted
+		{[]string{"BY", "nosort", "LIMIT", "0", "2"}, []string{"3", "1"}},
+		{[]string{"BY", "weight_*", "GET", "#", "GET", "obj_*->name", "LIMIT", "1", "2"}, []string{"3", "name3", "2", "name2"}},
+	}
+	for _, c := range cases {
+		if got := sortBy(c.args...); !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("SORT ids %v: expected %v, got %v", c.args, c.expected, got)
+		}
+	}
+	// missing values of GET are nil
+	reply := service.Handle([]*DbObject{NewStr("SORT_RO"), NewStr("ids"), NewStr("GET"), NewStr("obj_*->name")}, db, nil)
+	if reply != "*4\r\n$5\r\nname1\r\n$5\r\nname2\r\n$5\r\nname3\r\n$-1\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	// STORE is not allowed in SORT_RO
+	if reply = service.Handle([]*DbObject{NewStr("SORT_RO"), NewStr("ids"), NewStr("STORE"), NewStr("dst")}, db, nil); !service.IsErrorReply(reply) {
+		t.Fatalf("SORT_RO with STORE should be rejected")
+	}
+
+	// elements can not be converted to numbers
+	list.Val.(*LinkedList).Rpush(NewStr("a"))
+	if reply = service.Handle([]*DbObject{NewStr("SORT_RO"), NewStr("ids")}, db, nil); !service.IsErrorReply(reply) {
+		t.Fatalf("SORT of non-numeric elements should be rejected without ALPHA")
+	}
+	if got := sortBy("ALPHA", "DESC", "LIMIT", "0", "1"); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("unexpected result %v", got)
+	}
+
+	// set and zset
+	set, _ := db.GetKeyObject(NewStr("set"), SET)
+	zset, _ := db.GetKeyObject(NewStr("zset"), ZSET)
+	for i, member := range []string{"2", "3", "1"} {
+		set.Val.(*Set).Add(NewStr(member))
+		zset.Val.(*Zset).AddMember(NewStr(member), int64(i))
+	}
+	for _, key := range []string{"set", "zset"} {
+		got := parseBulkArray(t, service.Handle([]*DbObject{NewStr("SORT_RO"), NewStr(key)}, db, nil))
+		if !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+			t.Fatalf("SORT %s: unexpected result %v", key, got)
+		}
+	}
+	// zset elements are in order of score without sorting
+	got := parseBulkArray(t, service.Handle([]*DbObject{NewStr("SORT_RO"), NewStr("zset"), NewStr("BY"), NewStr("nosort")}, db, nil))
+	if !reflect.DeepEqual(got, []string{"2", "3", "1"}) {
+		t.Fatalf("SORT zset BY nosort: unexpected result %v", got)
+	}
+	// a key not exists is empty
+	if reply = service.Handle([]*DbObject{NewStr("SORT_RO"), NewStr("none")}, db, nil); reply != "*0\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	// keys of SORT include the destination of STORE
+	keys := service.CommandKeys([]*DbObject{NewStr("SORT"), NewStr("ids"), NewStr("LIMIT"), NewStr("0"), NewStr("1"), NewStr("STORE"), NewStr("dst")})
+	if len(keys) != 2 || keys[1].StrVal() != "dst" {
+		t.Fatalf("unexpected keys of SORT %v", keys)
+	}
+	if args := service.AppendOnlyArgs([]*DbObject{NewStr("SORT"), NewStr("ids")}, nil); len(args) != 0 {
+		t.Fatalf("SORT without STORE should not be written to AOF")
+	}
+}
@@ -0,0 +1,175 @@
+package test
+
+import (
+	"fmt"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/service"
+	"testing"
+	"time"
+)
+
+func TestSetCommand(t *testing.T) {
+	db := NewDatabase()
+	server := newFakeServer(db)
+	// expire time of key in ms, -1 if never expires
+	expireMs := func(key string) int64 {
+		expireTime := db.GetExpireTime(NewStr(key))
+		if expireTime < 0 {
+			return expireTime
+		}
+		return expireTime / int64(time.Millisecond)
+	}
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	cases := []struct {
+		args []string
+		// expected expire time in [min, min+1000]
+		min int64
+	}{
+		{[]string{"EX", "100"}, nowMs + 100000},
+		{[]string{"PX", "100000"}, nowMs + 100000},
+		{[]string{"EXAT", fmt.Sprint(nowMs/1000 + 100)}, (nowMs/1000 + 100) * 1000},
+		{[]string{"PXAT", fmt.Sprint(nowMs + 100000)}, nowMs + 100000},
+	}
+	for _, c := range cases {
+		if reply := server.handle(append([]string{"SET", "k", "v"}, c.args...)...); reply != "+Query OK\r\n" {
+			t.Fatalf("SET k v %v: unexpected reply %q", c.args, reply)
+		}
+		if got := expireMs("k"); got < c.min || got > c.min+1000 {
+			t.Fatalf("SET k v %v: expire time %d, expected about %d", c.args, got, c.min)
+		}
+	}
+	// KEEPTTL keeps the expire time, SET without options removes it
+	expireTime := expireMs("k")
+	server.handle("SET", "k", "v2", "KEEPTTL")
+	if got := expireMs("k"); got != expireTime {
+		t.Fatalf("KEEPTTL: expire time %d, expected %d", got, expireTime)
+	}
+	server.handle("SET", "k", "v3")
+	if got := expireMs("k"); got != -1 {
+		t.Fatalf("SET should remove the expire time, got %d", got)
+	}
+	for _, args := range [][]string{{"EX", "0"}, {"EX", "10", "PX", "10"}, {"EX", "10", "KEEPTTL"}, {"NX", "XX"}, {"PX", "a"}} {
+		if reply := server.handle(append([]string{"SET", "k", "v"}, args...)...); !service.IsErrorReply(reply) {
+			t.Fatalf("SET k v %v should be rejected", args)
+		}
+	}
+
+	// NX and XX
+	dirty := server.dirty
+	if reply := server.handle("SET", "k", "v4", "NX"); reply != "$-1\r\n" {
+		t.Fatalf("SET NX of an existing key: unexpected reply %q", reply)
+	}
+	if reply := server.handle("SET", "none", "v", "XX"); reply != "$-1\r\n" {
+		t.Fatalf("SET XX of a key not exists: unexpected reply %q", reply)
+	}
+	if server.dirty != dirty {
+		t.Fatalf("failed SET should not change dirty")
+	}
+	if reply := server.handle("SET", "k", "v4", "XX"); reply != "+Query OK\r\n" {
+		t.Fatalf("SET XX of an existing key: unexpected reply %q", reply)
+	}
+	if reply := server.handle("SET", "lock", "v", "NX", "PX", "30000"); reply != "+Query OK\r\n" {
+		t.Fatalf("SET NX of a key not exists: unexpected reply %q", reply)
+	}
+	if str, _ := db.GetStr(NewStr("k")); str == nil || str.StrVal() != "v4" {
+		t.Fatalf("k should be v4")
+	}
+	if exist, _ := db.Exist(NewStr("none")); exist {
+		t.Fatalf("none should not be set")
+	}
+
+	// GET
+	if reply := server.handle("SET", "new", "v", "GET"); reply != "$-1\r\n" {
+		t.Fatalf("SET GET of a key not exists: unexpected reply %q", reply)
+	}
+	if reply := server.handle("SET", "new", "v2", "NX", "GET"); reply != "$1\r\nv\r\n" {
+		t.Fatalf("SET NX GET of an existing key: unexpected reply %q", reply)
+	}
+	db.GetKeyObject(NewStr("list"), LINKDLIST)
+	if reply := server.handle("SET", "list", "v", "GET"); !service.IsErrorReply(reply) {
+		t.Fatalf("SET GET of a list should be rejected")
+	}
+	if val, _ := db.GetKey(NewStr("list")); val == nil || val.Type != LINKDLIST {
+		t.Fatalf("list should not be overwritten")
+	}
+	// without GET a key of any type is overwritten
+	db.GetKeyObject(NewStr("hash"), HASH)
+	for _, key := range []string{"list", "hash"} {
+		if reply := server.handle("SET", key, "v"); reply != "+Query OK\r\n" {
+			t.Fatalf("SET of %s: unexpected reply %q", key, reply)
+		}
+		if str, _ := db.GetStr(NewStr(key)); str == nil || str.StrVal() != "v" {
+			t.Fatalf("%s should be overwritten", key)
+		}
+	}
+}
+
+func TestSetexCommand(t *testing.T) {
+	db := NewDatabase()
+	server := newFakeServer(db)
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	if reply := server.handle("SETEX", "k", "10", "v"); reply != "+Query OK\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	// seconds are relative to now
+	if str, _ := db.GetStr(NewStr("k")); str == nil || str.StrVal() != "v" {
+		t.Fatalf("k expired immediately")
+	}
+	if got := db.GetExpireTime(NewStr("k")) / int64(time.Millisecond); got < nowMs+10000 || got > nowMs+11000 {
+		t.Fatalf("expire time %d, expected about %d", got, nowMs+10000)
+	}
+	if reply := server.handle("SETEX", "k", "0", "v"); !service.IsErrorReply(reply) {
+		t.Fatalf("SETEX with 0 seconds should be rejected")
+	}
+}
+
+func TestPropagateSet(t *testing.T) {
+	db, replayed := NewDatabase(), NewDatabase()
+	server := newFakeServer(db)
+	for _, args := range [][]string{{"SET", "a", "v", "EX", "100"}, {"SET", "b", "v", "NX", "PX", "100000", "GET"}, {"SETEX", "c", "100", "v"}} {
+		objs := make([]*DbObject, 0, len(args))
+		for _, arg := range args {
+			objs = append(objs, NewStr(arg))
+		}
+		service.Handle(objs, db, server)
+		// relative expire time is written as PXAT
+		propagated := service.AppendOnlyArgs(objs, server)
+		if propagated[0].StrVal() != "SET" || len(propagated) < 5 {
+			t.Fatalf("%v: unexpected propagated args %v", args, propagated)
+		}
+		if reply := service.Handle(propagated, replayed, newFakeServer(replayed)); service.IsErrorReply(reply) {
+			t.Fatalf("%v: replay error %q", args, reply)
+		}
+		// the absolute time written is the one applied
+		key := NewStr(args[1])
+		if expected, got := db.GetExpireTime(key), replayed.GetExpireTime(key); got != expected {
+			t.Fatalf("%v: expire time of replayed key %d, expected %d", args, got, expected)
+		}
+	}
+}
+
+func TestPropagateExpire(t *testing.T) {
+	db, replayed := NewDatabase(), NewDatabase()
+	server := newFakeServer(db)
+	for _, args := range [][]string{{"EXPIRE", "a", "100"}, {"PEXPIRE", "b", "100000", "NX"}, {"EXPIREAT", "c", fmt.Sprint(time.Now().Unix() + 100)}} {
+		key := NewStr(args[1])
+		db.SetStr(key, NewStr("v"), -1)
+		replayed.SetStr(key, NewStr("v"), -1)
+		objs := make([]*DbObject, 0, len(args))
+		for _, arg := range args {
+			objs = append(objs, NewStr(arg))
+		}
+		if reply := service.Handle(objs, db, server); reply != ":1\r\n" {
+			t.Fatalf("%v: unexpected reply %q", args, reply)
+		}
+		propagated := service.AppendOnlyArgs(objs, server)
+		if propagated[0].StrVal() != "PEXPIREAT" || len(propagated) != len(args) {
+			t.Fatalf("%v: unexpected propagated args %v", args, propagated)
+		}
+		service.Handle(propagated, replayed, newFakeServer(replayed))
+		if expected, got := db.GetExpireTime(key), replayed.GetExpireTime(key); got != expected {
+			t.Fatalf("%v: expire time of replayed key %d, expected %d", args, got, expected)
+		}
+	}
+}
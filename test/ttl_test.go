@@ -0,0 +1,67 @@
+package test
+
+import (
+	"bytes"
+	. "goRedis/data_structure"
+	. "goRedis/db"
+	"goRedis/persistence"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistentByDefault(t *testing.T) {
+	db := NewDatabase()
+	db.SetStr(NewStr("str"), NewStr("value"), -1)
+	db.GetKeyObject(NewStr("list"), LINKDLIST)
+	if db.GetExpireTime(NewStr("str")) != -1 || db.GetExpireTime(NewStr("list")) != -1 {
+		t.Fatalf("new keys should never expire")
+	}
+	// remove and rename keys without expire time
+	if err := db.RenameKey(NewStr("str"), NewStr("renamed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveKey(NewStr("list")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetExpireAndPersist(t *testing.T) {
+	db := NewDatabase()
+	key := NewStr("key")
+	if err := db.SetExpire(key, time.Now().Add(time.Hour).UnixNano()); err == nil {
+		t.Fatalf("expire time set on a key not exists")
+	}
+	db.SetStr(key, NewStr("value"), -1)
+	expireTime := time.Now().Add(time.Hour).UnixNano()
+	if err := db.SetExpire(key, expireTime); err != nil {
+		t.Fatal(err)
+	}
+	// rename keeps the expire time
+	db.RenameKey(key, NewStr("renamed"))
+	key = NewStr("renamed")
+	if db.GetExpireTime(key) != expireTime {
+		t.Fatalf("expire time lost after rename")
+	}
+	// the old expire time is discarded by SET
+	db.SetStr(key, NewStr("new"), -1)
+	if db.GetExpireTime(key) != -1 {
+		t.Fatalf("expire time kept after set")
+	}
+	db.SetExpire(key, expireTime)
+	if !db.Persist(key) || db.Persist(key) || db.GetExpireTime(key) != -1 {
+		t.Fatalf("persist failed")
+	}
+}
+
+func TestAofRewriteExpireTime(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	encoder := persistence.NewAofRewriteEncoder(buffer)
+	encoder.WriteEntry(NewStr("volatile"), NewStr("value"), 1700000000123*int64(time.Millisecond))
+	encoder.WriteEntry(NewStr("persistent"), NewStr("value"), -1)
+	encoder.Flush()
+	aof := buffer.String()
+	if !strings.Contains(aof, "PEXPIREAT\r\n$8\r\nvolatile\r\n$13\r\n1700000000123\r\n") || strings.Count(aof, "PEXPIREAT") != 1 {
+		t.Fatalf("unexpected rewrite result %q", aof)
+	}
+}
@@ -0,0 +1,156 @@
+package util
+
+// glob-style pattern matching, used by KEYS and MATCH options
+// *      any sequence of bytes
+// ?      any single byte
+// [abc]  one of the bytes, [^abc] none of them, [a-z] a range
+// \x     escape x
+// patterns and strings are matched byte by byte, so both of them can be any binary string
+
+type globTokenType uint8
+
+const (
+	globLiteral globTokenType = iota
+	globAny
+	globClass
+	globStar
+)
+
+type globToken struct {
+	typ globTokenType
+	// globLiteral
+	literal byte
+	// globClass, bytes matched
+	class *[256]bool
+}
+
+// GlobPattern
+// a compiled pattern, can be reused to match many strings
+type GlobPattern struct {
+	tokens []globToken
+	// pattern is "*" (or stars only), matches everything
+	matchAll bool
+}
+
+// CompileGlob
+// compile pattern, every byte sequence is a valid pattern
+// an unclosed class is closed by the end of pattern, a trailing '\' matches itself
+func CompileGlob(pattern string) *GlobPattern {
+	tokens := make([]globToken, 0, len(pattern))
+	for i := 0; i < len(pattern); i += 1 {
+		switch pattern[i] {
+		case '*':
+			// consecutive stars are the same as one
+			if len(tokens) == 0 || tokens[len(tokens)-1].typ != globStar {
+				tokens = append(tokens, globToken{typ: globStar})
+			}
+		case '?':
+			tokens = append(tokens, globToken{typ: globAny})
+		case '[':
+			var class *[256]bool
+			class, i = compileClass(pattern, i+1)
+			tokens = append(tokens, globToken{typ: globClass, class: class})
+		case '\\':
+			if i+1 < len(pattern) {
+				i += 1
+			}
+			fallthrough
+		default:
+			tokens = append(tokens, globToken{typ: globLiteral, literal: pattern[i]})
+		}
+	}
+	return &GlobPattern{
+		tokens:   tokens,
+		matchAll: len(tokens) == 1 && tokens[0].typ == globStar,
+	}
+}
+
+// compileClass
+// compile the class starting at i (after '['), return bytes matched and the index of its ']'
+func compileClass(pattern string, i int) (*[256]bool, int) {
+	class := &[256]bool{}
+	not := i < len(pattern) && pattern[i] == '^'
+	if not {
+		i += 1
+	}
+	for ; i < len(pattern) && pattern[i] != ']'; i += 1 {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i += 1
+			class[pattern[i]] = true
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			start, end := int(pattern[i]), int(pattern[i+2])
+			if start > end {
+				start, end = end, start
+			}
+			for c := start; c <= end; c += 1 {
+				class[c] = true
+			}
+			i += 2
+		default:
+			class[pattern[i]] = true
+		}
+	}
+	if not {
+		for c := range class {
+			class[c] = !class[c]
+		}
+	}
+	return class, i
+}
+
+func (token *globToken) matchByte(c byte) bool {
+	switch token.typ {
+	case globAny:
+		return true
+	case globClass:
+		return token.class[c]
+	}
+	return token.literal == c
+}
+
+// Match
+// judge whether s matches the pattern
+// every token except '*' matches exactly one byte, so when a later token fails only the last '*'
+// needs to extend its match, the time is O(len(pattern) * len(s)) in the worst case instead of
+// exponential (e.g. "a*a*a*a*b" against a long string of 'a')
+func (pattern *GlobPattern) Match(s string) bool {
+	if pattern.matchAll {
+		return true
+	}
+	tokens := pattern.tokens
+	p, i := 0, 0
+	// position after the last star and where its match ends, -1 if no star is met
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(tokens) && tokens[p].typ == globStar {
+			starP, starI = p+1, i
+			p += 1
+			continue
+		}
+		if p < len(tokens) && tokens[p].matchByte(s[i]) {
+			p += 1
+			i += 1
+			continue
+		}
+		if starP < 0 {
+			return false
+		}
+		// the last star matches one more byte
+		starI += 1
+		p, i = starP, starI
+	}
+	// the rest of pattern must be stars
+	for ; p < len(tokens); p += 1 {
+		if tokens[p].typ != globStar {
+			return false
+		}
+	}
+	return true
+}
+
+// StringMatch
+// judge whether s matches pattern, compile the pattern with CompileGlob to match many strings
+func StringMatch(pattern, s string) bool {
+	return CompileGlob(pattern).Match(s)
+}
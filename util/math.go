@@ -0,0 +1,10 @@
+package util
+
+// MinInt
+// the smaller one of a and b
+func MinInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,6 +0,0 @@
-package util
-
-// 加入所有TimeEvent
-func init() {
-
-}